@@ -0,0 +1,66 @@
+package sqflite
+
+import (
+	"encoding/binary"
+	"math"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+// sqlArgument converts an SQL argument decoded by the message codec to the
+// value bound by the driver. Integers are bound as int64, Dart sending the
+// ones above 2^31 as int64 and the ones beyond as big integers, booleans as 1
+// or 0, and lists of bytes as BLOBs, as on Android. Typed lists are bound as
+// BLOBs of their bytes in little endian, a List<int> as a BLOB if its values
+// are bytes.
+func sqlArgument(arg interface{}) (interface{}, error) {
+	switch v := arg.(type) {
+	case bool:
+		if v {
+			return int64(1), nil
+		}
+		return int64(0), nil
+	case int32:
+		return int64(v), nil
+	case *big.Int:
+		if !v.IsInt64() {
+			return nil, errors.Errorf("integer argument %s overflows int64", v)
+		}
+		return v.Int64(), nil
+	case []int32:
+		b := make([]byte, len(v)*4)
+		for i, n := range v {
+			binary.LittleEndian.PutUint32(b[i*4:], uint32(n))
+		}
+		return b, nil
+	case []int64:
+		b := make([]byte, len(v)*8)
+		for i, n := range v {
+			binary.LittleEndian.PutUint64(b[i*8:], uint64(n))
+		}
+		return b, nil
+	case []float64:
+		b := make([]byte, len(v)*8)
+		for i, f := range v {
+			binary.LittleEndian.PutUint64(b[i*8:], math.Float64bits(f))
+		}
+		return b, nil
+	case []interface{}:
+		return byteList(v)
+	}
+	return arg, nil
+}
+
+// byteList returns the values of a List<int> argument as bytes
+func byteList(list []interface{}) ([]byte, error) {
+	b := make([]byte, len(list))
+	for i, v := range list {
+		n, ok := v.(int32)
+		if !ok || n < 0 || n > math.MaxUint8 {
+			return nil, errors.Errorf("list argument value %v is not a byte", v)
+		}
+		b[i] = byte(n)
+	}
+	return b, nil
+}
@@ -0,0 +1,56 @@
+package sqflite
+
+import (
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestIntegerArgumentsRoundTrip(t *testing.T) {
+	p := newTestPlugin(t)
+	id := openTestDatabase(t, p, "args.db", nil)
+	mustCall(t, p.handleExecute, sqlArgs(id, "CREATE TABLE t (x INTEGER)"))
+	for _, arg := range []interface{}{
+		int64(math.MaxInt64),
+		int64(math.MinInt64),
+		big.NewInt(math.MaxInt64),
+		big.NewInt(math.MinInt64),
+		int32(math.MaxInt32),
+		int32(math.MinInt32),
+	} {
+		want, err := sqlArgument(arg)
+		if err != nil {
+			t.Fatalf("sqlArgument(%v): %v", arg, err)
+		}
+		mustCall(t, p.handleExecute, sqlArgs(id, "DELETE FROM t"))
+		mustCall(t, p.handleInsert, sqlArgs(id, "INSERT INTO t VALUES (?)", arg))
+		if v := queryValue(t, p, id, "SELECT x FROM t"); v != want {
+			t.Errorf("%T %v read back as %T %v", arg, arg, v, v)
+		}
+		if v := queryValue(t, p, id, "SELECT count(*) FROM t WHERE x = ?", arg); v != int64(1) {
+			t.Errorf("%T %v not matched by its value", arg, arg)
+		}
+	}
+}
+
+func TestBigIntArgumentOverflow(t *testing.T) {
+	p := newTestPlugin(t)
+	id := openTestDatabase(t, p, "args.db", nil)
+	mustCall(t, p.handleExecute, sqlArgs(id, "CREATE TABLE t (x INTEGER)"))
+	one := big.NewInt(1)
+	for _, arg := range []*big.Int{
+		new(big.Int).Add(big.NewInt(math.MaxInt64), one),
+		new(big.Int).Sub(big.NewInt(math.MinInt64), one),
+		new(big.Int).Lsh(one, 100),
+	} {
+		if _, err := sqlArgument(arg); err == nil {
+			t.Errorf("sqlArgument(%s) did not fail", arg)
+		}
+		if _, err := p.handleInsert(sqlArgs(id, "INSERT INTO t VALUES (?)", arg)); err == nil {
+			t.Errorf("insert of %s did not fail", arg)
+		}
+	}
+	if v := queryValue(t, p, id, "SELECT count(*) FROM t"); v != int64(0) {
+		t.Errorf("count = %v, want 0", v)
+	}
+}
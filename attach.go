@@ -0,0 +1,209 @@
+package sqflite
+
+import (
+	"context"
+	"database/sql/driver"
+	"strings"
+	"sync"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// connAttachments are the databases attached to a database by alias, attached
+// again on each of its new connections in the order they were attached
+type connAttachments struct {
+	sync.Mutex
+	aliases []string
+	paths   map[string]string
+}
+
+func newConnAttachments() *connAttachments {
+	return &connAttachments{paths: make(map[string]string)}
+}
+
+func (c *connAttachments) add(alias, path string) {
+	c.Lock()
+	defer c.Unlock()
+	c.aliases = append(c.aliases, alias)
+	c.paths[alias] = path
+}
+
+func (c *connAttachments) remove(alias string) {
+	c.Lock()
+	defer c.Unlock()
+	for i, a := range c.aliases {
+		if a == alias {
+			c.aliases = append(c.aliases[:i], c.aliases[i+1:]...)
+			break
+		}
+	}
+	delete(c.paths, alias)
+}
+
+func (c *connAttachments) has(alias string) bool {
+	c.Lock()
+	defer c.Unlock()
+	_, ok := c.paths[alias]
+	return ok
+}
+
+// attach attaches the databases on conn
+func (c *connAttachments) attach(conn *sqlite3.SQLiteConn) error {
+	if c == nil {
+		return nil
+	}
+	c.Lock()
+	defer c.Unlock()
+	for _, alias := range c.aliases {
+		if _, err := conn.Exec("ATTACH DATABASE ? AS "+quoteIdentifier(alias), []driver.Value{c.paths[alias]}); err != nil {
+			return errors.Wrap(err, "failed to attach "+c.paths[alias]+" as "+alias)
+		}
+	}
+	return nil
+}
+
+// getAttachAlias returns the valid PARAM_ALIAS argument
+func getAttachAlias(args map[interface{}]interface{}) (string, error) {
+	alias, _ := args[PARAM_ALIAS].(string)
+	if !pragmaName.MatchString(alias) {
+		return "", errors.Errorf("invalid alias %q", alias)
+	}
+	switch strings.ToLower(alias) {
+	case "main", "temp":
+		return "", errors.Errorf("invalid alias %s, reserved by sqlite", alias)
+	}
+	return alias, nil
+}
+
+// handleAttachDatabase attaches the database file PARAM_PATH, absolute or
+// relative to the databases folder, to the database PARAM_ID as PARAM_ALIAS,
+// on its connection and its read pool, until detached or closed. Its tables
+// are then read and written as alias.table, joining the tables of both, and a
+// transaction writing to both commits on both files or neither, as long as
+// they use a rollback journal. Otherwise a WARNING_NOT_ATOMIC warning is sent,
+// or the attach fails with PARAM_ATOMIC.
+func (p *SqflitePlugin) handleAttachDatabase(arguments interface{}) (reply interface{}, err error) {
+	databaseId, db, err := p.getDatabase(arguments)
+	if err != nil {
+		return nil, err
+	}
+	args := arguments.(map[interface{}]interface{})
+	alias, err := getAttachAlias(args)
+	if err != nil {
+		return nil, err
+	}
+	dbPath, _ := args[PARAM_PATH].(string)
+	if dbPath == "" {
+		return nil, errors.New("invalid path")
+	}
+	// each connection would attach its own in-memory database
+	if dbPath == MEMORY_DATABASE_PATH {
+		return nil, errors.New("cannot attach an in-memory database")
+	}
+	// the driver is built without SQLCipher, see handleOpenDatabase
+	if password, _ := args[PARAM_PASSWORD].(string); password != "" {
+		return nil, errors.New("cannot attach " + dbPath + " with a password, database encryption is not supported")
+	}
+	dbPath = p.resolvePath(dbPath)
+	p.Lock()
+	attachments := p.attachments[databaseId]
+	p.Unlock()
+	if attachments.has(alias) {
+		return nil, errors.Errorf("a database is already attached as %s", alias)
+	}
+	ctx := p.ctx
+	exec := p.executor(databaseId, db, arguments)
+	if _, err = exec.ExecContext(ctx, "ATTACH DATABASE ? AS "+quoteIdentifier(alias), dbPath); err != nil {
+		return nil, err
+	}
+	if modes, err := atomicCommitModes(ctx, exec, alias); err != nil || modes != "" {
+		if err == nil {
+			err = errors.Errorf("transactions writing to both databases are not atomic across them, %s", modes)
+		}
+		if requireAtomic, _ := args[PARAM_ATOMIC].(bool); requireAtomic {
+			exec.ExecContext(ctx, "DETACH DATABASE "+quoteIdentifier(alias))
+			return nil, errors.Wrap(err, "cannot attach "+dbPath+" as "+alias)
+		}
+		p.Lock()
+		mainPath := p.databasePaths[databaseId]
+		p.Unlock()
+		p.warn(WARNING_NOT_ATOMIC, mainPath, errors.Wrap(err, "attached "+alias).Error())
+	}
+	attachments.add(alias, dbPath)
+	p.resetAttachments(databaseId)
+	return nil, nil
+}
+
+// atomicCommitModes returns the journal modes of the main database and of the
+// database attached as alias preventing sqlite from committing a transaction
+// writing to both atomically with a super-journal, or "" when they do not.
+// A database in WAL mode, or with its journal in memory or disabled, only
+// commits atomically on its own file.
+func atomicCommitModes(ctx context.Context, exec executor, alias string) (string, error) {
+	var modes []string
+	for _, schema := range []string{"main", alias} {
+		var mode string
+		rows, err := exec.QueryContext(ctx, "PRAGMA "+quoteIdentifier(schema)+".journal_mode")
+		if err != nil {
+			return "", err
+		}
+		if rows.Next() {
+			err = rows.Scan(&mode)
+		}
+		rows.Close()
+		if err != nil {
+			return "", err
+		}
+		switch strings.ToLower(mode) {
+		case "delete", "truncate", "persist":
+		default:
+			modes = append(modes, schema+" is in journal mode "+mode)
+		}
+	}
+	return strings.Join(modes, ", "), nil
+}
+
+// handleDetachDatabase detaches the database attached as PARAM_ALIAS from the
+// database PARAM_ID
+func (p *SqflitePlugin) handleDetachDatabase(arguments interface{}) (reply interface{}, err error) {
+	databaseId, db, err := p.getDatabase(arguments)
+	if err != nil {
+		return nil, err
+	}
+	alias, err := getAttachAlias(arguments.(map[interface{}]interface{}))
+	if err != nil {
+		return nil, err
+	}
+	p.Lock()
+	attachments := p.attachments[databaseId]
+	p.Unlock()
+	if !attachments.has(alias) {
+		return nil, errors.Errorf("no database attached as %s", alias)
+	}
+	exec := p.executor(databaseId, db, arguments)
+	if _, err = exec.ExecContext(p.ctx, "DETACH DATABASE "+quoteIdentifier(alias)); err != nil {
+		return nil, err
+	}
+	attachments.remove(alias)
+	p.resetAttachments(databaseId)
+	return nil, nil
+}
+
+// resetAttachments clears the cached query results of databaseId, and
+// replaces its read pool, whose connections attach its current databases. The
+// queries running on the previous pool complete before it closes.
+func (p *SqflitePlugin) resetAttachments(databaseId int32) {
+	if cache := p.getQueryCache(databaseId); cache != nil {
+		cache.clear()
+	}
+	p.Lock()
+	pool, reopen := p.readPools[databaseId], p.readPoolOpeners[databaseId]
+	if pool != nil && reopen != nil {
+		p.readPools[databaseId] = reopen()
+	}
+	p.Unlock()
+	if pool != nil && reopen != nil {
+		pool.Close()
+	}
+}
@@ -0,0 +1,94 @@
+package sqflite
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+// countRows returns the number of rows of table in the database file dbPath,
+// read on a connection of its own
+func countRows(t *testing.T, dbPath, table string) int64 {
+	t.Helper()
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var count int64
+	if err = db.QueryRow("SELECT count(*) FROM " + table).Scan(&count); err != nil {
+		t.Fatalf("count %s of %s: %v", table, dbPath, err)
+	}
+	return count
+}
+
+func TestAttachedTransaction(t *testing.T) {
+	p := newTestPlugin(t)
+	id := openTestDatabase(t, p, "main.db", map[interface{}]interface{}{
+		PARAM_JOURNAL_MODE: "DELETE",
+	})
+	mainPath := p.resolvePath("main.db")
+	otherPath := filepath.Join(t.TempDir(), "other.db")
+	mustCall(t, p.handleExecute, sqlArgs(id, "CREATE TABLE t (x)"))
+	mustCall(t, p.handleAttachDatabase, map[interface{}]interface{}{
+		PARAM_ID:     id,
+		PARAM_PATH:   otherPath,
+		PARAM_ALIAS:  "other",
+		PARAM_ATOMIC: true,
+	})
+	mustCall(t, p.handleExecute, sqlArgs(id, "CREATE TABLE other.t (x)"))
+
+	// runs a Dart transaction inserting in both databases, ended by end
+	transaction := func(end string) {
+		begin := sqlArgs(id, "BEGIN IMMEDIATE")
+		begin[PARAM_IN_TRANSACTION] = true
+		reply := mustCall(t, p.handleExecute, begin)
+		txId := reply.(map[interface{}]interface{})[PARAM_TRANSACTION_ID]
+		for _, sqlStr := range []string{"INSERT INTO main.t VALUES (1)", "INSERT INTO other.t VALUES (1)"} {
+			insert := sqlArgs(id, sqlStr)
+			insert[PARAM_TRANSACTION_ID] = txId
+			mustCall(t, p.handleInsert, insert)
+		}
+		commit := sqlArgs(id, end)
+		commit[PARAM_IN_TRANSACTION] = false
+		commit[PARAM_TRANSACTION_ID] = txId
+		mustCall(t, p.handleExecute, commit)
+	}
+
+	transaction("COMMIT")
+	if n := countRows(t, mainPath, "t"); n != 1 {
+		t.Errorf("main.db has %d rows after commit, want 1", n)
+	}
+	if n := countRows(t, otherPath, "t"); n != 1 {
+		t.Errorf("other.db has %d rows after commit, want 1", n)
+	}
+
+	transaction("ROLLBACK")
+	if n := countRows(t, mainPath, "t"); n != 1 {
+		t.Errorf("main.db has %d rows after rollback, want 1", n)
+	}
+	if n := countRows(t, otherPath, "t"); n != 1 {
+		t.Errorf("other.db has %d rows after rollback, want 1", n)
+	}
+	if v := queryValue(t, p, id, "SELECT (SELECT count(*) FROM main.t) + (SELECT count(*) FROM other.t)"); v != int64(2) {
+		t.Errorf("rows seen by the database = %v, want 2", v)
+	}
+}
+
+func TestAttachAtomicWAL(t *testing.T) {
+	p := newTestPlugin(t)
+	id := openTestDatabase(t, p, "main.db", map[interface{}]interface{}{
+		PARAM_JOURNAL_MODE: "WAL",
+	})
+	if _, err := p.handleAttachDatabase(map[interface{}]interface{}{
+		PARAM_ID:     id,
+		PARAM_PATH:   filepath.Join(t.TempDir(), "other.db"),
+		PARAM_ALIAS:  "other",
+		PARAM_ATOMIC: true,
+	}); err == nil {
+		t.Error("attached with atomic to a database in WAL mode")
+	}
+	if v := queryValue(t, p, id, "SELECT count(*) FROM pragma_database_list WHERE name = 'other'"); v != int64(0) {
+		t.Error("database left attached")
+	}
+}
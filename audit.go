@@ -0,0 +1,275 @@
+package sqflite
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// table of the audit trail
+const auditTable = "_audit_log"
+
+// current time in ms since epoch, in core SQL, for the audit triggers to run
+// on any connection
+const auditTimeExpr = "CAST((julianday('now') - 2440587.5) * 86400000 AS INTEGER)"
+
+// handleEnableAudit creates the audit trail table and, for each table of
+// PARAM_TABLES, triggers logging the old and new values of the rows as JSON on
+// insert, update and delete. Calling it again after a table schema changed
+// recreates the triggers. The triggers only use core SQL, so the tables can be
+// written by other tools, and recreating them replaces the ones of the earlier
+// versions, which called the json_row() and now_ms() functions of the plugin.
+// Within the open transaction of the database, the changes are committed or
+// rolled back with it.
+func (p *SqflitePlugin) handleEnableAudit(arguments interface{}) (reply interface{}, err error) {
+	databaseId, db, err := p.getDatabase(arguments)
+	if err != nil {
+		return nil, err
+	}
+	tables, err := getTableList(arguments.(map[interface{}]interface{})[PARAM_TABLES])
+	if err != nil {
+		return nil, err
+	}
+	ctx := p.ctx
+	exec, tx, err := p.beginExecutor(databaseId, db, arguments)
+	if err != nil {
+		return nil, err
+	}
+	if tx != nil {
+		defer tx.Rollback()
+	}
+	if _, err = exec.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+auditTable+` (
+		id INTEGER PRIMARY KEY,
+		table_name TEXT NOT NULL,
+		operation TEXT NOT NULL,
+		row_key TEXT,
+		old_values TEXT,
+		new_values TEXT,
+		time INTEGER NOT NULL)`); err != nil {
+		return nil, errors.Wrap(err, "failed to create audit table")
+	}
+	if _, err = exec.ExecContext(ctx, "CREATE INDEX IF NOT EXISTS "+auditTable+"_table ON "+auditTable+" (table_name, time)"); err != nil {
+		return nil, err
+	}
+	for _, table := range tables {
+		cols, err := tableColumns(ctx, exec, "main", table)
+		if err != nil {
+			return nil, err
+		}
+		key, err := primaryKeyColumns(ctx, exec, table)
+		if err != nil {
+			return nil, err
+		}
+		if len(key) == 0 {
+			key = []string{"rowid"}
+		}
+		entry := func(operation, keyRow, oldRow, newRow string) string {
+			return fmt.Sprintf("INSERT INTO %s (table_name, operation, row_key, old_values, new_values, time) VALUES (%s, %s, %s, %s, %s, %s); %s",
+				auditTable, quoteString(table), quoteString(operation), jsonObjectExpr(key, keyRow), jsonObjectExpr(cols, oldRow), jsonObjectExpr(cols, newRow), auditTimeExpr,
+				escapeControlChars(auditTable, auditJSONColumns, "id = last_insert_rowid()"))
+		}
+		triggers := map[string]string{
+			"insert": fmt.Sprintf("AFTER INSERT ON %s BEGIN %s END", quoteIdentifier(table), entry("insert", "NEW.", "", "NEW.")),
+			"update": fmt.Sprintf("AFTER UPDATE ON %s BEGIN %s END", quoteIdentifier(table), entry("update", "NEW.", "OLD.", "NEW.")),
+			"delete": fmt.Sprintf("AFTER DELETE ON %s BEGIN %s END", quoteIdentifier(table), entry("delete", "OLD.", "OLD.", "")),
+		}
+		for event, body := range triggers {
+			name := quoteIdentifier(table + "_audit_" + event)
+			if _, err = exec.ExecContext(ctx, "DROP TRIGGER IF EXISTS "+name); err != nil {
+				return nil, err
+			}
+			if _, err = exec.ExecContext(ctx, fmt.Sprintf("CREATE TRIGGER %s %s", name, body)); err != nil {
+				return nil, errors.Wrap(err, "failed to create audit trigger")
+			}
+		}
+	}
+	if tx != nil {
+		if err = tx.Commit(); err != nil {
+			return nil, err
+		}
+	}
+	return map[interface{}]interface{}{
+		PARAM_TABLE: auditTable,
+	}, nil
+}
+
+// handleQueryAuditLog returns the entries of the audit trail, oldest first, in
+// the format of a query. They can be filtered on PARAM_TABLE and on the time
+// (ms since epoch) with PARAM_TIME, and limited to PARAM_LIMIT entries.
+func (p *SqflitePlugin) handleQueryAuditLog(arguments interface{}) (reply interface{}, err error) {
+	databaseId, db, err := p.getDatabase(arguments)
+	if err != nil {
+		return nil, err
+	}
+	args := arguments.(map[interface{}]interface{})
+	where := []string{"1"}
+	var whereArgs []interface{}
+	if table, _ := args[PARAM_TABLE].(string); table != "" {
+		where = append(where, "table_name = ?")
+		whereArgs = append(whereArgs, table)
+	}
+	if since, ok := args[PARAM_TIME]; ok && since != nil {
+		if _, ok = toFloat(since); !ok {
+			return nil, errors.Errorf("invalid time %v", since)
+		}
+		where = append(where, "time >= ?")
+		whereArgs = append(whereArgs, since)
+	}
+	limit := -1
+	if l, ok := args[PARAM_LIMIT]; ok && l != nil {
+		f, ok := toFloat(l)
+		if !ok || f < 0 {
+			return nil, errors.Errorf("invalid limit %v", l)
+		}
+		limit = int(f)
+	}
+	cols := []string{"id", "table_name", "operation", "row_key", "old_values", "new_values", "time"}
+	ctx := p.ctx
+	rows, err := queryRowMaps(ctx, p.executor(databaseId, db, arguments), fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY id LIMIT %d",
+		strings.Join(cols, ", "), auditTable, strings.Join(where, " AND "), limit), cols, whereArgs...)
+	if err != nil {
+		return nil, err
+	}
+	return rowMapsReply(cols, rows), nil
+}
+
+// jsonObjectExpr returns the core SQL expression encoding the given columns of
+// row (NEW. or OLD.) as a JSON object, or NULL for no row. The JSON1 functions
+// are not always built in, the object is concatenated instead: blobs are hex
+// encoded and infinite numbers are null.
+func jsonObjectExpr(cols []string, row string) string {
+	if row == "" {
+		return "NULL"
+	}
+	var members []string
+	for _, c := range cols {
+		name, _ := json.Marshal(c)
+		members = append(members, quoteString(string(name)+":")+" || "+jsonValueExpr(row+quoteIdentifier(c)))
+	}
+	return "'{' || " + strings.Join(members, " || ',' || ") + " || '}'"
+}
+
+// jsonValueExpr returns the core SQL expression encoding the value of expr
+// as JSON, the control characters of the strings being escaped afterwards by
+// escapeControlChars
+func jsonValueExpr(expr string) string {
+	text := fmt.Sprintf(`replace(replace(%s, '\', '\\'), '"', '\"')`, expr)
+	return fmt.Sprintf("CASE typeof(%[1]s) WHEN 'null' THEN 'null' WHEN 'integer' THEN %[1]s "+
+		"WHEN 'real' THEN CASE WHEN abs(%[1]s) > 1.7976931348623157e308 THEN 'null' ELSE %[1]s END "+
+		`WHEN 'blob' THEN '"' || hex(%[1]s) || '"' ELSE '"' || %[2]s || '"' END`, expr, text)
+}
+
+// control characters escaped per statement, the 31 nested replace() calls
+// overflowing the stack of the sqlite parser
+const controlCharsPerStatement = 16
+
+// JSON columns of the audit entries
+var auditJSONColumns = []string{"row_key", "old_values", "new_values"}
+
+// escapeControlChars returns the statements escaping the control characters
+// in the JSON columns of the rows of table matching where, such as the entry
+// just inserted. They can only come from the strings of the row, so are
+// escaped on the whole JSON.
+func escapeControlChars(table string, columns []string, where string) string {
+	codes := make([]string, 0x1f)
+	for c := range codes {
+		codes[c] = fmt.Sprint(c + 1)
+	}
+	hasControl := fmt.Sprintf("coalesce(%s, '') GLOB '*[' || char(%s) || ']*'",
+		strings.Join(columns, ", '') || coalesce("), strings.Join(codes, ", "))
+	var stmts []string
+	for first := 1; first < 0x20; first += controlCharsPerStatement {
+		var set []string
+		for _, column := range columns {
+			expr := column
+			for c := first; c < first+controlCharsPerStatement && c < 0x20; c++ {
+				escaped := fmt.Sprintf(`\u%04x`, c)
+				switch c {
+				case '\n':
+					escaped = `\n`
+				case '\r':
+					escaped = `\r`
+				case '\t':
+					escaped = `\t`
+				}
+				expr = fmt.Sprintf("replace(%s, char(%d), '%s')", expr, c, escaped)
+			}
+			set = append(set, column+" = "+expr)
+		}
+		stmts = append(stmts, fmt.Sprintf("UPDATE %s SET %s WHERE %s AND %s;",
+			table, strings.Join(set, ", "), where, hasControl))
+	}
+	return strings.Join(stmts, " ")
+}
+
+// registerJSONFuncs registers json_row(name1, value1, name2, value2, ...),
+// returning a JSON object of the given names and values in order. The JSON1
+// extension is only built in with the sqlite_json build tag, it stands in for
+// its json_object() in the sync and audit triggers created by the earlier
+// versions. Blobs are encoded in base64, infinite numbers as null.
+func registerJSONFuncs(conn *sqlite3.SQLiteConn) error {
+	return conn.RegisterFunc("json_row", jsonRowFunc, true)
+}
+
+func jsonRowFunc(args ...interface{}) (string, error) {
+	if len(args)%2 != 0 {
+		return "", errors.New("json_row() requires an even number of arguments")
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i < len(args); i += 2 {
+		name, ok := args[i].(string)
+		if !ok {
+			return "", errors.New("json_row() labels must be TEXT")
+		}
+		value := args[i+1]
+		switch v := value.(type) {
+		case []byte:
+			if v == nil {
+				value = nil
+			}
+		case float64:
+			if math.IsInf(v, 0) || math.IsNaN(v) {
+				// not representable in JSON
+				value = nil
+			}
+		}
+		n, err := json.Marshal(name)
+		if err != nil {
+			return "", err
+		}
+		v, err := json.Marshal(value)
+		if err != nil {
+			return "", errors.Wrap(err, "failed to encode "+name)
+		}
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.Write(n)
+		b.WriteByte(':')
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return b.String(), nil
+}
+
+// getTableList reads a list of table names
+func getTableList(arg interface{}) ([]string, error) {
+	list, ok := arg.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, errors.New("tables must be a list of table names")
+	}
+	var tables []string
+	for _, t := range list {
+		table, ok := t.(string)
+		if !ok || table == "" {
+			return nil, errors.Errorf("invalid table name %v", t)
+		}
+		tables = append(tables, table)
+	}
+	return tables, nil
+}
@@ -0,0 +1,120 @@
+package sqflite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestAuditCoreSQL checks that the audit triggers run on a connection without
+// the functions of the plugin, and log valid JSON
+func TestAuditCoreSQL(t *testing.T) {
+	p := newTestPlugin(t)
+	id := openTestDatabase(t, p, "audit.db", nil)
+	mustCall(t, p.handleExecute, sqlArgs(id, `CREATE TABLE t (id INTEGER PRIMARY KEY, "we""ird" TEXT, "it's" REAL, b BLOB, n)`))
+	mustCall(t, p.handleEnableAudit, map[interface{}]interface{}{
+		PARAM_ID:     id,
+		PARAM_TABLES: []interface{}{"t"},
+	})
+
+	other, err := sql.Open("sqlite3", p.resolvePath("audit.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	text := "a \"quoted\" \\ back\nslash\t\x01\x1f é ☃"
+	start := time.Now().UnixNano() / int64(time.Millisecond)
+	if _, err = other.Exec(`INSERT INTO t VALUES (1, ?, ?, ?, NULL)`, text, 1.5, []byte{0xca, 0xfe}); err != nil {
+		t.Fatalf("insert without the plugin functions: %v", err)
+	}
+	if _, err = other.Exec(`UPDATE t SET "it's" = ? WHERE id = 1`, math.Inf(1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = other.Exec(`DELETE FROM t`); err != nil {
+		t.Fatal(err)
+	}
+	end := time.Now().UnixNano() / int64(time.Millisecond)
+
+	rows := queryRows(t, p, id, "SELECT operation, row_key, old_values, new_values, time FROM "+auditTable+" ORDER BY id")
+	if len(rows) != 3 {
+		t.Fatalf("%d audit entries, want 3", len(rows))
+	}
+	decode := func(v interface{}) map[string]interface{} {
+		if v == nil {
+			return nil
+		}
+		var m map[string]interface{}
+		if err := json.Unmarshal([]byte(v.(string)), &m); err != nil {
+			t.Fatalf("invalid JSON %s: %v", v, err)
+		}
+		return m
+	}
+	inserted := map[string]interface{}{"id": 1.0, `we"ird`: text, "it's": 1.5, "b": "CAFE", "n": nil}
+	updated := map[string]interface{}{"id": 1.0, `we"ird`: text, "it's": nil, "b": "CAFE", "n": nil}
+	for i, want := range []struct {
+		operation string
+		old, new  map[string]interface{}
+	}{
+		{"insert", nil, inserted},
+		{"update", inserted, updated},
+		{"delete", updated, nil},
+	} {
+		row := rows[i].([]interface{})
+		if row[0] != want.operation {
+			t.Errorf("entry %d is %v, want %s", i, row[0], want.operation)
+		}
+		if key := decode(row[1]); !reflect.DeepEqual(key, map[string]interface{}{"id": 1.0}) {
+			t.Errorf("%s key = %v", want.operation, key)
+		}
+		if old := decode(row[2]); !reflect.DeepEqual(old, want.old) {
+			t.Errorf("%s old = %v, want %v", want.operation, old, want.old)
+		}
+		if new := decode(row[3]); !reflect.DeepEqual(new, want.new) {
+			t.Errorf("%s new = %v, want %v", want.operation, new, want.new)
+		}
+		if ms, _ := row[4].(int64); ms < start-1 || ms > end+1 {
+			t.Errorf("%s time = %v, want in [%d, %d]", want.operation, row[4], start, end)
+		}
+	}
+}
+
+// TestAuditInTransaction checks that enableAudit and queryAuditLog run within
+// the open transaction of the database instead of waiting for it
+func TestAuditInTransaction(t *testing.T) {
+	p := newTestPlugin(t)
+	id := openTestDatabase(t, p, "audit.db", nil)
+	mustCall(t, p.handleExecute, sqlArgs(id, "CREATE TABLE t (id INTEGER PRIMARY KEY, x)"))
+	audit := map[interface{}]interface{}{PARAM_ID: id, PARAM_TABLES: []interface{}{"t"}}
+
+	begin := sqlArgs(id, "BEGIN IMMEDIATE")
+	begin[PARAM_IN_TRANSACTION] = true
+	mustCall(t, p.handleExecute, begin)
+	done := make(chan error, 1)
+	go func() {
+		if _, err := p.handleEnableAudit(audit); err != nil {
+			done <- err
+			return
+		}
+		_, err := p.handleQueryAuditLog(map[interface{}]interface{}{PARAM_ID: id})
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("enableAudit blocked by the open transaction")
+	}
+	rollback := sqlArgs(id, "ROLLBACK")
+	rollback[PARAM_IN_TRANSACTION] = false
+	mustCall(t, p.handleExecute, rollback)
+
+	// rolled back with the transaction
+	if v := queryValue(t, p, id, "SELECT count(*) FROM sqlite_master WHERE name = ?", auditTable); v != int64(0) {
+		t.Error("audit table kept after rollback")
+	}
+}
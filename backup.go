@@ -0,0 +1,466 @@
+package sqflite
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// backupTimeFormat is the timestamp embedded in backup file names, in UTC
+// with nanoseconds so that backups taken within a second do not collide
+const backupTimeFormat = "20060102-150405,000000000Z"
+
+// legacyBackupTimeFormat is the timestamp, in local time, of the backups
+// named by the earlier versions
+const legacyBackupTimeFormat = "20060102-150405"
+
+// name of the event channel of the scheduled backups that ran
+const backupChannelName = channelName + "/backups"
+
+// BackupSchedule describes a periodic backup of a database file.
+type BackupSchedule struct {
+	Interval      time.Duration // time between two backups
+	Dir           string        // destination directory, created when missing
+	KeepLast      int           // number of backups to keep, 0 keeps all of them
+	OnlyIfChanged bool          // skip the backup when the file did not change since the last one
+}
+
+// fileState is used to detect changes of a database file between two backups
+type fileState struct {
+	size    int64
+	modTime time.Time
+	walSize int64
+	walTime time.Time
+}
+
+type backupWorker struct {
+	sync.Mutex
+	path     string
+	schedule BackupSchedule
+	stop     chan struct{}
+
+	state    fileState
+	lastRun  time.Time
+	nextRun  time.Time
+	lastFile string
+	lastErr  error
+	runs     int
+	skipped  int
+
+	lastFileTime time.Time // run that wrote lastFile
+}
+
+// ScheduleBackup starts a background worker backing up the database file at
+// dbPath every schedule.Interval. A previous schedule for the same path is
+// replaced.
+func (p *SqflitePlugin) ScheduleBackup(dbPath string, schedule BackupSchedule) error {
+	if dbPath == "" || dbPath == MEMORY_DATABASE_PATH {
+		return errors.New("invalid backup database path")
+	}
+	if schedule.Interval <= 0 {
+		return errors.New("backup interval must be positive")
+	}
+	if schedule.Dir == "" {
+		return errors.New("backup directory must be set")
+	}
+	if err := os.MkdirAll(schedule.Dir, 0755); err != nil {
+		return errors.Wrap(err, "failed to create backup directory")
+	}
+	w := &backupWorker{
+		path:     dbPath,
+		schedule: schedule,
+		stop:     make(chan struct{}),
+		nextRun:  time.Now().Add(schedule.Interval),
+	}
+	p.Lock()
+	if old, ok := p.backups[dbPath]; ok {
+		close(old.stop)
+	}
+	p.backups[dbPath] = w
+	p.Unlock()
+	go p.runBackupWorker(w)
+	return nil
+}
+
+// BackupDatabase copies the database at dbPath, absolute or relative to the
+// databases folder, to destPath with the sqlite online backup API, while the
+// database stays open and in use. The copy holds the committed transactions,
+// it is written next to destPath and renamed once complete.
+func (p *SqflitePlugin) BackupDatabase(dbPath, destPath string) error {
+	if dbPath == "" || dbPath == MEMORY_DATABASE_PATH {
+		return errors.New("invalid backup database path")
+	}
+	if destPath == "" {
+		return errors.New("backup path is not set")
+	}
+	dbPath = p.resolvePath(dbPath)
+	if samePath(dbPath, normalizePath(filepath.Clean(destPath))) {
+		return errors.New("cannot back up a database onto itself")
+	}
+	if err := os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return errors.Wrap(err, "failed to create backup directory")
+	}
+	return backupFile(dbPath, destPath)
+}
+
+// handleBackupDatabase backs up the database PARAM_ID to PARAM_PATH, see
+// BackupDatabase, and returns {path, size}
+func (p *SqflitePlugin) handleBackupDatabase(arguments interface{}) (reply interface{}, err error) {
+	databaseId, _, err := p.getDatabase(arguments)
+	if err != nil {
+		return nil, err
+	}
+	args := arguments.(map[interface{}]interface{})
+	dest, _ := args[PARAM_PATH].(string)
+	// encrypting the copy with another key needs sqlcipher_export(), the
+	// driver is built without SQLCipher
+	if password, _ := args[PARAM_PASSWORD].(string); password != "" {
+		return nil, errors.New("cannot back up " + dest + " with a password, database encryption is not supported")
+	}
+	p.Lock()
+	dbPath := p.databasePaths[databaseId]
+	p.Unlock()
+	if dbPath == MEMORY_DATABASE_PATH {
+		return nil, errors.New("cannot back up a memory database")
+	}
+	if err = p.BackupDatabase(dbPath, dest); err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(dest)
+	if err != nil {
+		return nil, err
+	}
+	return map[interface{}]interface{}{
+		PARAM_PATH: dest,
+		"size":     fi.Size(),
+	}, nil
+}
+
+// CancelBackup stops the scheduled backup of dbPath, if any.
+func (p *SqflitePlugin) CancelBackup(dbPath string) {
+	p.Lock()
+	defer p.Unlock()
+	if w, ok := p.backups[dbPath]; ok {
+		close(w.stop)
+		delete(p.backups, dbPath)
+	}
+}
+
+// handleScheduleBackup schedules the backup of the database file PARAM_PATH,
+// absolute or relative to the databases folder, every interval ms into dir,
+// keeping the keepLast latest backups, see ScheduleBackup. Each run is
+// reported on the backups event channel.
+func (p *SqflitePlugin) handleScheduleBackup(arguments interface{}) (reply interface{}, err error) {
+	args, ok := arguments.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("invalid arguments")
+	}
+	dbPath, _ := args[PARAM_PATH].(string)
+	if dbPath == "" {
+		return nil, errors.New("backup database path is not set")
+	}
+	interval, ok := toFloat(args["interval"])
+	if !ok {
+		return nil, errors.New("invalid backup interval")
+	}
+	keepLast, _ := toFloat(args["keepLast"])
+	schedule := BackupSchedule{
+		Interval: time.Duration(interval) * time.Millisecond,
+		KeepLast: int(keepLast),
+	}
+	schedule.Dir, _ = args["dir"].(string)
+	schedule.OnlyIfChanged, _ = args["onlyIfChanged"].(bool)
+	return nil, p.ScheduleBackup(p.resolvePath(dbPath), schedule)
+}
+
+// handleCancelBackup stops the backup scheduled for PARAM_PATH, absolute or
+// relative to the databases folder
+func (p *SqflitePlugin) handleCancelBackup(arguments interface{}) (reply interface{}, err error) {
+	args, ok := arguments.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("invalid arguments")
+	}
+	dbPath, _ := args[PARAM_PATH].(string)
+	if dbPath == "" {
+		return nil, errors.New("backup database path is not set")
+	}
+	p.CancelBackup(p.resolvePath(dbPath))
+	return nil, nil
+}
+
+func (p *SqflitePlugin) runBackupWorker(w *backupWorker) {
+	ticker := time.NewTicker(w.schedule.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-p.ctx.Done():
+			return
+		case now := <-ticker.C:
+			err := w.run(now)
+			event := w.event()
+			if err != nil {
+				p.logger.Errorf("backup of %s failed: %v", w.path, err)
+			} else if p.verbose() {
+				p.logger.Infof("backup of %s done, file=%v", w.path, event["file"])
+			}
+			p.Lock()
+			backupEvents := p.backupEvents
+			p.Unlock()
+			backupEvents.send(event)
+		}
+	}
+}
+
+// event returns the event of the last run of w: {path, time, file, skipped,
+// error}, file being the backup written, null when skipped or failed
+func (w *backupWorker) event() map[interface{}]interface{} {
+	w.Lock()
+	defer w.Unlock()
+	event := map[interface{}]interface{}{
+		PARAM_PATH:  w.path,
+		PARAM_TIME:  w.lastRun.UnixNano() / int64(time.Millisecond),
+		"file":      nil,
+		"skipped":   false,
+		PARAM_ERROR: nil,
+	}
+	switch {
+	case w.lastErr != nil:
+		event[PARAM_ERROR] = w.lastErr.Error()
+	case w.lastFile != "" && w.lastFileTime.Equal(w.lastRun):
+		event["file"] = w.lastFile
+	default:
+		event["skipped"] = true
+	}
+	return event
+}
+
+// run performs one scheduled backup and applies the retention policy
+func (w *backupWorker) run(now time.Time) error {
+	w.Lock()
+	defer w.Unlock()
+	w.lastRun = now
+	w.nextRun = now.Add(w.schedule.Interval)
+	state, err := statDatabaseFile(w.path)
+	if err != nil {
+		w.lastErr = err
+		return err
+	}
+	if w.schedule.OnlyIfChanged && w.runs > 0 && state == w.state {
+		w.skipped++
+		w.lastErr = nil
+		return nil
+	}
+	dest := filepath.Join(w.schedule.Dir, backupFileName(w.path, now))
+	if err = backupFile(w.path, dest); err != nil {
+		w.lastErr = err
+		return err
+	}
+	w.runs++
+	w.state = state
+	w.lastFile = dest
+	w.lastFileTime = now
+	w.lastErr = pruneBackups(w.path, w.schedule.Dir, w.schedule.KeepLast)
+	return w.lastErr
+}
+
+func (w *backupWorker) status() map[interface{}]interface{} {
+	w.Lock()
+	defer w.Unlock()
+	status := map[interface{}]interface{}{
+		PARAM_PATH:      w.path,
+		"dir":           w.schedule.Dir,
+		"interval":      int64(w.schedule.Interval / time.Millisecond),
+		"keepLast":      int64(w.schedule.KeepLast),
+		"onlyIfChanged": w.schedule.OnlyIfChanged,
+		"runs":          int64(w.runs),
+		"skipped":       int64(w.skipped),
+		"nextRun":       w.nextRun.UnixNano() / int64(time.Millisecond),
+		"lastFile":      nil,
+		"lastRun":       nil,
+		PARAM_ERROR:     nil,
+	}
+	if !w.lastRun.IsZero() {
+		status["lastRun"] = w.lastRun.UnixNano() / int64(time.Millisecond)
+	}
+	if w.lastFile != "" {
+		status["lastFile"] = w.lastFile
+	}
+	if w.lastErr != nil {
+		status[PARAM_ERROR] = w.lastErr.Error()
+	}
+	return status
+}
+
+// handleGetBackupStatus returns the status of the backup scheduled for the
+// given path, or the list of all scheduled backups when no path is given.
+func (p *SqflitePlugin) handleGetBackupStatus(arguments interface{}) (reply interface{}, err error) {
+	var dbPath string
+	if args, ok := arguments.(map[interface{}]interface{}); ok {
+		if v, ok := args[PARAM_PATH]; ok {
+			dbPath, _ = v.(string)
+		}
+	}
+	// a worker stays locked while copying the database, so its status is
+	// read without holding the plugin lock
+	p.Lock()
+	if dbPath != "" {
+		w, ok := p.backups[dbPath]
+		p.Unlock()
+		if !ok {
+			return nil, nil
+		}
+		return w.status(), nil
+	}
+	workers := make([]*backupWorker, 0, len(p.backups))
+	for _, w := range p.backups {
+		workers = append(workers, w)
+	}
+	p.Unlock()
+	var statuses []interface{}
+	for _, w := range workers {
+		statuses = append(statuses, w.status())
+	}
+	return statuses, nil
+}
+
+func statDatabaseFile(dbPath string) (state fileState, err error) {
+	fi, err := os.Stat(dbPath)
+	if err != nil {
+		return state, err
+	}
+	state.size, state.modTime = fi.Size(), fi.ModTime()
+	if fi, err = os.Stat(dbPath + "-wal"); err == nil {
+		state.walSize, state.walTime = fi.Size(), fi.ModTime()
+	}
+	return state, nil
+}
+
+// backupFileName returns <name>-<timestamp><ext> for the database at dbPath
+func backupFileName(dbPath string, t time.Time) string {
+	base := filepath.Base(dbPath)
+	ext := filepath.Ext(base)
+	return fmt.Sprintf("%s-%s%s", strings.TrimSuffix(base, ext), t.UTC().Format(backupTimeFormat), ext)
+}
+
+// parseBackupTime parses the timestamp of a backup file name, in the current
+// or the legacy format
+func parseBackupTime(stamp string) (time.Time, error) {
+	t, err := time.ParseInLocation(backupTimeFormat, stamp, time.UTC)
+	if err != nil {
+		return time.ParseInLocation(legacyBackupTimeFormat, stamp, time.Local)
+	}
+	return t, nil
+}
+
+// sortByArchiveTime sorts the archived files of dbPath oldest first
+func sortByArchiveTime(dbPath string, files []string) {
+	sort.SliceStable(files, func(i, j int) bool {
+		ti, _ := archiveTime(dbPath, files[i])
+		tj, _ := archiveTime(dbPath, files[j])
+		return ti.Before(tj)
+	})
+}
+
+// listBackups returns the backups of dbPath found in dir, oldest first
+func listBackups(dbPath, dir string) ([]string, error) {
+	base := filepath.Base(dbPath)
+	ext := filepath.Ext(base)
+	prefix := strings.TrimSuffix(base, ext) + "-"
+	matches, err := filepath.Glob(filepath.Join(dir, prefix+"*"+ext))
+	if err != nil {
+		return nil, err
+	}
+	var backups []string
+	for _, m := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(m), prefix), ext)
+		if _, err := parseBackupTime(stamp); err == nil {
+			backups = append(backups, m)
+		}
+	}
+	// the legacy names are in local time and do not sort with the others
+	sortByArchiveTime(dbPath, backups)
+	return backups, nil
+}
+
+// pruneBackups removes the oldest backups of dbPath so that at most keep remain
+func pruneBackups(dbPath, dir string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	backups, err := listBackups(dbPath, dir)
+	if err != nil {
+		return err
+	}
+	for len(backups) > keep {
+		if err = os.Remove(backups[0]); err != nil {
+			return err
+		}
+		backups = backups[1:]
+	}
+	return nil
+}
+
+// backupFile copies the database at src into dest using the sqlite online
+// backup API, so src can be in use while the copy is made. The copy is
+// written next to dest and renamed once complete.
+func backupFile(src, dest string) error {
+	if _, err := os.Stat(src); err != nil {
+		return err
+	}
+	drv := &sqlite3.SQLiteDriver{}
+	srcConn, err := drv.Open(fileDSN(src))
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+	tmp := dest + ".tmp"
+	os.Remove(tmp)
+	destConn, err := drv.Open(pathDSN(tmp))
+	if err != nil {
+		return err
+	}
+	bk, err := destConn.(*sqlite3.SQLiteConn).Backup("main", srcConn.(*sqlite3.SQLiteConn), "main")
+	if err != nil {
+		destConn.Close()
+		return err
+	}
+	_, err = bk.Step(-1)
+	if ferr := bk.Finish(); err == nil {
+		err = ferr
+	}
+	if cerr := destConn.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+// fileDSN returns the driver DSN for a private connection to an existing
+// database file. The driver sets the journal mode of every connection it
+// opens, so WAL databases must be opened in WAL mode to be left untouched.
+func fileDSN(dbPath string) string {
+	f, err := os.Open(dbPath)
+	if err != nil {
+		return pathDSN(dbPath)
+	}
+	defer f.Close()
+	header := make([]byte, 20)
+	// bytes 18 and 19 of the header are the file format versions, 2 means WAL
+	if n, _ := io.ReadFull(f, header); n == len(header) && header[18] == 2 {
+		return pathDSN(dbPath) + "?_journal_mode=WAL"
+	}
+	return pathDSN(dbPath)
+}
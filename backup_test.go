@@ -0,0 +1,83 @@
+package sqflite
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBackupFileNames(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "notes.db")
+	now := time.Now()
+
+	// backups taken within the same second
+	first, second := backupFileName(dbPath, now), backupFileName(dbPath, now.Add(time.Millisecond))
+	if first == second {
+		t.Fatalf("backups taken 1ms apart both named %s", first)
+	}
+	if stamp, err := archiveTime(dbPath, first); err != nil || !stamp.Equal(now) {
+		t.Errorf("archiveTime(%s) = %v, %v, want %v", first, stamp, err, now)
+	}
+
+	// a backup named by an earlier version, in local time, sorts by its time
+	legacy := "notes-" + now.Add(-time.Hour).Format(legacyBackupTimeFormat) + ".db"
+	var want []string
+	for _, name := range []string{legacy, first, second} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, path)
+	}
+	backups, err := listBackups(dbPath, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(backups, want) {
+		t.Errorf("listBackups = %v, want %v", backups, want)
+	}
+
+	if err = pruneBackups(dbPath, dir, 2); err != nil {
+		t.Fatal(err)
+	}
+	if backups, _ = listBackups(dbPath, dir); !reflect.DeepEqual(backups, want[1:]) {
+		t.Errorf("backups kept = %v, want %v", backups, want[1:])
+	}
+}
+
+// TestBackupStatusDuringBackup checks that reading the status of a worker
+// busy copying a database does not block the other calls
+func TestBackupStatusDuringBackup(t *testing.T) {
+	p := newTestPlugin(t)
+	dbPath := filepath.Join(p.getDatabasesPath(), "notes.db")
+	if err := p.ScheduleBackup(dbPath, BackupSchedule{Interval: time.Hour, Dir: t.TempDir()}); err != nil {
+		t.Fatal(err)
+	}
+	defer p.CancelBackup(dbPath)
+	p.Lock()
+	w := p.backups[dbPath]
+	p.Unlock()
+
+	// a worker running a backup
+	w.Lock()
+	unlock := sync.Once{}
+	defer unlock.Do(w.Unlock)
+	statuses := make(chan interface{}, 1)
+	go func() {
+		reply, _ := p.handleGetBackupStatus(nil)
+		statuses <- reply
+	}()
+	time.Sleep(50 * time.Millisecond)
+	callWithin(t, "getDatabasesPath", func() error {
+		p.getDatabasesPath()
+		return nil
+	})
+	unlock.Do(w.Unlock)
+	if reply := <-statuses; len(reply.([]interface{})) != 1 {
+		t.Errorf("statuses = %v, want 1", reply)
+	}
+}
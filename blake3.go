@@ -0,0 +1,152 @@
+package sqflite
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// Minimal BLAKE3 implementation (default hash mode, 32 byte output) backing
+// the blake3() SQL function. The whole input is hashed at once, there is no
+// streaming or keyed mode.
+
+const (
+	blake3ChunkLen = 1024
+	blake3BlockLen = 64
+
+	blake3ChunkStart = 1 << 0
+	blake3ChunkEnd   = 1 << 1
+	blake3Parent     = 1 << 2
+	blake3Root       = 1 << 3
+)
+
+var blake3IV = [8]uint32{
+	0x6A09E667, 0xBB67AE85, 0x3C6EF372, 0xA54FF53A,
+	0x510E527F, 0x9B05688C, 0x1F83D9AB, 0x5BE0CD19,
+}
+
+var blake3Permutation = [16]int{2, 6, 3, 10, 7, 0, 4, 13, 1, 11, 12, 5, 9, 14, 15, 8}
+
+// blake3Output is the input of a compression not run yet, kept to be
+// finalized either as a chaining value or as the root
+type blake3Output struct {
+	cv       [8]uint32
+	block    [16]uint32
+	counter  uint64
+	blockLen uint32
+	flags    uint32
+}
+
+func (o *blake3Output) chainingValue() (cv [8]uint32) {
+	s := blake3Compress(&o.cv, &o.block, o.counter, o.blockLen, o.flags)
+	copy(cv[:], s[:8])
+	return cv
+}
+
+func (o *blake3Output) rootHash() (sum [32]byte) {
+	s := blake3Compress(&o.cv, &o.block, 0, o.blockLen, o.flags|blake3Root)
+	for i := 0; i < 8; i++ {
+		binary.LittleEndian.PutUint32(sum[i*4:], s[i])
+	}
+	return sum
+}
+
+// blake3Sum returns the BLAKE3 hash of data
+func blake3Sum(data []byte) [32]byte {
+	var stack [][8]uint32
+	var chunk uint64
+	for len(data) > blake3ChunkLen {
+		cv := blake3Chunk(data[:blake3ChunkLen], chunk).chainingValue()
+		data = data[blake3ChunkLen:]
+		chunk++
+		// merge the completed subtrees, one per trailing zero of the chunk count
+		for n := chunk; n&1 == 0; n >>= 1 {
+			cv = blake3ParentOutput(stack[len(stack)-1], cv).chainingValue()
+			stack = stack[:len(stack)-1]
+		}
+		stack = append(stack, cv)
+	}
+	out := blake3Chunk(data, chunk)
+	for i := len(stack) - 1; i >= 0; i-- {
+		out = blake3ParentOutput(stack[i], out.chainingValue())
+	}
+	return out.rootHash()
+}
+
+// blake3Chunk compresses all blocks of a chunk but the last one
+func blake3Chunk(data []byte, counter uint64) *blake3Output {
+	cv := blake3IV
+	flags := uint32(blake3ChunkStart)
+	for len(data) > blake3BlockLen {
+		block := blake3Words(data[:blake3BlockLen])
+		s := blake3Compress(&cv, &block, counter, blake3BlockLen, flags)
+		copy(cv[:], s[:8])
+		data = data[blake3BlockLen:]
+		flags = 0
+	}
+	return &blake3Output{
+		cv:       cv,
+		block:    blake3Words(data),
+		counter:  counter,
+		blockLen: uint32(len(data)),
+		flags:    flags | blake3ChunkEnd,
+	}
+}
+
+func blake3ParentOutput(left, right [8]uint32) *blake3Output {
+	o := &blake3Output{cv: blake3IV, blockLen: blake3BlockLen, flags: blake3Parent}
+	copy(o.block[:8], left[:])
+	copy(o.block[8:], right[:])
+	return o
+}
+
+// blake3Words reads a block of at most 64 bytes, zero padded
+func blake3Words(b []byte) (m [16]uint32) {
+	var buf [blake3BlockLen]byte
+	copy(buf[:], b)
+	for i := range m {
+		m[i] = binary.LittleEndian.Uint32(buf[i*4:])
+	}
+	return m
+}
+
+func blake3Compress(cv *[8]uint32, block *[16]uint32, counter uint64, blockLen, flags uint32) [16]uint32 {
+	var s [16]uint32
+	copy(s[:8], cv[:])
+	copy(s[8:12], blake3IV[:4])
+	s[12] = uint32(counter)
+	s[13] = uint32(counter >> 32)
+	s[14] = blockLen
+	s[15] = flags
+	m := *block
+	for round := 0; round < 7; round++ {
+		blake3G(&s, 0, 4, 8, 12, m[0], m[1])
+		blake3G(&s, 1, 5, 9, 13, m[2], m[3])
+		blake3G(&s, 2, 6, 10, 14, m[4], m[5])
+		blake3G(&s, 3, 7, 11, 15, m[6], m[7])
+		blake3G(&s, 0, 5, 10, 15, m[8], m[9])
+		blake3G(&s, 1, 6, 11, 12, m[10], m[11])
+		blake3G(&s, 2, 7, 8, 13, m[12], m[13])
+		blake3G(&s, 3, 4, 9, 14, m[14], m[15])
+		var p [16]uint32
+		for i, j := range blake3Permutation {
+			p[i] = m[j]
+		}
+		m = p
+	}
+	for i := 0; i < 8; i++ {
+		s[i] ^= s[i+8]
+		s[i+8] ^= cv[i]
+	}
+	return s
+}
+
+func blake3G(s *[16]uint32, a, b, c, d int, mx, my uint32) {
+	s[a] += s[b] + mx
+	s[d] = bits.RotateLeft32(s[d]^s[a], -16)
+	s[c] += s[d]
+	s[b] = bits.RotateLeft32(s[b]^s[c], -12)
+	s[a] += s[b] + my
+	s[d] = bits.RotateLeft32(s[d]^s[a], -8)
+	s[c] += s[d]
+	s[b] = bits.RotateLeft32(s[b]^s[c], -7)
+}
@@ -0,0 +1,55 @@
+package sqflite
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// blake3Vectors are the hashes of the official BLAKE3 test vectors, the first
+// 32 bytes of their extended output, for inputs of the given length made of
+// the bytes 0, 1, ..., 250, 0, 1, ...
+var blake3Vectors = []struct {
+	length int
+	hash   string
+}{
+	{0, "af1349b9f5f9a1a6a0404dea36dcc9499bcb25c9adc112b7cc9a93cae41f3262"},
+	{1, "2d3adedff11b61f14c886e35afa036736dcd87a74d27b5c1510225d0f592e213"},
+	{1023, "10108970eeda3eb932baac1428c7a2163b0e924c9a9e25b35bba72b28f70bd11"},
+	{1024, "42214739f095a406f3fc83deb889744ac00df831c10daa55189b5d121c855af7"},
+	{1025, "d00278ae47eb27b34faecf67b4fe263f82d5412916c1ffd97c8cb7fb814b8444"},
+	{2048, "e776b6028c7cd22a4d0ba182a8bf62205d2ef576467e838ed6f2529b85fba24a"},
+	{31744, "62b6960e1a44bcc1eb1a611a8d6235b6b4b78f32e7abc4fb4c6cdcce94895c47"},
+	{102400, "bc3e3d41a1146b069abffad3c0d44860cf664390afce4d9661f7902e7943e085"},
+}
+
+func blake3VectorInput(length int) []byte {
+	b := make([]byte, length)
+	for i := range b {
+		b[i] = byte(i % 251)
+	}
+	return b
+}
+
+func TestBlake3Vectors(t *testing.T) {
+	for _, v := range blake3Vectors {
+		sum := blake3Sum(blake3VectorInput(v.length))
+		if got := hex.EncodeToString(sum[:]); got != v.hash {
+			t.Errorf("blake3Sum of %d bytes = %s, want %s", v.length, got, v.hash)
+		}
+	}
+}
+
+func TestBlake3Function(t *testing.T) {
+	p := newTestPlugin(t)
+	id := openTestDatabase(t, p, "blake3.db", nil)
+	for _, v := range blake3Vectors {
+		got := queryValue(t, p, id, "SELECT hex(blake3(?))", blake3VectorInput(v.length))
+		if got != strings.ToUpper(v.hash) {
+			t.Errorf("blake3() of %d bytes = %v, want %s", v.length, got, strings.ToUpper(v.hash))
+		}
+	}
+	if got := queryValue(t, p, id, "SELECT blake3(NULL)"); got != nil {
+		t.Errorf("blake3(NULL) = %v, want NULL", got)
+	}
+}
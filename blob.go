@@ -0,0 +1,100 @@
+package sqflite
+
+/*
+typedef struct sqlite3_stmt sqlite3_stmt;
+extern int sqlite3_column_type(sqlite3_stmt *stmt, int col);
+extern long long sqlite3_column_int64(sqlite3_stmt *stmt, int col);
+extern double sqlite3_column_double(sqlite3_stmt *stmt, int col);
+extern const unsigned char *sqlite3_column_text(sqlite3_stmt *stmt, int col);
+extern int sqlite3_column_bytes(sqlite3_stmt *stmt, int col);
+*/
+import "C"
+
+import (
+	"database/sql"
+	"reflect"
+	"time"
+	"unsafe"
+)
+
+// sqlite fundamental types of the values
+const (
+	sqliteInteger = 1
+	sqliteFloat   = 2
+	sqliteText    = 3
+	sqliteBlob    = 4
+)
+
+// rowsStmt returns the sqlite statement of rows, positioned on the current
+// row after Next. Neither database/sql nor the driver expose it, it is read
+// from their rowsi and s fields.
+func rowsStmt(rows *sql.Rows) *C.sqlite3_stmt {
+	v := reflect.ValueOf(rows).Elem().FieldByName("rowsi") // driver.Rows
+	if !v.IsValid() || v.IsNil() {
+		return nil
+	}
+	v = field(v.Elem(), "s") // *sqlite3.SQLiteRows
+	v = field(v, "s")        // *sqlite3.SQLiteStmt
+	if !v.IsValid() {
+		return nil
+	}
+	return *(**C.sqlite3_stmt)(unsafe.Pointer(v.UnsafeAddr()))
+}
+
+// field returns the non-nil pointer field name of the struct ptr points to
+func field(ptr reflect.Value, name string) reflect.Value {
+	if !ptr.IsValid() || ptr.Kind() != reflect.Ptr || ptr.IsNil() || ptr.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}
+	}
+	v := ptr.Elem().FieldByName(name)
+	if !v.IsValid() || v.Kind() != reflect.Ptr || v.IsNil() {
+		return reflect.Value{}
+	}
+	return v
+}
+
+// valueTypes reads the types of the values of the current row of rows
+type valueTypes struct {
+	stmt *C.sqlite3_stmt
+}
+
+func rowValueTypes(rows *sql.Rows) valueTypes {
+	return valueTypes{stmt: rowsStmt(rows)}
+}
+
+// isBlob tells if the value of column col of the current row is a BLOB, the
+// driver reading both TEXT and BLOB values as []byte
+func (t valueTypes) isBlob(col int) bool {
+	return t.stmt != nil && C.sqlite3_column_type(t.stmt, C.int(col)) == sqliteBlob
+}
+
+// value returns the value of column col of the current row as stored, as
+// int64, float64, string, []byte or nil, instead of the bool or time.Time
+// the driver converts it to from the declared type of its column, which the
+// message codec cannot send
+func (t valueTypes) value(col int, converted interface{}) interface{} {
+	if t.stmt == nil {
+		switch v := converted.(type) {
+		case bool:
+			if v {
+				return int64(1)
+			}
+			return int64(0)
+		case time.Time:
+			return v.Format("2006-01-02 15:04:05.999999999-07:00")
+		}
+		return converted
+	}
+	c := C.int(col)
+	switch C.sqlite3_column_type(t.stmt, c) {
+	case sqliteInteger:
+		return int64(C.sqlite3_column_int64(t.stmt, c))
+	case sqliteFloat:
+		return float64(C.sqlite3_column_double(t.stmt, c))
+	case sqliteText:
+		return C.GoStringN((*C.char)(unsafe.Pointer(C.sqlite3_column_text(t.stmt, c))), C.sqlite3_column_bytes(t.stmt, c))
+	case sqliteBlob:
+		return converted
+	}
+	return nil
+}
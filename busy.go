@@ -0,0 +1,44 @@
+package sqflite
+
+import (
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// busyRetry is the policy applied to the statements failing because the
+// database is locked by another connection or process, once the busy timeout
+// of the connection has elapsed
+type busyRetry struct {
+	attempts int           // retries of a statement, none by default
+	backoff  time.Duration // wait before the first retry, doubled at each one
+}
+
+// isBusy tells if err is a "database is locked" or "database table is
+// locked" error
+func isBusy(err error) bool {
+	sqliteErr, ok := errors.Cause(err).(sqlite3.Error)
+	return ok && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked)
+}
+
+// retryBusy runs f, and runs it again while it fails with a busy error, as
+// many times as set by WithBusyRetry. f must be safe to retry: a statement
+// run outside of a transaction, or beginning one.
+func (p *SqflitePlugin) retryBusy(f func() error) error {
+	p.Lock()
+	retry := p.busyRetry
+	p.Unlock()
+	err := f()
+	wait := retry.backoff
+	for i := 0; i < retry.attempts && isBusy(err); i++ {
+		select {
+		case <-time.After(wait):
+		case <-p.ctx.Done():
+			return err
+		}
+		wait *= 2
+		err = f()
+	}
+	return err
+}
@@ -0,0 +1,160 @@
+package sqflite
+
+/*
+typedef struct sqlite3 sqlite3;
+extern void sqlite3_interrupt(sqlite3 *db);
+*/
+import "C"
+
+import (
+	"database/sql"
+	"fmt"
+	"sync"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// openConns are the connections opened by the plugin by id, returned by the
+// sqflite_conn_id() function of each, for the calls to find the connection
+// they run on
+var openConns = struct {
+	sync.Mutex
+	conns  map[int64]*sqlite3.SQLiteConn
+	lastId int64
+}{conns: make(map[int64]*sqlite3.SQLiteConn)}
+
+// registerConnId registers sqflite_conn_id() on a new connection, forgetting
+// the connections closed since the last one
+func registerConnId(conn *sqlite3.SQLiteConn) error {
+	openConns.Lock()
+	for id, c := range openConns.conns {
+		if connHandle(c) == nil {
+			delete(openConns.conns, id)
+		}
+	}
+	openConns.lastId++
+	id := openConns.lastId
+	openConns.conns[id] = conn
+	openConns.Unlock()
+	return conn.RegisterFunc("sqflite_conn_id", func() int64 {
+		return id
+	}, true)
+}
+
+// cancelable is a query given a PARAM_CANCEL_TOKEN, interrupted by
+// cancelQuery while it runs on conn
+type cancelable struct {
+	sync.Mutex
+	conn      *sqlite3.SQLiteConn // nil once the query returned
+	cancelled bool
+}
+
+// getCancelToken returns the PARAM_CANCEL_TOKEN argument, a string or an
+// integer chosen by the Dart side, and whether it was given
+func getCancelToken(arguments interface{}) (string, bool, error) {
+	args, _ := arguments.(map[interface{}]interface{})
+	switch token := args[PARAM_CANCEL_TOKEN].(type) {
+	case nil:
+		return "", false, nil
+	case string, int32, int64:
+		return fmt.Sprint(token), true, nil
+	default:
+		return "", false, errors.Errorf("invalid cancelToken %v", token)
+	}
+}
+
+// queryCancelable runs the query sqlStr of databaseId on the connection exec
+// reserved to its transaction, or else on a connection of its read pool or of
+// db, which cancelQuery interrupts with token until the query returns
+func (p *SqflitePlugin) queryCancelable(token string, databaseId int32, db *sql.DB, exec executor, sqlStr string, args []interface{}) (reply interface{}, err error) {
+	ctx := p.ctx
+	conn, reserved := exec.(*sql.Conn)
+	if !reserved {
+		pool := db
+		if readPool := p.readPoolOf(databaseId, sqlStr); readPool != nil {
+			pool = readPool
+		}
+		if conn, err = pool.Conn(ctx); err != nil {
+			return nil, err
+		}
+		defer conn.Close()
+	}
+	var id int64
+	if err = conn.QueryRowContext(ctx, "SELECT sqflite_conn_id()").Scan(&id); err != nil {
+		return nil, err
+	}
+	openConns.Lock()
+	c := &cancelable{conn: openConns.conns[id]}
+	openConns.Unlock()
+	p.Lock()
+	if _, ok := p.cancelables[token]; ok {
+		p.Unlock()
+		return nil, errors.Errorf("cancelToken %s is already used by a running query", token)
+	}
+	p.cancelables[token] = c
+	p.Unlock()
+	defer func() {
+		p.Lock()
+		delete(p.cancelables, token)
+		p.Unlock()
+		c.Lock()
+		c.conn = nil
+		c.Unlock()
+	}()
+	run := func() error {
+		reply, err = readQuery(conn.QueryContext(ctx, sqlStr, args...))
+		return err
+	}
+	if reserved {
+		err = run()
+	} else {
+		err = p.retryBusy(run)
+	}
+	c.Lock()
+	cancelled := c.cancelled
+	c.Unlock()
+	if err != nil && cancelled {
+		return nil, &codedError{code: ERROR_CANCELLED, err: errors.Wrap(err, "query cancelled")}
+	}
+	return reply, err
+}
+
+// CancelQuery interrupts the running query given token as PARAM_CANCEL_TOKEN,
+// which fails with ERROR_CANCELLED, and tells if there was one. A query that
+// did not start yet, or already returned, is not cancelled.
+func (p *SqflitePlugin) CancelQuery(token string) bool {
+	p.Lock()
+	c := p.cancelables[token]
+	p.Unlock()
+	if c == nil {
+		return false
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.conn == nil {
+		return false
+	}
+	db := connHandle(c.conn)
+	if db == nil {
+		return false
+	}
+	C.sqlite3_interrupt(db)
+	c.cancelled = true
+	return true
+}
+
+// handleCancelQuery cancels the query given PARAM_CANCEL_TOKEN, see
+// CancelQuery, and returns {cancelled}
+func (p *SqflitePlugin) handleCancelQuery(arguments interface{}) (reply interface{}, err error) {
+	token, ok, err := getCancelToken(arguments)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("invalid cancelToken")
+	}
+	return map[interface{}]interface{}{
+		"cancelled": p.CancelQuery(token),
+	}, nil
+}
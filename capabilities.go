@@ -0,0 +1,57 @@
+package sqflite
+
+import (
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// Capabilities are the optional features of the sqlite library the databases
+// are opened with, which depend on the build tags of the application
+type Capabilities struct {
+	SQLiteVersion string
+	FTS5          bool // fts5 full-text search tables, with the sqlite_fts5 build tag
+	JSON1         bool // json functions, with the sqlite_json build tag
+}
+
+// capabilityProbes are the statements run on an in-memory connection to find
+// if a capability is built in
+var capabilityProbes = map[string]string{
+	"fts5":  "CREATE VIRTUAL TABLE temp.sqflite_probe USING fts5(content)",
+	"json1": "SELECT json_extract('{}', '$')",
+}
+
+// Capabilities returns the optional features of the sqlite library opening
+// the databases, probed once on an in-memory database
+func (p *SqflitePlugin) Capabilities() Capabilities {
+	p.Lock()
+	defer p.Unlock()
+	if p.capabilities == nil {
+		p.capabilities = &Capabilities{
+			SQLiteVersion: sqlite3Version(),
+			FTS5:          p.probeCapability("fts5"),
+			JSON1:         p.probeCapability("json1"),
+		}
+	}
+	return *p.capabilities
+}
+
+// probeCapability tells if the probe of the capability name succeeds
+func (p *SqflitePlugin) probeCapability(name string) bool {
+	conn, err := p.driver.Open(MEMORY_DATABASE_PATH)
+	if err != nil {
+		return false
+	}
+	defer conn.Close()
+	_, err = conn.(*sqlite3.SQLiteConn).Exec(capabilityProbes[name], nil)
+	return err == nil
+}
+
+// handleGetCapabilities returns the optional features of sqlite, see
+// Capabilities, as {sqliteVersion, fts5, json1}
+func (p *SqflitePlugin) handleGetCapabilities(arguments interface{}) (reply interface{}, err error) {
+	caps := p.Capabilities()
+	return map[interface{}]interface{}{
+		"sqliteVersion": caps.SQLiteVersion,
+		"fts5":          caps.FTS5,
+		"json1":         caps.JSON1,
+	}, nil
+}
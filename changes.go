@@ -0,0 +1,217 @@
+package sqflite
+
+import (
+	"sync"
+	"sync/atomic"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// names of the event channels of the changes committed to the databases, and
+// of their transactions
+const (
+	changeChannelName      = channelName + "/changes"
+	transactionChannelName = channelName + "/transactions"
+)
+
+// changes recorded per transaction, the changes above being reported as a
+// single event per table, without rowid
+const maxTransactionChanges = 1000
+
+// names of the operations of the update hook
+var changeOperations = map[int]string{
+	sqlite3.SQLITE_INSERT: "insert",
+	sqlite3.SQLITE_UPDATE: "update",
+	sqlite3.SQLITE_DELETE: "delete",
+}
+
+// changeSource is the database whose connections publish their committed
+// changes. Its id is set once the database is registered, the changes made
+// while opening it are not published.
+type changeSource struct {
+	databaseId int32
+	path       string
+}
+
+func (s *changeSource) id() int32 {
+	return atomic.LoadInt32(&s.databaseId)
+}
+
+// change is a row changed by a transaction, a change of the table without
+// rowid when op is 0
+type change struct {
+	schema string
+	table  string
+	op     int
+	rowid  int64
+}
+
+// changeBatch are the changes of a transaction, committed or rolled back
+type changeBatch struct {
+	source    *changeSource
+	changes   []change
+	committed bool
+}
+
+// TransactionEvent is a transaction of a database opened by the plugin that
+// committed or rolled back
+type TransactionEvent struct {
+	DatabaseId int32
+	Path       string
+	Committed  bool
+	Tables     []string // tables changed by the committed transaction, in order
+}
+
+// tables returns the tables of the changes of the batch, once each
+func (b *changeBatch) tables() []string {
+	seen := make(map[string]bool)
+	var tables []string
+	for _, c := range b.changes {
+		table := c.table
+		if c.schema != "main" {
+			table = c.schema + "." + table
+		}
+		if !seen[table] {
+			seen[table] = true
+			tables = append(tables, table)
+		}
+	}
+	return tables
+}
+
+// hookChanges records the rows changed by the transactions of a connection,
+// publishing them once committed, and publishes the end of the transactions
+func (p *SqflitePlugin) hookChanges(hooks *connHooks, source *changeSource) {
+	var pending []change
+	overflowed := make(map[string]bool)
+	reset := func() {
+		pending = nil
+		overflowed = make(map[string]bool)
+	}
+	hooks.onUpdate(func(op int, db string, table string, rowid int64) {
+		// temp tables are private to the connection
+		if db == "temp" {
+			return
+		}
+		if len(pending) < maxTransactionChanges {
+			pending = append(pending, change{schema: db, table: table, op: op, rowid: rowid})
+		} else if key := db + "." + table; !overflowed[key] {
+			overflowed[key] = true
+			pending = append(pending, change{schema: db, table: table})
+		}
+	})
+	hooks.onCommit(func() int {
+		if source.id() != 0 {
+			p.publishChanges(changeBatch{source: source, changes: pending, committed: true})
+		}
+		reset()
+		return 0
+	})
+	hooks.onRollback(func() {
+		if source.id() != 0 {
+			p.publishChanges(changeBatch{source: source})
+		}
+		reset()
+	})
+}
+
+// batchQueue is the unbounded queue of the ends of the transactions, so that
+// the commit hooks never wait for the events to be sent
+type batchQueue struct {
+	sync.Mutex
+	batches []changeBatch
+	ready   chan struct{} // signaled when batches are pushed
+}
+
+func newBatchQueue() *batchQueue {
+	return &batchQueue{ready: make(chan struct{}, 1)}
+}
+
+func (q *batchQueue) push(batch changeBatch) {
+	q.Lock()
+	q.batches = append(q.batches, batch)
+	q.Unlock()
+	select {
+	case q.ready <- struct{}{}:
+	default:
+		// already signaled
+	}
+}
+
+// pop returns the queued batches, oldest first, and empties the queue
+func (q *batchQueue) pop() []changeBatch {
+	q.Lock()
+	defer q.Unlock()
+	batches := q.batches
+	q.batches = nil
+	return batches
+}
+
+// publishChanges queues the end of a transaction, sent in order by
+// dispatchChanges. It is called by the commit hook, and does not block.
+func (p *SqflitePlugin) publishChanges(batch changeBatch) {
+	p.Lock()
+	queue := p.changeQueue
+	p.Unlock()
+	if queue != nil {
+		queue.push(batch)
+	}
+}
+
+// dispatchChanges sends the committed changes and the transactions to the
+// Dart side listening on their event channels and to the transaction
+// listener, and runs the watched queries they change again
+func (p *SqflitePlugin) dispatchChanges(queue *batchQueue) {
+	for range queue.ready {
+		for _, batch := range queue.pop() {
+			p.dispatchBatch(batch)
+		}
+	}
+}
+
+// dispatchBatch sends the changes and the end of a transaction
+func (p *SqflitePlugin) dispatchBatch(batch changeBatch) {
+	p.Lock()
+	changes, txEvents := p.changes, p.txEvents
+	p.Unlock()
+	id := batch.source.id()
+	for _, c := range batch.changes {
+		event := map[interface{}]interface{}{
+			"db":        id,
+			PARAM_PATH:  batch.source.path,
+			"schema":    c.schema,
+			PARAM_TABLE: c.table,
+		}
+		if c.op != 0 {
+			event["operation"] = changeOperations[c.op]
+			event["rowid"] = c.rowid
+		}
+		changes.send(event)
+	}
+	if len(batch.changes) > 0 {
+		p.notifyWatches(id, batch.changes)
+	}
+	tx := TransactionEvent{
+		DatabaseId: id,
+		Path:       batch.source.path,
+		Committed:  batch.committed,
+		Tables:     batch.tables(),
+	}
+	operation := "rollback"
+	if tx.Committed {
+		operation = "commit"
+	}
+	tables := make([]interface{}, len(tx.Tables))
+	for i, table := range tx.Tables {
+		tables[i] = table
+	}
+	txEvents.send(map[interface{}]interface{}{
+		"db":        id,
+		PARAM_PATH:  tx.Path,
+		"operation": operation,
+		"tables":    tables,
+	})
+	if p.transactionListener != nil {
+		p.transactionListener(tx)
+	}
+}
@@ -0,0 +1,55 @@
+package sqflite
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-flutter-desktop/go-flutter/plugin"
+)
+
+// TestPublishChangesDoesNotBlock checks that the commits do not wait for a
+// blocked transaction listener, and that their events are all sent once it
+// returns
+func TestPublishChangesDoesNotBlock(t *testing.T) {
+	const inserts = 200
+	release := make(chan struct{})
+	events := make(chan TransactionEvent, 2*inserts)
+	p := newTestPlugin(t, WithTransactionListener(func(tx TransactionEvent) {
+		<-release
+		events <- tx
+	}))
+	if err := p.InitPlugin(&testMessenger{handlers: make(map[string]plugin.ChannelHandlerFunc)}); err != nil {
+		t.Fatal(err)
+	}
+	id := openTestDatabase(t, p, "changes.db", nil)
+	mustCall(t, p.handleExecute, sqlArgs(id, "CREATE TABLE t (x)"))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < inserts; i++ {
+			if _, err := p.handleInsert(sqlArgs(id, "INSERT INTO t VALUES (?)", int64(i))); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		close(release)
+		t.Fatal("commits blocked by the transaction listener")
+	}
+
+	close(release)
+	for n := 0; n < inserts; {
+		select {
+		case tx := <-events:
+			if len(tx.Tables) == 1 && tx.Tables[0] == "t" {
+				n++
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("received %d insert events, want %d", n, inserts)
+		}
+	}
+}
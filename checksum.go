@@ -0,0 +1,204 @@
+package sqflite
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// default name of the checksum column added by addRowChecksum
+const defaultChecksumColumn = "row_checksum"
+
+// handleAddRowChecksum adds a checksum column to PARAM_TABLE, named after
+// PARAM_COLUMN, with triggers keeping it equal to the sha256 of the other
+// columns of the row on insert and update, and fills it for the existing rows.
+// Calling it again after the table schema changed recreates the triggers.
+// The triggers use the sha256() SQL function of the plugin, which sqlite does
+// not provide: the table can then only be written through connections having
+// it registered, other tools fail with "no such function: sha256" until the
+// triggers are dropped with removeRowChecksum. Within the open transaction of
+// the database, the changes are committed or rolled back with it.
+func (p *SqflitePlugin) handleAddRowChecksum(arguments interface{}) (reply interface{}, err error) {
+	databaseId, db, err := p.getDatabase(arguments)
+	if err != nil {
+		return nil, err
+	}
+	table, column, err := getChecksumParams(arguments)
+	if err != nil {
+		return nil, err
+	}
+	ctx := p.ctx
+	exec, tx, err := p.beginExecutor(databaseId, db, arguments)
+	if err != nil {
+		return nil, err
+	}
+	if tx != nil {
+		defer tx.Rollback()
+	}
+	cols, err := tableColumns(ctx, exec, "main", table)
+	if err != nil {
+		return nil, err
+	}
+	if indexOf(cols, column) < 0 {
+		if _, err = exec.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s BLOB", quoteIdentifier(table), quoteIdentifier(column))); err != nil {
+			return nil, err
+		}
+	} else {
+		cols = append(cols[:indexOf(cols, column)], cols[indexOf(cols, column)+1:]...)
+	}
+	key, err := checksumKey(ctx, exec, table)
+	if err != nil {
+		return nil, err
+	}
+	var quoted, keyMatch []string
+	for _, c := range cols {
+		quoted = append(quoted, quoteIdentifier(c))
+	}
+	for _, k := range key {
+		keyMatch = append(keyMatch, k+" IS NEW."+k)
+	}
+	update := fmt.Sprintf("UPDATE %s SET %s = %s WHERE %s;", quoteIdentifier(table), quoteIdentifier(column),
+		checksumExpr(cols, "NEW."), strings.Join(keyMatch, " AND "))
+	triggers := map[string]string{
+		"insert": fmt.Sprintf("AFTER INSERT ON %s", quoteIdentifier(table)),
+		"update": fmt.Sprintf("AFTER UPDATE OF %s ON %s", strings.Join(quoted, ", "), quoteIdentifier(table)),
+	}
+	for event, when := range triggers {
+		name := checksumTrigger(table, column, event)
+		if _, err = exec.ExecContext(ctx, "DROP TRIGGER IF EXISTS "+name); err != nil {
+			return nil, err
+		}
+		if _, err = exec.ExecContext(ctx, fmt.Sprintf("CREATE TRIGGER %s %s BEGIN %s END", name, when, update)); err != nil {
+			return nil, errors.Wrap(err, "failed to create checksum trigger")
+		}
+	}
+	res, err := exec.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET %s = %s", quoteIdentifier(table), quoteIdentifier(column), checksumExpr(cols, "")))
+	if err != nil {
+		return nil, err
+	}
+	if tx != nil {
+		if err = tx.Commit(); err != nil {
+			return nil, err
+		}
+	}
+	n, _ := res.RowsAffected()
+	return map[interface{}]interface{}{
+		PARAM_COLUMN: column,
+		"rows":       n,
+	}, nil
+}
+
+// handleRemoveRowChecksum drops the triggers added by addRowChecksum to
+// PARAM_TABLE for its PARAM_COLUMN, so that other tools can write the table.
+// The column is kept, no longer updated, sqlite before 3.35 not dropping
+// columns.
+func (p *SqflitePlugin) handleRemoveRowChecksum(arguments interface{}) (reply interface{}, err error) {
+	databaseId, db, err := p.getDatabase(arguments)
+	if err != nil {
+		return nil, err
+	}
+	table, column, err := getChecksumParams(arguments)
+	if err != nil {
+		return nil, err
+	}
+	exec := p.executor(databaseId, db, arguments)
+	for _, event := range []string{"insert", "update"} {
+		if _, err = exec.ExecContext(p.ctx, "DROP TRIGGER IF EXISTS "+checksumTrigger(table, column, event)); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+// checksumTrigger returns the quoted name of the checksum trigger of column
+// on the insert or update event of table
+func checksumTrigger(table, column, event string) string {
+	return quoteIdentifier(table + "_" + column + "_" + event)
+}
+
+// handleVerifyRowChecksums reports the rows of PARAM_TABLE whose checksum
+// column does not match their content, by primary key (or rowid).
+func (p *SqflitePlugin) handleVerifyRowChecksums(arguments interface{}) (reply interface{}, err error) {
+	databaseId, db, err := p.getDatabase(arguments)
+	if err != nil {
+		return nil, err
+	}
+	table, column, err := getChecksumParams(arguments)
+	if err != nil {
+		return nil, err
+	}
+	ctx := p.ctx
+	exec := p.executor(databaseId, db, arguments)
+	cols, err := tableColumns(ctx, exec, "main", table)
+	if err != nil {
+		return nil, err
+	}
+	i := indexOf(cols, column)
+	if i < 0 {
+		return nil, errors.New("no checksum column " + column + " in " + table)
+	}
+	cols = append(cols[:i], cols[i+1:]...)
+	key, err := checksumKey(ctx, exec, table)
+	if err != nil {
+		return nil, err
+	}
+	var total int64
+	if err = exec.(rowQueryer).QueryRowContext(ctx, "SELECT count(*) FROM "+quoteIdentifier(table)).Scan(&total); err != nil {
+		return nil, err
+	}
+	rows, err := exec.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s WHERE %s IS NOT %s",
+		strings.Join(key, ", "), quoteIdentifier(table), quoteIdentifier(column), checksumExpr(cols, "")))
+	if err != nil {
+		return nil, err
+	}
+	n, mismatches, err := collectKeys(rows, true)
+	if err != nil {
+		return nil, err
+	}
+	return map[interface{}]interface{}{
+		"checked":    total,
+		"valid":      n == 0,
+		"mismatches": mismatches,
+	}, nil
+}
+
+// checksumExpr returns the checksum of the given columns, prefixed with
+// prefix. quote() keeps the storage class of each value in the hashed text.
+func checksumExpr(cols []string, prefix string) string {
+	var values []string
+	for _, c := range cols {
+		values = append(values, "quote("+prefix+quoteIdentifier(c)+")")
+	}
+	return "sha256(" + strings.Join(values, " || ',' || ") + ")"
+}
+
+// checksumKey returns the quoted columns identifying a row of table
+func checksumKey(ctx context.Context, q queryer, table string) ([]string, error) {
+	pk, err := primaryKeyColumns(ctx, q, table)
+	if err != nil {
+		return nil, err
+	}
+	if len(pk) == 0 {
+		return []string{"rowid"}, nil
+	}
+	var key []string
+	for _, c := range pk {
+		key = append(key, quoteIdentifier(c))
+	}
+	return key, nil
+}
+
+func getChecksumParams(arguments interface{}) (table, column string, err error) {
+	args := arguments.(map[interface{}]interface{})
+	table, _ = args[PARAM_TABLE].(string)
+	if table == "" {
+		return "", "", errors.New("table is not set")
+	}
+	column, _ = args[PARAM_COLUMN].(string)
+	if column == "" {
+		column = defaultChecksumColumn
+	}
+	return table, column, nil
+}
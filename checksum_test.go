@@ -0,0 +1,82 @@
+package sqflite
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRowChecksumRemove(t *testing.T) {
+	p := newTestPlugin(t)
+	id := openTestDatabase(t, p, "checksum.db", nil)
+	mustCall(t, p.handleExecute, sqlArgs(id, "CREATE TABLE t (id INTEGER PRIMARY KEY, x)"))
+	mustCall(t, p.handleInsert, sqlArgs(id, "INSERT INTO t VALUES (1, 'a')"))
+	checksum := map[interface{}]interface{}{PARAM_ID: id, PARAM_TABLE: "t"}
+	mustCall(t, p.handleAddRowChecksum, checksum)
+	mustCall(t, p.handleInsert, sqlArgs(id, "INSERT INTO t (id, x) VALUES (2, 'b')"))
+	reply := mustCall(t, p.handleVerifyRowChecksums, checksum).(map[interface{}]interface{})
+	if reply["valid"] != true || reply["checked"] != int64(2) {
+		t.Errorf("verifyRowChecksums = %v, want 2 valid rows", reply)
+	}
+
+	// inserts on a connection without the functions of the plugin
+	insert := func() error {
+		other, err := sql.Open("sqlite3", p.resolvePath("checksum.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer other.Close()
+		_, err = other.Exec("INSERT INTO t (id, x) VALUES (3, 'c')")
+		return err
+	}
+	if err := insert(); err == nil || !strings.Contains(err.Error(), "sha256") {
+		t.Errorf("insert without sha256() = %v, want no such function", err)
+	}
+
+	mustCall(t, p.handleRemoveRowChecksum, checksum)
+	if err := insert(); err != nil {
+		t.Errorf("insert after removeRowChecksum: %v", err)
+	}
+	reply = mustCall(t, p.handleVerifyRowChecksums, checksum).(map[interface{}]interface{})
+	if reply["valid"] != false {
+		t.Errorf("verifyRowChecksums = %v, want the row without checksum reported", reply)
+	}
+}
+
+func TestRowChecksumInTransaction(t *testing.T) {
+	p := newTestPlugin(t)
+	id := openTestDatabase(t, p, "checksum.db", nil)
+	mustCall(t, p.handleExecute, sqlArgs(id, "CREATE TABLE t (id INTEGER PRIMARY KEY, x)"))
+	mustCall(t, p.handleInsert, sqlArgs(id, "INSERT INTO t VALUES (1, 'a')"))
+	checksum := map[interface{}]interface{}{PARAM_ID: id, PARAM_TABLE: "t"}
+
+	begin := sqlArgs(id, "BEGIN IMMEDIATE")
+	begin[PARAM_IN_TRANSACTION] = true
+	mustCall(t, p.handleExecute, begin)
+	done := make(chan error, 1)
+	go func() {
+		if _, err := p.handleAddRowChecksum(checksum); err != nil {
+			done <- err
+			return
+		}
+		_, err := p.handleVerifyRowChecksums(checksum)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("addRowChecksum blocked by the open transaction")
+	}
+	rollback := sqlArgs(id, "ROLLBACK")
+	rollback[PARAM_IN_TRANSACTION] = false
+	mustCall(t, p.handleExecute, rollback)
+
+	// rolled back with the transaction
+	if v := queryValue(t, p, id, "SELECT count(*) FROM pragma_table_info('t') WHERE name = ?", defaultChecksumColumn); v != int64(0) {
+		t.Error("checksum column kept after rollback")
+	}
+}
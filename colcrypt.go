@@ -0,0 +1,125 @@
+package sqflite
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// columnCipherVersion prefixes the values produced by encrypt_col
+const columnCipherVersion = 1
+
+// keyRing holds the keys used by the encrypt_col and decrypt_col SQL functions
+type keyRing struct {
+	sync.RWMutex
+	keys map[string]cipher.AEAD
+}
+
+func newKeyRing() *keyRing {
+	return &keyRing{keys: make(map[string]cipher.AEAD)}
+}
+
+// SetColumnKey adds or replaces the key keyID of the column encryption key
+// ring. key must be 16, 24 or 32 bytes long to select AES-128, AES-192 or
+// AES-256. From SQL, values are encrypted with encrypt_col(value, keyID),
+// returning a BLOB, and decrypted with decrypt_col(value, keyID), returning
+// the plaintext as a BLOB: use CAST(decrypt_col(value, keyID) AS TEXT) for
+// text columns.
+func (p *SqflitePlugin) SetColumnKey(keyID string, key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	p.columnKeys.Lock()
+	defer p.columnKeys.Unlock()
+	p.columnKeys.keys[keyID] = aead
+	return nil
+}
+
+// RemoveColumnKey removes the key keyID from the column encryption key ring.
+func (p *SqflitePlugin) RemoveColumnKey(keyID string) {
+	p.columnKeys.Lock()
+	defer p.columnKeys.Unlock()
+	delete(p.columnKeys.keys, keyID)
+}
+
+func (k *keyRing) get(keyID string) (cipher.AEAD, error) {
+	k.RLock()
+	defer k.RUnlock()
+	aead, ok := k.keys[keyID]
+	if !ok {
+		return nil, errors.New("unknown column key " + keyID)
+	}
+	return aead, nil
+}
+
+// encrypt implements encrypt_col(value, keyid). The result is the version
+// byte, the nonce and the sealed value, authenticated with the key id.
+// NULL stays NULL.
+func (k *keyRing) encrypt(value interface{}, keyID string) ([]byte, error) {
+	plain := columnBytes(value)
+	if plain == nil {
+		return nil, nil
+	}
+	aead, err := k.get(keyID)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 1+aead.NonceSize(), 1+aead.NonceSize()+len(plain)+aead.Overhead())
+	out[0] = columnCipherVersion
+	if _, err = io.ReadFull(rand.Reader, out[1:]); err != nil {
+		return nil, err
+	}
+	return aead.Seal(out, out[1:], plain, []byte(keyID)), nil
+}
+
+// decrypt implements decrypt_col(value, keyid). NULL stays NULL.
+func (k *keyRing) decrypt(arg interface{}, keyID string) ([]byte, error) {
+	value := columnBytes(arg)
+	if value == nil {
+		return nil, nil
+	}
+	aead, err := k.get(keyID)
+	if err != nil {
+		return nil, err
+	}
+	if len(value) < 1+aead.NonceSize()+aead.Overhead() {
+		return nil, errors.New("invalid encrypted column value, too short")
+	}
+	if value[0] != columnCipherVersion {
+		return nil, errors.Errorf("invalid encrypted column value, unknown version %d", value[0])
+	}
+	nonce := value[1 : 1+aead.NonceSize()]
+	plain, err := aead.Open(nil, nonce, value[1+aead.NonceSize():], []byte(keyID))
+	if err != nil {
+		return nil, errors.New("cannot decrypt column value with key " + keyID)
+	}
+	return plain, nil
+}
+
+// columnBytes returns the bytes to encrypt for a sqlite value, numbers being
+// encrypted as their text representation
+func columnBytes(value interface{}) []byte {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []byte:
+		// the driver passes NULL as a nil slice
+		if v == nil {
+			return nil
+		}
+		return append([]byte{}, v...)
+	case string:
+		return []byte(v)
+	}
+	return []byte(fmt.Sprint(value))
+}
@@ -0,0 +1,54 @@
+package sqflite
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDecryptInvalidValues(t *testing.T) {
+	k := newKeyRing()
+	p := &SqflitePlugin{columnKeys: k}
+	if err := p.SetColumnKey("k", bytes.Repeat([]byte{1}, 32)); err != nil {
+		t.Fatal(err)
+	}
+	valid, err := k.encrypt("secret", "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrongVersion := append([]byte{}, valid...)
+	wrongVersion[0] = columnCipherVersion + 1
+	for _, test := range []struct {
+		name  string
+		value interface{}
+	}{
+		{"empty text", ""},
+		{"empty blob", []byte{}},
+		{"version only", []byte{columnCipherVersion}},
+		{"truncated", valid[:len(valid)-len("secret")-1]},
+		{"wrong version", wrongVersion},
+	} {
+		if _, err := k.decrypt(test.value, "k"); err == nil {
+			t.Errorf("%s: decrypted without error", test.name)
+		}
+	}
+	plain, err := k.decrypt(valid, "k")
+	if err != nil || string(plain) != "secret" {
+		t.Errorf("decrypt = %q, %v, want secret", plain, err)
+	}
+}
+
+func TestDecryptColEmptyFromSQL(t *testing.T) {
+	p := newTestPlugin(t)
+	if err := p.SetColumnKey("k", bytes.Repeat([]byte{1}, 16)); err != nil {
+		t.Fatal(err)
+	}
+	id := openTestDatabase(t, p, "crypt.db", nil)
+	for _, sqlStr := range []string{"SELECT decrypt_col('', 'k')", "SELECT decrypt_col(X'', 'k')"} {
+		if _, err := p.handleQuery(sqlArgs(id, sqlStr)); err == nil {
+			t.Errorf("%s succeeded", sqlStr)
+		}
+	}
+	if v := queryValue(t, p, id, "SELECT CAST(decrypt_col(encrypt_col('x', 'k'), 'k') AS TEXT)"); v != "x" {
+		t.Errorf("round trip = %v, want x", v)
+	}
+}
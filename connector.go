@@ -0,0 +1,217 @@
+package sqflite
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// connector opens the connections of a database, preparing each new
+// connection of the pool the same way, see setupConn.
+type connector struct {
+	dsn     string
+	plugin  *SqflitePlugin
+	driver  *sqlite3.SQLiteDriver
+	options engineOptions
+}
+
+// engineOptions are the settings of a database applied to its connections
+type engineOptions struct {
+	cache       *queryCache   // optional result cache of the database
+	tempStore   string        // temp_store pragma value, empty for the default
+	accessMode  *accessMode   // read-only connections when set and read-only
+	keepWAL     string        // database file whose WAL mode is kept, empty to reset it
+	busyTimeout time.Duration // busy_timeout pragma value, 0 for the default of the driver
+	journalMode string        // journal_mode pragma value, empty to keep the WAL mode
+	checkpoint  int32         // wal_autocheckpoint pragma value in pages, 0 for the default
+	pragmas     *connPragmas  // pragmas of the database
+	changes     *changeSource // database whose committed changes are published
+	extensions  []string      // extension libraries loaded on each connection
+
+	attachments *connAttachments // databases attached to each connection
+}
+
+var _ driver.Connector = &connector{} // compile-time type check
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	dsn := c.dsn
+	if c.options.accessMode != nil && c.options.accessMode.isReadOnly() && c.options.accessMode.readOnlyDSN != "" {
+		dsn = c.options.accessMode.readOnlyDSN
+	}
+	// the driver sets the journal mode of each new connection, DELETE by
+	// default, which fails while another connection uses the WAL
+	if c.options.journalMode != "" {
+		dsn = dsnParam(dsn, "_journal_mode="+c.options.journalMode)
+	} else if c.options.keepWAL != "" && isWALFile(c.options.keepWAL) {
+		dsn = dsnParam(dsn, "_journal_mode=WAL")
+	}
+	conn, err := c.driver.Open(dsn)
+	if err != nil {
+		// the driver reports an extension that failed to load with the
+		// message of the last sqlite error, which is not set
+		if len(c.options.extensions) > 0 && err.Error() == "not an error" {
+			err = errors.Errorf("failed to load the extensions %s", strings.Join(c.options.extensions, ", "))
+		}
+		return nil, err
+	}
+	if err = c.plugin.setupConn(conn.(*sqlite3.SQLiteConn)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	for _, pragma := range c.plugin.pragmas {
+		if _, err = conn.(*sqlite3.SQLiteConn).Exec("PRAGMA "+pragma, nil); err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, "PRAGMA "+pragma)
+		}
+	}
+	for _, pragma := range c.options.pragmas.statements() {
+		if _, err = conn.(*sqlite3.SQLiteConn).Exec(pragma, nil); err != nil {
+			conn.Close()
+			return nil, errors.Wrap(err, pragma)
+		}
+	}
+	if err = c.options.attachments.attach(conn.(*sqlite3.SQLiteConn)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if c.options.busyTimeout > 0 {
+		if _, err = conn.(*sqlite3.SQLiteConn).Exec(fmt.Sprintf("PRAGMA busy_timeout = %d", c.options.busyTimeout/time.Millisecond), nil); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.options.checkpoint > 0 {
+		if _, err = conn.(*sqlite3.SQLiteConn).Exec(fmt.Sprintf("PRAGMA wal_autocheckpoint = %d", c.options.checkpoint), nil); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.options.tempStore != "" {
+		if _, err = conn.(*sqlite3.SQLiteConn).Exec("PRAGMA temp_store = "+c.options.tempStore, nil); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if c.options.accessMode != nil && c.options.accessMode.isReadOnly() {
+		if _, err = conn.(*sqlite3.SQLiteConn).Exec("PRAGMA query_only = 1", nil); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	hooks := &connHooks{}
+	if c.options.cache != nil {
+		c.options.cache.hook(hooks)
+	}
+	if c.options.changes != nil {
+		c.plugin.hookChanges(hooks, c.options.changes)
+	}
+	hooks.register(conn.(*sqlite3.SQLiteConn))
+	return conn, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
+
+// openEngine opens the database handler for dsn with the given options. The
+// handler keeps a single connection, so that all the calls made on a
+// database id share the temp tables, attached databases, pragmas and
+// transaction state set by the previous ones.
+func (p *SqflitePlugin) openEngine(dsn string, options engineOptions) *sql.DB {
+	engine := sql.OpenDB(&connector{
+		dsn:     dsn,
+		plugin:  p,
+		driver:  extensionDriver(p.driver, options.extensions),
+		options: options,
+	})
+	engine.SetMaxOpenConns(1)
+	engine.SetMaxIdleConns(1)
+	engine.SetConnMaxLifetime(0)
+	return engine
+}
+
+// verifyDatabase reads the schema of a newly opened database, as sqlite only
+// reads the file on the first statement. A file that is not a database, as
+// an encrypted one read without its key, fails with ERROR_INVALID_KEY.
+func verifyDatabase(ctx context.Context, db *sql.DB) error {
+	var count int64
+	err := db.QueryRowContext(ctx, "SELECT count(*) FROM sqlite_master").Scan(&count)
+	if sqliteErr, ok := err.(sqlite3.Error); ok && sqliteErr.Code == sqlite3.ErrNotADB {
+		return &codedError{code: ERROR_INVALID_KEY, err: errors.Wrap(err, "cannot read database, wrong key or not a database")}
+	}
+	return err
+}
+
+// pathDSN returns the DSN opening dbPath. The driver reads what follows a '?'
+// as its parameters, so a path containing one, as the Windows extended-length
+// paths, is passed as a file: URI.
+func pathDSN(dbPath string) string {
+	if strings.Contains(dbPath, "?") {
+		return fileURI(dbPath)
+	}
+	return dbPath
+}
+
+// fileURI returns the file: URI of dbPath, without parameters
+func fileURI(dbPath string) string {
+	return "file:" + strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23").Replace(dbPath)
+}
+
+// dsnParam adds the driver parameter param to dsn
+func dsnParam(dsn, param string) string {
+	if strings.Contains(dsn, "?") {
+		return dsn + "&" + param
+	}
+	return dsn + "?" + param
+}
+
+// uriDSN returns the URI opening dbPath with the given sqlite URI parameters,
+// such as cache=shared, for the connections of the process opened on the
+// same file with it to share a single page cache, locking each other per
+// table instead of waiting on the file lock, or mode=ro to open it read-only.
+// See https://www.sqlite.org/uri.html
+func uriDSN(dbPath string, params ...string) string {
+	return fileURI(dbPath) + "?" + strings.Join(params, "&")
+}
+
+// setupConn registers the plugin's SQL functions, the ones registered by the
+// app, and the virtual table modules on a new connection
+func (p *SqflitePlugin) setupConn(conn *sqlite3.SQLiteConn) error {
+	if err := conn.RegisterFunc("encrypt_col", p.columnKeys.encrypt, false); err != nil {
+		return err
+	}
+	if err := conn.RegisterFunc("decrypt_col", p.columnKeys.decrypt, true); err != nil {
+		return err
+	}
+	if err := registerHashFuncs(conn); err != nil {
+		return err
+	}
+	if err := p.registerDatetimeFuncs(conn); err != nil {
+		return err
+	}
+	if err := p.registerClockFuncs(conn); err != nil {
+		return err
+	}
+	if err := registerJSONFuncs(conn); err != nil {
+		return err
+	}
+	if err := registerMemoryFuncs(conn); err != nil {
+		return err
+	}
+	if err := registerRegexpFunc(conn); err != nil {
+		return err
+	}
+	if err := registerConnId(conn); err != nil {
+		return err
+	}
+	if err := p.registerFunctions(conn); err != nil {
+		return err
+	}
+	return createModules(conn, p.virtualTables())
+}
@@ -0,0 +1,172 @@
+package sqflite
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// TestSessionStateAcrossCalls checks that the temp tables, pragmas and
+// attached databases set by a call are seen by the next ones, with and
+// without a read pool
+func TestSessionStateAcrossCalls(t *testing.T) {
+	for _, poolSize := range []int32{0, 2} {
+		t.Run(fmt.Sprintf("readPoolSize=%d", poolSize), func(t *testing.T) {
+			p := newTestPlugin(t)
+			id := openTestDatabase(t, p, "session.db", map[interface{}]interface{}{
+				PARAM_JOURNAL_MODE:   "WAL",
+				PARAM_READ_POOL_SIZE: poolSize,
+			})
+
+			mustCall(t, p.handleExecute, sqlArgs(id, "CREATE TEMP TABLE tt (x)"))
+			mustCall(t, p.handleInsert, sqlArgs(id, "INSERT INTO tt VALUES (?)", int64(1)))
+			if v := queryValue(t, p, id, "SELECT x FROM tt"); v != int64(1) {
+				t.Errorf("SELECT x FROM tt = %v, want 1", v)
+			}
+
+			mustCall(t, p.handleExecute, sqlArgs(id, "PRAGMA cache_size = 1234"))
+			if v := queryValue(t, p, id, "PRAGMA cache_size"); v != int64(1234) {
+				t.Errorf("PRAGMA cache_size = %v, want 1234", v)
+			}
+
+			other := filepath.Join(t.TempDir(), "other.db")
+			mustCall(t, p.handleExecute, sqlArgs(id, "ATTACH DATABASE ? AS other", other))
+			mustCall(t, p.handleExecute, sqlArgs(id, "CREATE TABLE other.o (x)"))
+			mustCall(t, p.handleInsert, sqlArgs(id, "INSERT INTO other.o VALUES (?)", int64(2)))
+			if v := queryValue(t, p, id, "SELECT x FROM other.o"); v != int64(2) {
+				t.Errorf("SELECT x FROM other.o = %v, want 2", v)
+			}
+
+			// all of it still there once the other calls ran
+			if v := queryValue(t, p, id, "SELECT count(*) FROM tt JOIN other.o"); v != int64(1) {
+				t.Errorf("join = %v, want 1", v)
+			}
+		})
+	}
+}
+
+func TestTempStore(t *testing.T) {
+	for _, test := range []struct {
+		tempStore string
+		want      int64
+	}{
+		{"default", 0},
+		{"file", 1},
+		{"memory", 2},
+	} {
+		t.Run(test.tempStore, func(t *testing.T) {
+			p := newTestPlugin(t)
+			id := openTestDatabase(t, p, "temp.db", map[interface{}]interface{}{
+				PARAM_TEMP_STORE: test.tempStore,
+			})
+			if v := queryValue(t, p, id, "PRAGMA temp_store"); v != test.want {
+				t.Errorf("PRAGMA temp_store = %v, want %d", v, test.want)
+			}
+			mustCall(t, p.handleExecute, sqlArgs(id, "CREATE TEMP TABLE tt (x)"))
+			mustCall(t, p.handleInsert, sqlArgs(id, "INSERT INTO tt VALUES (?)", int64(1)))
+			if v := queryValue(t, p, id, "SELECT count(*) FROM tt"); v != int64(1) {
+				t.Errorf("count = %v, want 1", v)
+			}
+		})
+	}
+}
+
+func TestTempStoreInvalid(t *testing.T) {
+	p := newTestPlugin(t)
+	if _, err := p.handleOpenDatabase(map[interface{}]interface{}{
+		PARAM_PATH:       "temp.db",
+		PARAM_TEMP_STORE: "disk",
+	}); err == nil {
+		t.Error("opened with tempStore disk")
+	}
+}
+
+func TestFileURI(t *testing.T) {
+	for _, test := range []struct {
+		path, uri, dsn string
+	}{
+		{`/data/a.db`, `file:/data/a.db`, `/data/a.db`},
+		{`/data/a#b%c.db`, `file:/data/a%23b%25c.db`, `/data/a#b%c.db`},
+		{`/data/a?b.db`, `file:/data/a%3fb.db`, `file:/data/a%3fb.db`},
+		{`\\?\C:\db\a.db`, `file:\\%3f\C:\db\a.db`, `file:\\%3f\C:\db\a.db`},
+		{`\\?\UNC\server\share\a.db`, `file:\\%3f\UNC\server\share\a.db`, `file:\\%3f\UNC\server\share\a.db`},
+		{`\\server\share\a#1%.db`, `file:\\server\share\a%231%25.db`, `\\server\share\a#1%.db`},
+	} {
+		if uri := fileURI(test.path); uri != test.uri {
+			t.Errorf("fileURI(%s) = %s, want %s", test.path, uri, test.uri)
+		}
+		if dsn := pathDSN(test.path); dsn != test.dsn {
+			t.Errorf("pathDSN(%s) = %s, want %s", test.path, dsn, test.dsn)
+		}
+	}
+}
+
+// TestPathRoundTrip checks that the databases whose paths contain the
+// characters escaped by fileURI are opened at their path
+func TestPathRoundTrip(t *testing.T) {
+	names := []string{"a#b.db", "100%.db", "a%3fb.db", "a b.db"}
+	if runtime.GOOS != "windows" {
+		// not valid in Windows file names, where \\?\ is a path prefix
+		names = append(names, "a?b.db", "a?mode=ro.db", `\\?\a.db`)
+	}
+	for _, name := range names {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			dbPath := filepath.Join(dir, name)
+			p := newTestPlugin(t)
+			id := openTestDatabase(t, p, dbPath, nil)
+			mustCall(t, p.handleExecute, sqlArgs(id, "CREATE TABLE t (x)"))
+			mustCall(t, p.handleInsert, sqlArgs(id, "INSERT INTO t VALUES (?)", int64(1)))
+			mustCall(t, p.handleCloseDatabase, map[interface{}]interface{}{PARAM_ID: id})
+
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 || entries[0].Name() != name {
+				var files []string
+				for _, entry := range entries {
+					files = append(files, entry.Name())
+				}
+				t.Fatalf("files %q, want only %q", files, name)
+			}
+			id = openTestDatabase(t, p, dbPath, nil)
+			if v := queryValue(t, p, id, "SELECT x FROM t"); v != int64(1) {
+				t.Errorf("SELECT x FROM t = %v, want 1", v)
+			}
+		})
+	}
+}
+
+// TestRelativeDatabasesPath checks that a relative databases folder is resolved
+// against the working directory when set, and rejected after
+func TestRelativeDatabasesPath(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err = os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	p := newTestPlugin(t, WithDatabasesPath("databases"))
+	if err = os.Chdir(wd); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := p.getDatabasesPath(), filepath.Join(dir, "databases"); !samePath(got, want) {
+		t.Errorf("databases path = %s, want %s", got, want)
+	}
+	if err = os.MkdirAll(p.getDatabasesPath(), 0755); err != nil {
+		t.Fatal(err)
+	}
+	openTestDatabase(t, p, "relative.db", nil)
+	if _, err = os.Stat(filepath.Join(dir, "databases", "relative.db")); err != nil {
+		t.Errorf("database not created in the databases folder: %v", err)
+	}
+
+	if err = p.SetDatabasesPath("databases"); err == nil {
+		t.Error("relative databases path set")
+	}
+}
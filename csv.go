@@ -0,0 +1,170 @@
+package sqflite
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// name of the built-in CSV virtual table module
+const csvModule = "csv"
+
+// AllowCSVDir allows the csv virtual table module to read the files under dir.
+// No file can be read until a directory is allowed. With the sqlite_vtable
+// build tag, a CSV file can then be queried from SQL without importing it:
+//
+//	CREATE VIRTUAL TABLE temp.orders USING csv(filename='/data/orders.csv', header=yes);
+//
+// The columns are named after the header line, or c0, c1, ... without one.
+// All values are TEXT.
+func (p *SqflitePlugin) AllowCSVDir(dir string) error {
+	dir, err := resolvePath(dir)
+	if err != nil {
+		return err
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.csvDirs = append(p.csvDirs, dir)
+	return nil
+}
+
+// openCSVTable is the factory of the csv module
+func (p *SqflitePlugin) openCSVTable(args []string) (VirtualTable, error) {
+	t := &csvTable{}
+	for _, arg := range args {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) != 2 {
+			return nil, errors.New("invalid csv argument " + arg)
+		}
+		key, value := strings.TrimSpace(kv[0]), unquoteArg(strings.TrimSpace(kv[1]))
+		switch key {
+		case "filename":
+			t.path = value
+		case "header":
+			switch strings.ToLower(value) {
+			case "yes", "true", "on", "1":
+				t.header = true
+			case "no", "false", "off", "0":
+			default:
+				return nil, errors.New("invalid csv header " + value)
+			}
+		default:
+			return nil, errors.New("unknown csv argument " + key)
+		}
+	}
+	if t.path == "" {
+		return nil, errors.New("csv filename is not set")
+	}
+	path, err := resolvePath(t.path)
+	if err != nil {
+		return nil, err
+	}
+	if !p.csvAllowed(path) {
+		return nil, errors.New("csv file is not in an allowed directory: " + t.path)
+	}
+	t.path = path
+	f, err := os.Open(t.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	first, err := newCSVReader(f).Read()
+	if err != nil && err != io.EOF {
+		return nil, errors.Wrap(err, "failed to read csv header")
+	}
+	for i, name := range first {
+		if !t.header || name == "" {
+			name = fmt.Sprintf("c%d", i)
+		}
+		t.columns = append(t.columns, name)
+	}
+	return t, nil
+}
+
+func (p *SqflitePlugin) csvAllowed(path string) bool {
+	p.Lock()
+	defer p.Unlock()
+	for _, dir := range p.csvDirs {
+		rel, err := filepath.Rel(dir, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolvePath returns the absolute path of pt with symbolic links resolved
+func resolvePath(pt string) (string, error) {
+	abs, err := filepath.Abs(pt)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
+
+// unquoteArg removes the SQL quotes around a module argument
+func unquoteArg(s string) string {
+	if len(s) >= 2 && (s[0] == '\'' || s[0] == '"') && s[len(s)-1] == s[0] {
+		q := string(s[0])
+		return strings.Replace(s[1:len(s)-1], q+q, q, -1)
+	}
+	return s
+}
+
+func newCSVReader(r io.Reader) *csv.Reader {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+	return reader
+}
+
+type csvTable struct {
+	path    string
+	header  bool
+	columns []string
+}
+
+func (t *csvTable) Columns() []string {
+	return t.columns
+}
+
+func (t *csvTable) Open() (VirtualCursor, error) {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return nil, err
+	}
+	c := &csvCursor{file: f, reader: newCSVReader(f)}
+	if t.header {
+		if _, err = c.reader.Read(); err != nil && err != io.EOF {
+			f.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+type csvCursor struct {
+	file   *os.File
+	reader *csv.Reader
+}
+
+func (c *csvCursor) Next() ([]interface{}, error) {
+	record, err := c.reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	row := make([]interface{}, len(record))
+	for i, v := range record {
+		row[i] = v
+	}
+	return row, nil
+}
+
+func (c *csvCursor) Close() error {
+	return c.file.Close()
+}
@@ -0,0 +1,131 @@
+package sqflite
+
+import (
+	"database/sql"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// cursor is a query whose rows are read a page at a time, by
+// queryCursorNext calls
+type cursor struct {
+	sync.Mutex
+	id         int32
+	databaseId int32
+	rows       *sql.Rows
+	types      valueTypes
+	cols       []string
+	pageSize   int
+}
+
+// openCursor runs a query given PARAM_CURSOR_PAGE_SIZE, and returns its first
+// page: {columns, rows, cursorId}, cursorId being set while rows may remain.
+// The connection of the database stays reserved to its transaction and
+// cursors until the cursor is closed, at the end of the rows or with cancel.
+func (p *SqflitePlugin) openCursor(databaseId int32, db *sql.DB, sqlStr string, args []interface{}, pageSize int) (interface{}, error) {
+	conn, err := p.reserveConn(databaseId, db)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := conn.QueryContext(p.ctx, sqlStr, args...)
+	if err != nil {
+		p.releaseConn(databaseId)
+		return nil, err
+	}
+	cols, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		p.releaseConn(databaseId)
+		return nil, err
+	}
+	c := &cursor{
+		databaseId: databaseId,
+		rows:       rows,
+		types:      rowValueTypes(rows),
+		cols:       cols,
+		pageSize:   pageSize,
+	}
+	p.Lock()
+	p.lastCursorId++
+	c.id = p.lastCursorId
+	p.cursors[c.id] = c
+	p.Unlock()
+	return p.cursorPage(c)
+}
+
+// handleQueryCursorNext returns the next page of the cursor PARAM_CURSOR_ID,
+// or closes it with PARAM_CANCEL
+func (p *SqflitePlugin) handleQueryCursorNext(arguments interface{}) (reply interface{}, err error) {
+	args, ok := arguments.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("invalid arguments")
+	}
+	id, ok := args[PARAM_CURSOR_ID].(int32)
+	if !ok {
+		return nil, errors.New("invalid cursorId")
+	}
+	p.Lock()
+	c, ok := p.cursors[id]
+	p.Unlock()
+	if !ok {
+		return nil, errors.Errorf("cursor %d not found", id)
+	}
+	if cancel, _ := args[PARAM_CANCEL].(bool); cancel {
+		p.closeCursor(id)
+		return nil, nil
+	}
+	return p.cursorPage(c)
+}
+
+// cursorPage reads the next page of c, closing it after its last row
+func (p *SqflitePlugin) cursorPage(c *cursor) (interface{}, error) {
+	c.Lock()
+	resultRows, more, err := readRows(c.rows, c.types, len(c.cols), c.pageSize)
+	c.Unlock()
+	if err != nil {
+		p.closeCursor(c.id)
+		return nil, err
+	}
+	reply := map[interface{}]interface{}{
+		"columns": columnList(c.cols),
+		"rows":    resultRows,
+	}
+	if more {
+		reply[PARAM_CURSOR_ID] = c.id
+	} else {
+		p.closeCursor(c.id)
+	}
+	return reply, nil
+}
+
+// closeCursor closes the cursor id and releases the connection of its
+// database
+func (p *SqflitePlugin) closeCursor(id int32) {
+	p.Lock()
+	c, ok := p.cursors[id]
+	delete(p.cursors, id)
+	p.Unlock()
+	if !ok {
+		return
+	}
+	c.Lock()
+	c.rows.Close()
+	c.Unlock()
+	p.releaseConn(c.databaseId)
+}
+
+// closeCursors closes the cursors of databaseId
+func (p *SqflitePlugin) closeCursors(databaseId int32) {
+	var ids []int32
+	p.Lock()
+	for id, c := range p.cursors {
+		if c.databaseId == databaseId {
+			ids = append(ids, id)
+		}
+	}
+	p.Unlock()
+	for _, id := range ids {
+		p.closeCursor(id)
+	}
+}
@@ -0,0 +1,128 @@
+package sqflite
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// layout of the timestamps returned by the datetime functions, the same as the
+// sqlite datetime() function
+const datetimeLayout = "2006-01-02 15:04:05"
+
+// layouts of the timestamps accepted by the datetime functions, the time
+// values formats of sqlite
+var datetimeInputLayouts = []string{
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02 15:04Z07:00",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// locations already loaded by the datetime functions
+var locations sync.Map
+
+// Time zone SQL functions registered on every connection. The sqlite date and
+// time functions only know UTC and the zone of the process, these use the
+// time zone database of Go. Timestamps without offset are read as UTC,
+// numbers as unix times in seconds, and the result is formatted like the
+// result of datetime(). NULL gives an empty string, as a Go function cannot
+// return both TEXT and NULL, wrap the call in nullif() to get NULL.
+//
+//	datetime_local()         current local time
+//	datetime_local(ts)       ts in the local time zone
+//	tz_convert(ts, zone)     ts in the time zone named zone, like 'Europe/Paris'
+func (p *SqflitePlugin) registerDatetimeFuncs(conn *sqlite3.SQLiteConn) error {
+	if err := conn.RegisterFunc("datetime_local", p.datetimeLocalFunc, false); err != nil {
+		return err
+	}
+	return conn.RegisterFunc("tz_convert", tzConvertFunc, true)
+}
+
+func (p *SqflitePlugin) datetimeLocalFunc(args ...interface{}) (string, error) {
+	switch len(args) {
+	case 0:
+		return p.now().Local().Format(datetimeLayout), nil
+	case 1:
+		return convertTimestamp(args[0], time.Local)
+	}
+	return "", errors.New("wrong number of arguments to function datetime_local()")
+}
+
+func tzConvertFunc(ts interface{}, zone string) (string, error) {
+	loc, err := loadLocation(zone)
+	if err != nil {
+		return "", err
+	}
+	return convertTimestamp(ts, loc)
+}
+
+// convertTimestamp returns the sqlite time value ts in the time zone loc
+func convertTimestamp(ts interface{}, loc *time.Location) (string, error) {
+	t, ok, err := parseTimestamp(ts)
+	if err != nil || !ok {
+		return "", err
+	}
+	return t.In(loc).Format(datetimeLayout), nil
+}
+
+// parseTimestamp reads a sqlite time value, ok is false for NULL
+func parseTimestamp(ts interface{}) (t time.Time, ok bool, err error) {
+	switch v := ts.(type) {
+	case nil:
+		return t, false, nil
+	case int64:
+		return time.Unix(v, 0), true, nil
+	case float64:
+		sec := int64(v)
+		return time.Unix(sec, int64((v-float64(sec))*float64(time.Second))), true, nil
+	case []byte:
+		if v == nil {
+			return t, false, nil
+		}
+		return parseTimestamp(string(v))
+	case string:
+		s := strings.TrimSpace(v)
+		if s == "" {
+			return t, false, nil
+		}
+		if n, err := strconv.ParseFloat(s, 64); err == nil {
+			return parseTimestamp(n)
+		}
+		s = strings.Replace(s, "T", " ", 1)
+		if strings.HasSuffix(s, "z") {
+			s = s[:len(s)-1] + "Z"
+		}
+		for _, layout := range datetimeInputLayouts {
+			if t, err = time.ParseInLocation(layout, s, time.UTC); err == nil {
+				return t, true, nil
+			}
+		}
+	}
+	return t, false, errors.Errorf("invalid timestamp %v", ts)
+}
+
+// loadLocation returns the time zone named zone, "local" and "utc" being the
+// zone of the process and UTC
+func loadLocation(zone string) (*time.Location, error) {
+	switch strings.ToLower(zone) {
+	case "local", "localtime":
+		return time.Local, nil
+	case "utc":
+		return time.UTC, nil
+	}
+	if loc, ok := locations.Load(zone); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	locations.Store(zone, loc)
+	return loc, nil
+}
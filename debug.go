@@ -0,0 +1,52 @@
+package sqflite
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// sqflite log levels, set with the options method
+const (
+	LOG_LEVEL_NONE    = 0 // no logs
+	LOG_LEVEL_SQL     = 1 // the statements run
+	LOG_LEVEL_VERBOSE = 2 // the statements run and the internal logs
+)
+
+// debug commands of the sqflite dev tools
+const (
+	DEBUG_CMD_GET = "get" // returns the log level and the open databases
+)
+
+// handleDebug answers the debug commands of the sqflite dev tools. PARAM_CMD
+// DEBUG_CMD_GET returns {logLevel, databases: {id: {path, singleInstance,
+// logLevel}}}, the log levels given when not LOG_LEVEL_NONE and the databases
+// when some are open. Other commands return an empty map, as on Android.
+func (p *SqflitePlugin) handleDebug(arguments interface{}) (reply interface{}, err error) {
+	args, _ := arguments.(map[interface{}]interface{})
+	info := map[interface{}]interface{}{}
+	if cmd, _ := args[PARAM_CMD].(string); cmd != DEBUG_CMD_GET {
+		return info, nil
+	}
+	p.Lock()
+	defer p.Unlock()
+	if logLevel := atomic.LoadInt32(&p.logLevel); logLevel > LOG_LEVEL_NONE {
+		info[PARAM_LOG_LEVEL] = logLevel
+	}
+	if len(p.databases) == 0 {
+		return info, nil
+	}
+	databases := make(map[interface{}]interface{}, len(p.databases))
+	for id := range p.databases {
+		db := map[interface{}]interface{}{
+			PARAM_PATH:            p.databasePaths[id],
+			PARAM_SINGLE_INSTANCE: p.singleInstances[id],
+		}
+		if logLevel := p.logLevels[id]; logLevel > LOG_LEVEL_NONE {
+			db[PARAM_LOG_LEVEL] = logLevel
+		}
+		// the ids are the keys of a JSON object on the Dart side
+		databases[fmt.Sprint(id)] = db
+	}
+	info["databases"] = databases
+	return info, nil
+}
@@ -0,0 +1,248 @@
+package sqflite
+
+import (
+	"encoding/binary"
+	"math"
+	"math/big"
+	"reflect"
+
+	"github.com/go-flutter-desktop/go-flutter/plugin"
+	"github.com/pkg/errors"
+)
+
+// types of the values of the standard message codec
+const (
+	codecNull = iota
+	codecTrue
+	codecFalse
+	codecInt32
+	codecInt64
+	codecBigInt
+	codecFloat64
+	codecString
+	codecByteSlice
+	codecInt32Slice
+	codecInt64Slice
+	codecFloat64Slice
+	codecList
+	codecMap
+)
+
+// maximum nesting of the lists and maps of a message
+const maxDecodeDepth = 64
+
+// decodeMethodCall decodes a method call encoded with the standard method
+// codec, into the same values as plugin.StandardMethodCodec. The sizes read
+// from the message are checked against its length, so a malformed message
+// returns an error instead of panicking or allocating unbounded memory, as the
+// go-flutter decoder does for negative or huge sizes and unhashable map keys.
+// Values are read in little endian, the byte order of the desktop platforms.
+func decodeMethodCall(data []byte) (call plugin.MethodCall, err error) {
+	d := &decoder{data: data}
+	method, err := d.value(0)
+	if err != nil {
+		return call, errors.Wrap(err, "failed to decode method name")
+	}
+	var ok bool
+	if call.Method, ok = method.(string); !ok {
+		return call, errors.New("decoded method name is not a string")
+	}
+	if call.Arguments, err = d.value(0); err != nil {
+		return call, errors.Wrap(err, "failed to decode arguments of "+call.Method)
+	}
+	return call, nil
+}
+
+// decoder reads the values of a message of the standard message codec
+type decoder struct {
+	data []byte
+	pos  int
+}
+
+var errTruncated = errors.New("message corrupted: not enough bytes")
+
+func (d *decoder) next(n int) ([]byte, error) {
+	if n < 0 || n > len(d.data)-d.pos {
+		return nil, errTruncated
+	}
+	b := d.data[d.pos : d.pos+n]
+	d.pos += n
+	return b, nil
+}
+
+func (d *decoder) align(alignment int) error {
+	if mod := d.pos % alignment; mod != 0 {
+		_, err := d.next(alignment - mod)
+		return err
+	}
+	return nil
+}
+
+// size reads a size, checking that at least size*unit bytes follow
+func (d *decoder) size(unit int) (int, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, err
+	}
+	n := int(b[0])
+	switch n {
+	case 254:
+		if b, err = d.next(2); err != nil {
+			return 0, err
+		}
+		n = int(binary.LittleEndian.Uint16(b))
+	case 255:
+		if b, err = d.next(4); err != nil {
+			return 0, err
+		}
+		n = int(binary.LittleEndian.Uint32(b))
+	}
+	if n > (len(d.data)-d.pos)/unit {
+		return 0, errTruncated
+	}
+	return n, nil
+}
+
+func (d *decoder) bytes() ([]byte, error) {
+	n, err := d.size(1)
+	if err != nil {
+		return nil, err
+	}
+	return d.next(n)
+}
+
+func (d *decoder) value(depth int) (interface{}, error) {
+	if depth > maxDecodeDepth {
+		return nil, errors.New("message nested too deeply")
+	}
+	t, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	switch t[0] {
+	case codecNull:
+		return nil, nil
+	case codecTrue:
+		return true, nil
+	case codecFalse:
+		return false, nil
+	case codecInt32:
+		b, err := d.next(4)
+		if err != nil {
+			return nil, err
+		}
+		return int32(binary.LittleEndian.Uint32(b)), nil
+	case codecInt64:
+		b, err := d.next(8)
+		if err != nil {
+			return nil, err
+		}
+		return int64(binary.LittleEndian.Uint64(b)), nil
+	case codecBigInt:
+		b, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+		n, ok := new(big.Int).SetString(string(b), 16)
+		if !ok {
+			return nil, errors.New("invalid binary encoding for bigint")
+		}
+		return n, nil
+	case codecFloat64:
+		if err = d.align(8); err != nil {
+			return nil, err
+		}
+		b, err := d.next(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+	case codecString:
+		b, err := d.bytes()
+		return string(b), err
+	case codecByteSlice:
+		b, err := d.bytes()
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), b...), nil
+	case codecInt32Slice:
+		n, err := d.size(1)
+		if err != nil {
+			return nil, err
+		}
+		if err = d.align(4); err != nil {
+			return nil, err
+		}
+		b, err := d.next(n * 4)
+		if err != nil {
+			return nil, err
+		}
+		v := make([]int32, n)
+		for i := range v {
+			v[i] = int32(binary.LittleEndian.Uint32(b[i*4:]))
+		}
+		return v, nil
+	case codecInt64Slice, codecFloat64Slice:
+		n, err := d.size(1)
+		if err != nil {
+			return nil, err
+		}
+		if err = d.align(8); err != nil {
+			return nil, err
+		}
+		b, err := d.next(n * 8)
+		if err != nil {
+			return nil, err
+		}
+		if t[0] == codecInt64Slice {
+			v := make([]int64, n)
+			for i := range v {
+				v[i] = int64(binary.LittleEndian.Uint64(b[i*8:]))
+			}
+			return v, nil
+		}
+		v := make([]float64, n)
+		for i := range v {
+			v[i] = math.Float64frombits(binary.LittleEndian.Uint64(b[i*8:]))
+		}
+		return v, nil
+	case codecList:
+		// each value takes at least one byte
+		n, err := d.size(1)
+		if err != nil {
+			return nil, err
+		}
+		list := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	case codecMap:
+		n, err := d.size(2)
+		if err != nil {
+			return nil, err
+		}
+		m := make(map[interface{}]interface{}, n)
+		for i := 0; i < n; i++ {
+			k, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if k != nil && !reflect.TypeOf(k).Comparable() {
+				return nil, errors.Errorf("invalid map key of type %T", k)
+			}
+			v, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = v
+		}
+		return m, nil
+	}
+	return nil, errors.Errorf("unknown value type %d", t[0])
+}
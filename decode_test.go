@@ -0,0 +1,138 @@
+package sqflite
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/go-flutter-desktop/go-flutter/plugin"
+)
+
+// seedMethodCalls adds to f the method calls of a typical session, encoded
+// by the go-flutter codec
+func seedMethodCalls(f *testing.F) {
+	codec := plugin.StandardMethodCodec{}
+	calls := []plugin.MethodCall{
+		{Method: METHOD_GET_PLATFORM_VERSION},
+		{Method: METHOD_DEBUG, Arguments: map[interface{}]interface{}{PARAM_CMD: DEBUG_CMD_GET}},
+		{Method: METHOD_QUERY, Arguments: map[interface{}]interface{}{
+			PARAM_ID:             int32(1),
+			PARAM_SQL:            "SELECT * FROM t WHERE a = ? AND b = ?",
+			PARAM_SQL_ARGUMENTS:  []interface{}{int64(1 << 40), "x", 1.5, []byte{1, 2}, nil, true},
+			PARAM_IN_TRANSACTION: false,
+		}},
+		{Method: METHOD_BATCH, Arguments: map[interface{}]interface{}{
+			PARAM_ID: int32(1),
+			PARAM_OPERATIONS: []interface{}{
+				map[interface{}]interface{}{PARAM_METHOD: METHOD_EXECUTE, PARAM_SQL: "CREATE TABLE t (a)"},
+				map[interface{}]interface{}{PARAM_METHOD: METHOD_INSERT, PARAM_SQL: "INSERT INTO t VALUES (?)", PARAM_SQL_ARGUMENTS: []interface{}{int32(1)}},
+			},
+		}},
+		{Method: METHOD_INSERT, Arguments: map[interface{}]interface{}{
+			PARAM_ID:            int32(1),
+			PARAM_SQL:           "INSERT INTO t VALUES (?, ?, ?, ?)",
+			PARAM_SQL_ARGUMENTS: []interface{}{big.NewInt(42), []int32{1, 2}, []int64{3}, []float64{4.5}},
+		}},
+	}
+	for _, call := range calls {
+		data, err := codec.EncodeMethodCall(call)
+		if err != nil {
+			f.Fatalf("failed to encode %s: %v", call.Method, err)
+		}
+		f.Add(data)
+	}
+	f.Add([]byte{})
+	f.Add([]byte{codecString, 255, 255, 255, 255, 127})
+	f.Add([]byte{codecString, 1, 'm', codecList, 254, 255, 255})
+	f.Add([]byte{codecString, 1, 'm', codecMap, 1, codecList, 0, codecNull})
+}
+
+// FuzzDecodeMethodCall checks that decodeMethodCall never panics, and that a
+// decoded call encodes back to a message decoding to the same method
+func FuzzDecodeMethodCall(f *testing.F) {
+	seedMethodCalls(f)
+	codec := plugin.StandardMethodCodec{}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		call, err := decodeMethodCall(data)
+		if err != nil {
+			return
+		}
+		encoded, err := codec.EncodeMethodCall(call)
+		if err != nil {
+			t.Fatalf("failed to encode decoded call %s: %v", call.Method, err)
+		}
+		again, err := decodeMethodCall(encoded)
+		if err != nil {
+			t.Fatalf("failed to decode encoded call %s: %v", call.Method, err)
+		}
+		if again.Method != call.Method {
+			t.Fatalf("method %q decoded back as %q", call.Method, again.Method)
+		}
+	})
+}
+
+// testMessenger keeps the handlers of the channels set by InitPlugin
+type testMessenger struct {
+	handlers map[string]plugin.ChannelHandlerFunc
+}
+
+func (m *testMessenger) Send(channel string, binaryMessage []byte) ([]byte, error) {
+	return nil, nil
+}
+
+func (m *testMessenger) SetChannelHandler(channel string, handler plugin.ChannelHandlerFunc) {
+	m.handlers[channel] = handler
+}
+
+// testResponse receives the reply of a method call
+type testResponse chan []byte
+
+func (r testResponse) Send(binaryReply []byte) {
+	r <- binaryReply
+}
+
+// fuzzedMethods are the methods whose calls are dispatched by
+// FuzzHandleMessage, those not taking paths outside of the databases folder
+var fuzzedMethods = map[string]bool{
+	METHOD_INSERT:               true,
+	METHOD_BATCH:                true,
+	METHOD_EXECUTE:              true,
+	METHOD_UPDATE:               true,
+	METHOD_QUERY:                true,
+	METHOD_QUERY_CURSOR_NEXT:    true,
+	METHOD_GET_PLATFORM_VERSION: true,
+	METHOD_GET_DATABASES_PATH:   true,
+	METHOD_GET_LAST_ERROR:       true,
+	METHOD_GET_STATS:            true,
+	METHOD_GET_CAPABILITIES:     true,
+	METHOD_GET_MEMORY_USAGE:     true,
+	METHOD_DEBUG:                true,
+	METHOD_OPTIONS:              true,
+	METHOD_CANCEL_QUERY:         true,
+}
+
+// FuzzHandleMessage checks that the method channel replies to every message,
+// decoded or not, without the plugin panicking
+func FuzzHandleMessage(f *testing.F) {
+	seedMethodCalls(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		if call, err := decodeMethodCall(data); err == nil && !fuzzedMethods[call.Method] {
+			return
+		}
+		p := newTestPlugin(t)
+		messenger := &testMessenger{handlers: make(map[string]plugin.ChannelHandlerFunc)}
+		if err := p.InitPlugin(messenger); err != nil {
+			t.Fatal(err)
+		}
+		openTestDatabase(t, p, ":memory:", nil)
+		response := make(testResponse, 1)
+		if err := messenger.handlers[channelName](data, response); err != nil {
+			t.Fatalf("handleMessage: %v", err)
+		}
+		select {
+		case <-response:
+		case <-time.After(10 * time.Second):
+			t.Fatal("no reply to the message")
+		}
+	})
+}
@@ -0,0 +1,232 @@
+package sqflite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// alias of the second database while diffing
+const diffSchema = "diff_b"
+
+// handleDiffDatabases compares two database files, absolute or relative to
+// the databases folder, table by table. For each table it reports the number
+// of rows only found in either database and the number of rows whose values
+// differ, matched on the primary key (or rowid). With PARAM_INCLUDE_KEYS the
+// keys of those rows are returned as well.
+func (p *SqflitePlugin) handleDiffDatabases(arguments interface{}) (reply interface{}, err error) {
+	args, ok := arguments.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("invalid arguments")
+	}
+	pathA, _ := args[PARAM_PATH_A].(string)
+	pathB, _ := args[PARAM_PATH_B].(string)
+	if pathA == "" || pathB == "" {
+		return nil, errors.New("pathA and pathB must be set")
+	}
+	pathA, pathB = p.resolvePath(pathA), p.resolvePath(pathB)
+	includeKeys, _ := args[PARAM_INCLUDE_KEYS].(bool)
+	for _, pt := range []string{pathA, pathB} {
+		if _, err = os.Stat(pt); err != nil {
+			return nil, errors.Wrap(err, "cannot diff "+pt)
+		}
+	}
+	return p.diffDatabases(pathA, pathB, includeKeys)
+}
+
+// diffDatabases compares the databases pathA and pathB on a connection of
+// the plugin, with its driver, functions and busy timeout, pathB attached
+func (p *SqflitePlugin) diffDatabases(pathA, pathB string, includeKeys bool) (map[interface{}]interface{}, error) {
+	ctx := p.ctx
+	// the engine keeps a single connection, the one pathB is attached to
+	db := p.openEngine(fileDSN(pathA), engineOptions{busyTimeout: p.busyTimeout})
+	defer db.Close()
+	if _, err := db.ExecContext(ctx, "ATTACH DATABASE ? AS "+diffSchema, pathB); err != nil {
+		return nil, err
+	}
+	tablesA, err := listTables(ctx, db, "main")
+	if err != nil {
+		return nil, err
+	}
+	tablesB, err := listTables(ctx, db, diffSchema)
+	if err != nil {
+		return nil, err
+	}
+	identical := true
+	tables := make(map[interface{}]interface{})
+	for _, t := range tablesA {
+		if indexOf(tablesB, t) < 0 {
+			tables[t] = map[interface{}]interface{}{"status": "onlyInA"}
+			identical = false
+			continue
+		}
+		d, err := diffTable(ctx, db, t, includeKeys)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to diff "+t)
+		}
+		if d["status"] != "same" {
+			identical = false
+		}
+		tables[t] = d
+	}
+	for _, t := range tablesB {
+		if indexOf(tablesA, t) < 0 {
+			tables[t] = map[interface{}]interface{}{"status": "onlyInB"}
+			identical = false
+		}
+	}
+	return map[interface{}]interface{}{
+		"identical": identical,
+		"tables":    tables,
+	}, nil
+}
+
+func diffTable(ctx context.Context, db *sql.DB, table string, includeKeys bool) (map[interface{}]interface{}, error) {
+	colsA, err := tableColumns(ctx, db, "main", table)
+	if err != nil {
+		return nil, err
+	}
+	colsB, err := tableColumns(ctx, db, diffSchema, table)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Join(colsA, "\x00") != strings.Join(colsB, "\x00") {
+		return map[interface{}]interface{}{"status": "schemaChanged"}, nil
+	}
+	pk, err := primaryKeyColumns(ctx, db, table)
+	if err != nil {
+		return nil, err
+	}
+	// key columns in the base tables, and columns of the compared rows
+	keyList, rowSel := "rowid", "rowid AS _rowid, *"
+	keyLHS, changedLHS := "rowid", "_rowid"
+	if len(pk) > 0 {
+		var quoted []string
+		for _, c := range pk {
+			quoted = append(quoted, quoteIdentifier(c))
+		}
+		keyList, rowSel = strings.Join(quoted, ", "), "*"
+		keyLHS, changedLHS = keyList, keyList
+		if len(pk) > 1 {
+			// row values
+			keyLHS, changedLHS = "("+keyList+")", "("+keyList+")"
+		}
+	}
+	ta, tb := "main."+quoteIdentifier(table), diffSchema+"."+quoteIdentifier(table)
+	changedKeys := keyList
+	if len(pk) == 0 {
+		changedKeys = "_rowid"
+	}
+	queries := map[string]string{
+		"onlyInA": fmt.Sprintf("SELECT %s FROM %s WHERE %s NOT IN (SELECT %s FROM %s)", keyList, ta, keyLHS, keyList, tb),
+		"onlyInB": fmt.Sprintf("SELECT %s FROM %s WHERE %s NOT IN (SELECT %s FROM %s)", keyList, tb, keyLHS, keyList, ta),
+		"changed": fmt.Sprintf("SELECT %s FROM (SELECT %s FROM %s EXCEPT SELECT %s FROM %s) WHERE %s IN (SELECT %s FROM %s)",
+			changedKeys, rowSel, ta, rowSel, tb, changedLHS, keyList, tb),
+	}
+	result := map[interface{}]interface{}{"status": "same"}
+	var keys map[interface{}]interface{}
+	if includeKeys {
+		keys = make(map[interface{}]interface{})
+		result["keys"] = keys
+	}
+	for name, q := range queries {
+		rows, err := db.QueryContext(ctx, q)
+		if err != nil {
+			return nil, err
+		}
+		n, list, err := collectKeys(rows, includeKeys)
+		if err != nil {
+			return nil, err
+		}
+		result[name] = n
+		if includeKeys {
+			keys[name] = list
+		}
+		if n > 0 {
+			result["status"] = "changed"
+		}
+	}
+	return result, nil
+}
+
+// collectKeys counts the rows and optionally returns their keys, a key being
+// a single value or a list of values for composite keys
+func collectKeys(rows *sql.Rows, includeKeys bool) (int64, []interface{}, error) {
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return 0, nil, err
+	}
+	var n int64
+	var keys []interface{}
+	for rows.Next() {
+		n++
+		if !includeKeys {
+			continue
+		}
+		values := make([]interface{}, len(cols))
+		dest := make([]interface{}, len(cols))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return 0, nil, err
+		}
+		for i, v := range values {
+			if b, ok := v.([]byte); ok {
+				values[i] = string(b)
+			}
+		}
+		if len(values) == 1 {
+			keys = append(keys, values[0])
+		} else {
+			keys = append(keys, values)
+		}
+	}
+	return n, keys, rows.Err()
+}
+
+// listTables returns the user tables of a schema
+func listTables(ctx context.Context, q queryer, schema string) ([]string, error) {
+	rows, err := q.QueryContext(ctx, "SELECT name FROM "+schema+".sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tables = append(tables, name)
+	}
+	return tables, rows.Err()
+}
+
+// primaryKeyColumns returns the primary key columns of main.table in key order
+func primaryKeyColumns(ctx context.Context, q queryer, table string) ([]string, error) {
+	rows, err := q.QueryContext(ctx, fmt.Sprintf("SELECT name FROM pragma_table_info(%s) WHERE pk > 0 ORDER BY pk", quoteString(table)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var cols []string
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		cols = append(cols, name)
+	}
+	return cols, rows.Err()
+}
+
+// quoteString quotes a string literal for use in generated SQL
+func quoteString(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
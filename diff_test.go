@@ -0,0 +1,32 @@
+package sqflite
+
+import "testing"
+
+func TestDiffDatabasesRelativePaths(t *testing.T) {
+	p := newTestPlugin(t)
+	for i, name := range []string{"a.db", "b.db"} {
+		id := openTestDatabase(t, p, name, map[interface{}]interface{}{PARAM_JOURNAL_MODE: "WAL"})
+		mustCall(t, p.handleExecute, sqlArgs(id, "CREATE TABLE t (id INTEGER PRIMARY KEY, x)"))
+		mustCall(t, p.handleInsert, sqlArgs(id, "INSERT INTO t VALUES (1, 'same')"))
+		mustCall(t, p.handleInsert, sqlArgs(id, "INSERT INTO t VALUES (2, ?)", int64(i)))
+	}
+	// the databases are still open, in WAL mode
+	reply := mustCall(t, p.handleDiffDatabases, map[interface{}]interface{}{
+		PARAM_PATH_A: "a.db",
+		PARAM_PATH_B: "b.db",
+	}).(map[interface{}]interface{})
+	if reply["identical"] != false {
+		t.Errorf("identical = %v, want false", reply["identical"])
+	}
+	table, _ := reply["tables"].(map[interface{}]interface{})["t"].(map[interface{}]interface{})
+	if table["status"] != "changed" {
+		t.Errorf("table t = %v, want changed", table)
+	}
+
+	if _, err := p.handleDiffDatabases(map[interface{}]interface{}{
+		PARAM_PATH_A: "a.db",
+		PARAM_PATH_B: "missing.db",
+	}); err == nil {
+		t.Error("diffed a missing database")
+	}
+}
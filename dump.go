@@ -0,0 +1,125 @@
+package sqflite
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// name of the event channel of the progress of the dump imports
+const importChannelName = channelName + "/import"
+
+// ImportDump runs the statements of dump, as written by the .dump command of
+// the sqlite3 shell, on db in a single transaction, and returns the number of
+// statements run. The transaction statements of the dump are skipped. When a
+// statement fails, the transaction is rolled back and a *ScriptError tells
+// which one. progress, when set, is called with the number of statements run
+// and their total as the import goes.
+func ImportDump(ctx context.Context, db *sql.DB, dump string, progress func(done, total int)) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	n, err := importDump(ctx, tx, dump, progress)
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// handleImportDump imports the dump file PARAM_PATH into the database PARAM_ID
+// with ImportDump, within its open transaction if any, and returns the number
+// of statements run. The progress is sent on the import event channel as
+// {id, path, done, total}.
+func (p *SqflitePlugin) handleImportDump(arguments interface{}) (reply interface{}, err error) {
+	databaseId, db, err := p.getDatabase(arguments)
+	if err != nil {
+		return nil, err
+	}
+	dumpPath, _ := arguments.(map[interface{}]interface{})[PARAM_PATH].(string)
+	if dumpPath == "" {
+		return nil, errors.New("dump path is not set")
+	}
+	dump, err := ioutil.ReadFile(dumpPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the dump")
+	}
+	if cache := p.getQueryCache(databaseId); cache != nil {
+		defer cache.clear()
+	}
+	p.Lock()
+	importEvents := p.importEvents
+	p.Unlock()
+	progress := func(done, total int) {
+		importEvents.send(map[interface{}]interface{}{
+			PARAM_ID:   databaseId,
+			PARAM_PATH: dumpPath,
+			"done":     int64(done),
+			"total":    int64(total),
+		})
+	}
+	ctx := p.ctx
+	exec := p.executor(databaseId, db, arguments)
+	var tx *sql.Tx
+	if _, open := p.transactionId(databaseId); !open {
+		if tx, err = exec.(txBeginner).BeginTx(ctx, nil); err != nil {
+			return nil, err
+		}
+		defer tx.Rollback()
+		exec = tx
+	}
+	n, err := importDump(ctx, exec, string(dump), progress)
+	if err != nil {
+		return nil, err
+	}
+	if tx != nil {
+		if err = tx.Commit(); err != nil {
+			return nil, err
+		}
+	}
+	return int64(n), nil
+}
+
+// importDump runs the statements of dump on exec, but its transaction
+// statements, reporting the progress about every percent
+func importDump(ctx context.Context, exec executor, dump string, progress func(done, total int)) (int, error) {
+	var statements []scriptStatement
+	for _, s := range splitScript(dump) {
+		switch dumpCommand(s.sql) {
+		case "BEGIN", "COMMIT", "END":
+			continue
+		case "ROLLBACK":
+			// the shell ends the dump of a database it failed to read so
+			return 0, errors.Errorf("the dump rolls back at line %d, it is incomplete", s.line)
+		}
+		statements = append(statements, s)
+	}
+	step := len(statements) / 100
+	if step == 0 {
+		step = 1
+	}
+	for i, s := range statements {
+		if _, err := exec.ExecContext(ctx, s.sql); err != nil {
+			return i, &ScriptError{Index: i, Line: s.line, SQL: s.sql, Err: err}
+		}
+		if progress != nil && ((i+1)%step == 0 || i+1 == len(statements)) {
+			progress(i+1, len(statements))
+		}
+	}
+	return len(statements), nil
+}
+
+// dumpCommand returns the first keyword of the statement sql, in upper case
+func dumpCommand(sql string) string {
+	fields := strings.FieldsFunc(sql, func(r rune) bool {
+		return r == ' ' || r == '\t' || r == '\r' || r == '\n' || r == ';'
+	})
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToUpper(fields[0])
+}
@@ -0,0 +1,69 @@
+package sqflite
+
+import (
+	"sync"
+
+	"github.com/go-flutter-desktop/go-flutter/plugin"
+)
+
+// eventChannel is the host side of a Dart EventChannel using the standard
+// method codec. go-flutter has no event channel, so the "listen" and "cancel"
+// calls of the Dart side are handled here, and the events are sent as success
+// envelopes while a stream is listening.
+type eventChannel struct {
+	sync.Mutex
+	messenger plugin.BinaryMessenger
+	name      string
+	codec     plugin.StandardMethodCodec
+	logger    LevelLogger
+	listening bool
+}
+
+func newEventChannel(messenger plugin.BinaryMessenger, name string, logger LevelLogger) *eventChannel {
+	c := &eventChannel{messenger: messenger, name: name, logger: logger}
+	messenger.SetChannelHandler(name, c.handleMessage)
+	return c
+}
+
+func (c *eventChannel) handleMessage(binaryMessage []byte, r plugin.ResponseSender) error {
+	call, err := decodeMethodCall(binaryMessage)
+	if err != nil {
+		r.Send(nil)
+		return err
+	}
+	c.Lock()
+	switch call.Method {
+	case "listen":
+		c.listening = true
+	case "cancel":
+		c.listening = false
+	default:
+		c.Unlock()
+		r.Send(nil)
+		return nil
+	}
+	c.Unlock()
+	reply, err := c.codec.EncodeSuccessEnvelope(nil)
+	r.Send(reply)
+	return err
+}
+
+// send emits an event to the listening Dart stream, if any
+func (c *eventChannel) send(event interface{}) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	listening := c.listening
+	c.Unlock()
+	if !listening {
+		return
+	}
+	data, err := c.codec.EncodeSuccessEnvelope(event)
+	if err == nil {
+		_, err = c.messenger.Send(c.name, data)
+	}
+	if err != nil {
+		c.logger.Errorf("failed to send event on %s: %v", c.name, err)
+	}
+}
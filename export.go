@@ -0,0 +1,257 @@
+package sqflite
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// scrubbing rules of an anonymized export
+const (
+	SCRUB_HASH = "hash" // sha256 of the value, hex encoded
+	SCRUB_NULL = "null" // replaced by NULL
+	SCRUB_FAKE = "fake" // replaced by a fake value of the same type, or "fake:<kind>"
+)
+
+var (
+	fakeFirstNames = []string{"Alex", "Sam", "Charlie", "Jordan", "Taylor", "Morgan", "Casey", "Robin", "Jamie", "Drew"}
+	fakeLastNames  = []string{"Smith", "Garcia", "Martin", "Rossi", "Novak", "Kim", "Silva", "Muller", "Dubois", "Jensen"}
+)
+
+// handleExportDatabase copies the database to PARAM_PATH, absolute or relative
+// to the databases folder. When PARAM_ANONYMIZE maps tables to {column: rule}
+// the copy is scrubbed with those rules and vacuumed, so the original values
+// do not survive in free pages. The hashed and fake values are derived from
+// PARAM_SALT, a random salt returned in the reply when not given, so that the
+// hashes cannot be reversed with a dictionary.
+func (p *SqflitePlugin) handleExportDatabase(arguments interface{}) (reply interface{}, err error) {
+	databaseId, _, err := p.getDatabase(arguments)
+	if err != nil {
+		return nil, err
+	}
+	args := arguments.(map[interface{}]interface{})
+	dest, _ := args[PARAM_PATH].(string)
+	if dest == "" {
+		return nil, errors.New("export path is not set")
+	}
+	rules, err := getScrubRules(args[PARAM_ANONYMIZE])
+	if err != nil {
+		return nil, err
+	}
+	salt, _ := args[PARAM_SALT].(string)
+	if salt == "" {
+		if salt, err = randomSalt(); err != nil {
+			return nil, err
+		}
+	}
+	p.Lock()
+	dbPath := p.databasePaths[databaseId]
+	p.Unlock()
+	if dbPath == MEMORY_DATABASE_PATH {
+		return nil, errors.New("cannot export a memory database")
+	}
+	dest = p.resolvePath(dest)
+	if overlapsDatabaseFiles(dest, dbPath) {
+		return nil, errors.New("cannot export a database onto itself")
+	}
+	if err = os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return nil, err
+	}
+	if err = backupFile(dbPath, dest); err != nil {
+		return nil, err
+	}
+	scrubbed := make(map[interface{}]interface{})
+	if len(rules) > 0 {
+		if scrubbed, err = scrubDatabase(dest, rules, salt); err != nil {
+			os.Remove(dest)
+			return nil, errors.Wrap(err, "failed to anonymize export")
+		}
+	}
+	return map[interface{}]interface{}{
+		PARAM_PATH: dest,
+		PARAM_SALT: salt,
+		"scrubbed": scrubbed,
+	}, nil
+}
+
+// randomSalt returns a random salt of the scrubbed values, hex encoded
+func randomSalt() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "failed to generate salt")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// overlapsDatabaseFiles tells if the file at dest is the database at dbPath or
+// one of its journal files, or has the database as one of its own
+func overlapsDatabaseFiles(dest, dbPath string) bool {
+	for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+		if samePath(dest, dbPath+suffix) || samePath(dest+suffix, dbPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// scrubDatabase applies the rules to the database file at dbPath and returns
+// the number of rows updated per table
+func scrubDatabase(dbPath string, rules map[string]map[string]string, salt string) (map[interface{}]interface{}, error) {
+	c, err := (&sqlite3.SQLiteDriver{}).Open(fileDSN(dbPath))
+	if err != nil {
+		return nil, err
+	}
+	conn := c.(*sqlite3.SQLiteConn)
+	defer conn.Close()
+	// registered functions cannot return a dynamic type, so the storage class
+	// of the scrubbed values is chosen in SQL, see scrubExpr
+	text := func(rule string, value interface{}) (string, error) {
+		v, err := scrubValue(rule, value, salt)
+		s, _ := v.(string)
+		return s, err
+	}
+	integer := func(value interface{}) (int64, error) {
+		v, err := scrubValue(SCRUB_FAKE, value, salt)
+		n, _ := v.(int64)
+		return n, err
+	}
+	real := func(value interface{}) (float64, error) {
+		v, err := scrubValue(SCRUB_FAKE, value, salt)
+		f, _ := v.(float64)
+		return f, err
+	}
+	for name, fn := range map[string]interface{}{
+		"sqflite_scrub_text":    text,
+		"sqflite_scrub_integer": integer,
+		"sqflite_scrub_real":    real,
+	} {
+		if err = conn.RegisterFunc(name, fn, true); err != nil {
+			return nil, err
+		}
+	}
+	counts := make(map[interface{}]interface{})
+	for table, columns := range rules {
+		var sets []string
+		for col, rule := range columns {
+			sets = append(sets, quoteIdentifier(col)+" = "+scrubExpr(col, rule))
+		}
+		res, err := conn.Exec(fmt.Sprintf("UPDATE %s SET %s", quoteIdentifier(table), strings.Join(sets, ", ")), nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to scrub "+table)
+		}
+		counts[table], _ = res.RowsAffected()
+	}
+	if _, err = conn.Exec("VACUUM", nil); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// scrubExpr returns the SQL expression scrubbing col with rule
+func scrubExpr(col, rule string) string {
+	c := quoteIdentifier(col)
+	switch rule {
+	case SCRUB_NULL:
+		return "NULL"
+	case SCRUB_FAKE:
+		return fmt.Sprintf("CASE typeof(%[1]s) WHEN 'integer' THEN sqflite_scrub_integer(%[1]s) "+
+			"WHEN 'real' THEN sqflite_scrub_real(%[1]s) WHEN 'blob' THEN zeroblob(length(%[1]s)) "+
+			"WHEN 'text' THEN sqflite_scrub_text('fake', %[1]s) END", c)
+	}
+	return fmt.Sprintf("CASE WHEN %[1]s IS NULL THEN NULL ELSE sqflite_scrub_text(%[2]s, %[1]s) END", c, quoteString(rule))
+}
+
+// scrubValue applies a scrubbing rule to a single value. NULL stays NULL.
+func scrubValue(rule string, value interface{}, salt string) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	var raw []byte
+	switch v := value.(type) {
+	case []byte:
+		raw = v
+	case string:
+		raw = []byte(v)
+	default:
+		raw = []byte(fmt.Sprint(v))
+	}
+	sum := sha256.Sum256(append([]byte(salt), raw...))
+	tag := hex.EncodeToString(sum[:4])
+	n := binary.BigEndian.Uint64(sum[:8])
+
+	kind := ""
+	if strings.HasPrefix(rule, SCRUB_FAKE+":") {
+		rule, kind = SCRUB_FAKE, strings.TrimPrefix(rule, SCRUB_FAKE+":")
+	}
+	switch rule {
+	case SCRUB_NULL:
+		return nil, nil
+	case SCRUB_HASH:
+		return hex.EncodeToString(sum[:]), nil
+	case SCRUB_FAKE:
+	default:
+		return nil, errors.New("invalid scrub rule " + rule)
+	}
+	switch kind {
+	case "name":
+		return fakeFirstNames[n%uint64(len(fakeFirstNames))] + " " + fakeLastNames[(n/16)%uint64(len(fakeLastNames))], nil
+	case "email":
+		return "user-" + tag + "@example.com", nil
+	case "phone":
+		return "555-" + strconv.FormatUint(1000000+n%9000000, 10), nil
+	case "":
+	default:
+		return nil, errors.New("invalid fake kind " + kind)
+	}
+	// keep the storage class of the original value
+	switch v := value.(type) {
+	case int64:
+		return int64(n % 1000000), nil
+	case float64:
+		return float64(n%1000000) / 100, nil
+	case []byte:
+		return make([]byte, len(v)), nil
+	}
+	return "redacted-" + tag, nil
+}
+
+// getScrubRules decodes a map of table to {column: rule}
+func getScrubRules(arg interface{}) (map[string]map[string]string, error) {
+	rules := make(map[string]map[string]string)
+	if arg == nil {
+		return rules, nil
+	}
+	m, ok := arg.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("invalid anonymize rules")
+	}
+	for t, c := range m {
+		table, ok := t.(string)
+		cols, ok2 := c.(map[interface{}]interface{})
+		if !ok || !ok2 {
+			return nil, errors.New("invalid anonymize rules")
+		}
+		rules[table] = make(map[string]string)
+		for col, r := range cols {
+			name, ok := col.(string)
+			rule, ok2 := r.(string)
+			if !ok || !ok2 {
+				return nil, errors.New("invalid anonymize rule for " + table)
+			}
+			if _, err := scrubValue(rule, "", ""); err != nil {
+				return nil, err
+			}
+			rules[table][name] = rule
+		}
+	}
+	return rules, nil
+}
@@ -0,0 +1,62 @@
+package sqflite
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExportDatabaseOntoItself(t *testing.T) {
+	p := newTestPlugin(t)
+	id := openTestDatabase(t, p, "export.db", nil)
+	mustCall(t, p.handleExecute, sqlArgs(id, "CREATE TABLE t (email TEXT)"))
+	mustCall(t, p.handleInsert, sqlArgs(id, "INSERT INTO t VALUES ('a@b.c')"))
+	anonymize := map[interface{}]interface{}{"t": map[interface{}]interface{}{"email": SCRUB_HASH}}
+
+	dbPath := filepath.Join(p.getDatabasesPath(), "export.db")
+	for _, dest := range []string{"export.db", dbPath, dbPath + "-wal", dbPath + "-journal"} {
+		if _, err := p.handleExportDatabase(map[interface{}]interface{}{
+			PARAM_ID: id, PARAM_PATH: dest, PARAM_ANONYMIZE: anonymize,
+		}); err == nil {
+			t.Errorf("exported onto %s", dest)
+		}
+	}
+	if v := queryValue(t, p, id, "SELECT email FROM t"); v != "a@b.c" {
+		t.Errorf("database changed by a rejected export, email = %v", v)
+	}
+}
+
+func TestExportDatabaseSalt(t *testing.T) {
+	p := newTestPlugin(t)
+	id := openTestDatabase(t, p, "export.db", nil)
+	mustCall(t, p.handleExecute, sqlArgs(id, "CREATE TABLE t (email TEXT)"))
+	mustCall(t, p.handleInsert, sqlArgs(id, "INSERT INTO t VALUES ('a@b.c')"))
+
+	// a relative path is resolved against the databases folder
+	reply := mustCall(t, p.handleExportDatabase, map[interface{}]interface{}{
+		PARAM_ID:        id,
+		PARAM_PATH:      "exports/anonymized.db",
+		PARAM_ANONYMIZE: map[interface{}]interface{}{"t": map[interface{}]interface{}{"email": SCRUB_HASH}},
+	}).(map[interface{}]interface{})
+	dest := filepath.Join(p.getDatabasesPath(), "exports", "anonymized.db")
+	if _, err := os.Stat(dest); err != nil {
+		t.Fatalf("export not written in the databases folder: %v", err)
+	}
+	salt, _ := reply[PARAM_SALT].(string)
+	if salt == "" {
+		t.Fatal("no salt generated")
+	}
+
+	exportId := openTestDatabase(t, p, dest, nil)
+	unsalted := sha256.Sum256([]byte("a@b.c"))
+	salted := sha256.Sum256([]byte(salt + "a@b.c"))
+	switch queryValue(t, p, exportId, "SELECT email FROM t") {
+	case hex.EncodeToString(unsalted[:]):
+		t.Error("email hashed without salt")
+	case hex.EncodeToString(salted[:]):
+	default:
+		t.Error("email not hashed with the returned salt")
+	}
+}
@@ -0,0 +1,62 @@
+package sqflite
+
+import (
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// extensionsOf returns the extension libraries loaded on the connections of
+// the database opened on dbPath: the ones registered with
+// WithDatabaseExtensions, then the requested ones, once each. They must all
+// be allowed with WithExtensions.
+func (p *SqflitePlugin) extensionsOf(dbPath string, requested []string) ([]string, error) {
+	var libraries []string
+	for path, libs := range p.dbExtensions {
+		if p.resolvePath(path) == dbPath {
+			libraries = append(libraries, libs...)
+		}
+	}
+	libraries = append(libraries, requested...)
+	seen := make(map[string]bool)
+	var extensions []string
+	for _, lib := range libraries {
+		if !p.extensions[lib] {
+			return nil, errors.Errorf("extension %s is not allowed, see WithExtensions", lib)
+		}
+		if !seen[lib] {
+			seen[lib] = true
+			extensions = append(extensions, lib)
+		}
+	}
+	return extensions, nil
+}
+
+// getExtensions returns the list of library names of the extensions
+// argument of openDatabase
+func getExtensions(arg interface{}) ([]string, error) {
+	list, ok := arg.([]interface{})
+	if !ok {
+		return nil, errors.New("invalid extensions")
+	}
+	libraries := make([]string, 0, len(list))
+	for _, v := range list {
+		lib, ok := v.(string)
+		if !ok || lib == "" {
+			return nil, errors.Errorf("invalid extension %v", v)
+		}
+		libraries = append(libraries, lib)
+	}
+	return libraries, nil
+}
+
+// extensionDriver returns a copy of driver also loading extensions on the
+// connections it opens. The driver enables extension loading only while it
+// loads them, so the load_extension() SQL function stays disabled.
+func extensionDriver(driver *sqlite3.SQLiteDriver, extensions []string) *sqlite3.SQLiteDriver {
+	if len(extensions) == 0 {
+		return driver
+	}
+	d := *driver
+	d.Extensions = append(append([]string(nil), driver.Extensions...), extensions...)
+	return &d
+}
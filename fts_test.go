@@ -0,0 +1,100 @@
+package sqflite
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newFTSTestDatabase opens a database, skipping the test when FTS5 is not
+// built in
+func newFTSTestDatabase(t *testing.T) (*SqflitePlugin, int32) {
+	t.Helper()
+	p := newTestPlugin(t)
+	if !p.Capabilities().FTS5 {
+		t.Skip("fts5 is not built in, run with -tags sqlite_fts5")
+	}
+	return p, openTestDatabase(t, p, "fts.db", nil)
+}
+
+// firstColumn returns the first column of the rows of the query sqlStr
+func firstColumn(t *testing.T, p *SqflitePlugin, databaseId int32, sqlStr string, args ...interface{}) []interface{} {
+	t.Helper()
+	var values []interface{}
+	for _, row := range queryRows(t, p, databaseId, sqlStr, args...) {
+		values = append(values, row.([]interface{})[0])
+	}
+	return values
+}
+
+func TestFTS5Match(t *testing.T) {
+	p, id := newFTSTestDatabase(t)
+	mustCall(t, p.handleExecute, sqlArgs(id, "CREATE VIRTUAL TABLE doc USING fts5(title, body)"))
+	for _, doc := range [][2]string{
+		{"moon", "the moon orbits the earth, the moon is bright"},
+		{"sun", "the sun and the moon"},
+		{"mars", "a red planet"},
+	} {
+		mustCall(t, p.handleInsert, sqlArgs(id, "INSERT INTO doc (title, body) VALUES (?, ?)", doc[0], doc[1]))
+	}
+
+	// bm25 ranks the documents with more matches first, lower is better
+	titles := firstColumn(t, p, id, "SELECT title FROM doc WHERE doc MATCH ? ORDER BY bm25(doc)", "moon")
+	if want := []interface{}{"moon", "sun"}; !reflect.DeepEqual(titles, want) {
+		t.Errorf("titles = %v, want %v", titles, want)
+	}
+
+	snippet := queryValue(t, p, id, "SELECT snippet(doc, 1, '[', ']', '...', 4) FROM doc WHERE doc MATCH ?", "planet")
+	if s, _ := snippet.(string); !strings.Contains(s, "[planet]") {
+		t.Errorf("snippet = %v, want the match in brackets", snippet)
+	}
+
+	if v := queryValue(t, p, id, "SELECT count(*) FROM doc WHERE doc MATCH ?", "title:mars"); v != int64(1) {
+		t.Errorf("column filter count = %v, want 1", v)
+	}
+}
+
+func TestFTS5ExternalContent(t *testing.T) {
+	p, id := newFTSTestDatabase(t)
+	for _, sqlStr := range []string{
+		"CREATE TABLE note (id INTEGER PRIMARY KEY, body TEXT)",
+		"CREATE VIRTUAL TABLE note_fts USING fts5(body, content='note', content_rowid='id')",
+		`CREATE TRIGGER note_ai AFTER INSERT ON note BEGIN
+			INSERT INTO note_fts (rowid, body) VALUES (new.id, new.body);
+		END`,
+		`CREATE TRIGGER note_ad AFTER DELETE ON note BEGIN
+			INSERT INTO note_fts (note_fts, rowid, body) VALUES ('delete', old.id, old.body);
+		END`,
+		`CREATE TRIGGER note_au AFTER UPDATE ON note BEGIN
+			INSERT INTO note_fts (note_fts, rowid, body) VALUES ('delete', old.id, old.body);
+			INSERT INTO note_fts (rowid, body) VALUES (new.id, new.body);
+		END`,
+	} {
+		mustCall(t, p.handleExecute, sqlArgs(id, sqlStr))
+	}
+	match := func(term string) []interface{} {
+		return firstColumn(t, p, id, "SELECT note.id FROM note_fts JOIN note ON note.id = note_fts.rowid WHERE note_fts MATCH ? ORDER BY rank", term)
+	}
+
+	mustCall(t, p.handleInsert, sqlArgs(id, "INSERT INTO note (id, body) VALUES (1, 'full moon tonight')"))
+	mustCall(t, p.handleInsert, sqlArgs(id, "INSERT INTO note (id, body) VALUES (2, 'new moon')"))
+	if ids := match("moon"); len(ids) != 2 {
+		t.Errorf("moon matched %v, want 2 notes", ids)
+	}
+
+	mustCall(t, p.handleUpdate, sqlArgs(id, "UPDATE note SET body = 'half sun' WHERE id = 1"))
+	if ids, want := match("moon"), []interface{}{int64(2)}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("moon matched %v after update, want %v", ids, want)
+	}
+	if ids, want := match("sun"), []interface{}{int64(1)}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("sun matched %v after update, want %v", ids, want)
+	}
+
+	mustCall(t, p.handleUpdate, sqlArgs(id, "DELETE FROM note WHERE id = 2"))
+	if ids := match("moon"); len(ids) != 0 {
+		t.Errorf("moon matched %v after delete, want none", ids)
+	}
+
+	// the index agrees with its content table
+	mustCall(t, p.handleExecute, sqlArgs(id, "INSERT INTO note_fts (note_fts, rank) VALUES ('integrity-check', 1)"))
+}
@@ -0,0 +1,104 @@
+package sqflite
+
+import (
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// RegisterFunction registers the scalar SQL function name, implemented by fn,
+// on every connection opened afterwards, so SQL sent from Dart can call Go
+// code, e.g. a geo distance:
+//
+//	p.RegisterFunction("distance", func(lat1, lon1, lat2, lon2 float64) float64 { ... })
+//	SELECT * FROM place ORDER BY distance(lat, lon, ?, ?);
+//
+// The arguments of fn can be of any numeric type, bool, []byte, string or
+// interface{}, and variadic. fn returns a number, bool, []byte or string, and
+// optionally an error failing the statement. A function registered again replaces the
+// previous one, including the functions of the plugin.
+func (p *SqflitePlugin) RegisterFunction(name string, fn interface{}) error {
+	if name == "" || fn == nil {
+		return errors.New("invalid function")
+	}
+	if err := checkFunction(func(conn *sqlite3.SQLiteConn) error {
+		return conn.RegisterFunc(name, fn, false)
+	}); err != nil {
+		return errors.Wrap(err, "invalid function "+name)
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.functions[name] = fn
+	return nil
+}
+
+// RegisterAggregate registers the aggregate SQL function name on every
+// connection opened afterwards. newAggregate returns a new aggregation for
+// each group of rows, whose Step method is called with the arguments of each
+// row, and Done returns the result, e.g. a weighted average:
+//
+//	type weightedAvg struct{ sum, weights float64 }
+//
+//	func (a *weightedAvg) Step(value, weight float64) { a.sum += value * weight; a.weights += weight }
+//	func (a *weightedAvg) Done() float64              { return a.sum / a.weights }
+//
+//	p.RegisterAggregate("weighted_avg", func() *weightedAvg { return &weightedAvg{} })
+//
+// Step and Done follow the rules of the functions of RegisterFunction, Step
+// returning nothing or an error.
+func (p *SqflitePlugin) RegisterAggregate(name string, newAggregate interface{}) error {
+	if name == "" || newAggregate == nil {
+		return errors.New("invalid aggregate")
+	}
+	if err := checkFunction(func(conn *sqlite3.SQLiteConn) error {
+		return conn.RegisterAggregator(name, newAggregate, false)
+	}); err != nil {
+		return errors.Wrap(err, "invalid aggregate "+name)
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.aggregates[name] = newAggregate
+	return nil
+}
+
+// checkFunction runs register on an in-memory connection, to reject an
+// invalid function before it fails the connections of the databases. The
+// driver panics on some invalid signatures.
+func checkFunction(register func(conn *sqlite3.SQLiteConn) error) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = errors.Errorf("%v", v)
+		}
+	}()
+	conn, err := (&sqlite3.SQLiteDriver{}).Open(MEMORY_DATABASE_PATH)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	return register(conn.(*sqlite3.SQLiteConn))
+}
+
+// registerFunctions registers the functions registered with RegisterFunction
+// and RegisterAggregate on a new connection
+func (p *SqflitePlugin) registerFunctions(conn *sqlite3.SQLiteConn) error {
+	p.Lock()
+	functions := make(map[string]interface{}, len(p.functions))
+	for name, fn := range p.functions {
+		functions[name] = fn
+	}
+	aggregates := make(map[string]interface{}, len(p.aggregates))
+	for name, newAggregate := range p.aggregates {
+		aggregates[name] = newAggregate
+	}
+	p.Unlock()
+	for name, fn := range functions {
+		if err := conn.RegisterFunc(name, fn, false); err != nil {
+			return errors.Wrap(err, "failed to register function "+name)
+		}
+	}
+	for name, newAggregate := range aggregates {
+		if err := conn.RegisterAggregator(name, newAggregate, false); err != nil {
+			return errors.Wrap(err, "failed to register aggregate "+name)
+		}
+	}
+	return nil
+}
@@ -1,9 +1,10 @@
 module github.com/nealwon/go-flutter-plugin-sqlite
 
-go 1.12
+go 1.18
 
 require (
 	github.com/go-flutter-desktop/go-flutter v0.14.0
+	github.com/go-gl/glfw v0.0.0-20190217072633-93b30450e032
 	github.com/go-xorm/xorm v0.7.1
 	github.com/mattn/go-sqlite3 v1.9.0
 	github.com/mitchellh/go-homedir v1.1.0
@@ -0,0 +1,65 @@
+package sqflite
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// Hash SQL functions registered on every connection. Like the sha3() function
+// of the sqlite shell they return the digest as a BLOB, use hex() to get it as
+// text. Numbers are hashed as their text representation, NULL gives NULL.
+//
+//	sha1(value), sha256(value), blake3(value), hmac_sha256(key, value)
+func registerHashFuncs(conn *sqlite3.SQLiteConn) error {
+	for name, fn := range map[string]interface{}{
+		"sha1":        sha1Func,
+		"sha256":      sha256Func,
+		"blake3":      blake3Func,
+		"hmac_sha256": hmacSHA256Func,
+	} {
+		if err := conn.RegisterFunc(name, fn, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sha1Func(value interface{}) []byte {
+	b := columnBytes(value)
+	if b == nil {
+		return nil
+	}
+	sum := sha1.Sum(b)
+	return sum[:]
+}
+
+func sha256Func(value interface{}) []byte {
+	b := columnBytes(value)
+	if b == nil {
+		return nil
+	}
+	sum := sha256.Sum256(b)
+	return sum[:]
+}
+
+func blake3Func(value interface{}) []byte {
+	b := columnBytes(value)
+	if b == nil {
+		return nil
+	}
+	sum := blake3Sum(b)
+	return sum[:]
+}
+
+func hmacSHA256Func(key, value interface{}) []byte {
+	k, b := columnBytes(key), columnBytes(value)
+	if k == nil || b == nil {
+		return nil
+	}
+	mac := hmac.New(sha256.New, k)
+	mac.Write(b)
+	return mac.Sum(nil)
+}
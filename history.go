@@ -0,0 +1,306 @@
+package sqflite
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// suffix of the history table of a table
+const historySuffix = "_history"
+
+// columns added to the history tables, the other columns being copies of the
+// columns of the table. A row of history is a version of a row of the table,
+// valid from _valid_from until _valid_to (ms since epoch, NULL for the current
+// version).
+const (
+	historyIdColumn        = "_history_id"
+	historyOperationColumn = "_operation" // insert, update, delete or snapshot
+	historyFromColumn      = "_valid_from"
+	historyToColumn        = "_valid_to"
+	historyRowidColumn     = "_rowid" // key of the tables without primary key
+)
+
+// current time in ms since epoch, in SQL, following the clock of the test mode
+const nowMillisExpr = "now_ms()"
+
+// handleEnableHistory creates the history table of PARAM_TABLE, with triggers
+// recording every change made to the table, and snapshots the existing rows.
+// Calling it again after the table schema changed adds the new columns to the
+// history table and recreates the triggers. The triggers use the now_ms() SQL
+// function, so the table can only be written through connections having it
+// registered. Within the open transaction of the database, the changes are
+// committed or rolled back with it.
+func (p *SqflitePlugin) handleEnableHistory(arguments interface{}) (reply interface{}, err error) {
+	databaseId, db, err := p.getDatabase(arguments)
+	if err != nil {
+		return nil, err
+	}
+	table, _ := arguments.(map[interface{}]interface{})[PARAM_TABLE].(string)
+	if table == "" {
+		return nil, errors.New("table is not set")
+	}
+	ctx := p.ctx
+	exec, tx, err := p.beginExecutor(databaseId, db, arguments)
+	if err != nil {
+		return nil, err
+	}
+	if tx != nil {
+		defer tx.Rollback()
+	}
+	cols, err := tableColumns(ctx, exec, "main", table)
+	if err != nil {
+		return nil, err
+	}
+	pk, err := primaryKeyColumns(ctx, exec, table)
+	if err != nil {
+		return nil, err
+	}
+	history := table + historySuffix
+	historyCols, err := tableColumns(ctx, exec, "main", history)
+	created := err != nil
+	if created {
+		defs := []string{
+			historyIdColumn + " INTEGER PRIMARY KEY",
+			historyOperationColumn + " TEXT NOT NULL",
+			historyFromColumn + " INTEGER NOT NULL",
+			historyToColumn + " INTEGER",
+		}
+		if len(pk) == 0 {
+			defs = append(defs, historyRowidColumn+" INTEGER")
+		}
+		for _, c := range cols {
+			defs = append(defs, quoteIdentifier(c))
+		}
+		if _, err = exec.ExecContext(ctx, fmt.Sprintf("CREATE TABLE %s (%s)", quoteIdentifier(history), strings.Join(defs, ", "))); err != nil {
+			return nil, errors.Wrap(err, "failed to create history table")
+		}
+	} else {
+		for _, c := range cols {
+			if indexOf(historyCols, c) >= 0 {
+				continue
+			}
+			if _, err = exec.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", quoteIdentifier(history), quoteIdentifier(c))); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// key of the row of a version
+	keyCols := []string{historyRowidColumn}
+	keyValues := []string{"rowid"}
+	if len(pk) > 0 {
+		keyCols, keyValues = nil, nil
+		for _, c := range pk {
+			keyCols = append(keyCols, quoteIdentifier(c))
+			keyValues = append(keyValues, quoteIdentifier(c))
+		}
+	}
+	index := quoteIdentifier(history + "_key")
+	if _, err = exec.ExecContext(ctx, fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s, %s)",
+		index, quoteIdentifier(history), strings.Join(keyCols, ", "), historyToColumn)); err != nil {
+		return nil, err
+	}
+
+	insertCols := []string{historyOperationColumn, historyFromColumn, historyToColumn}
+	if len(pk) == 0 {
+		insertCols = append(insertCols, historyRowidColumn)
+	}
+	var values []string
+	for _, c := range cols {
+		insertCols = append(insertCols, quoteIdentifier(c))
+		values = append(values, quoteIdentifier(c))
+	}
+	version := func(operation, to, row string) string {
+		v := []string{quoteString(operation), nowMillisExpr, to}
+		if len(pk) == 0 {
+			v = append(v, row+"rowid")
+		}
+		for _, c := range values {
+			v = append(v, row+c)
+		}
+		return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s);", quoteIdentifier(history), strings.Join(insertCols, ", "), strings.Join(v, ", "))
+	}
+	var match []string
+	for i, c := range keyCols {
+		match = append(match, c+" IS OLD."+keyValues[i])
+	}
+	closeVersion := fmt.Sprintf("UPDATE %s SET %s = %s WHERE %s AND %s IS NULL;", quoteIdentifier(history),
+		historyToColumn, nowMillisExpr, strings.Join(match, " AND "), historyToColumn)
+	triggers := map[string]string{
+		"insert": fmt.Sprintf("AFTER INSERT ON %s BEGIN %s END", quoteIdentifier(table), version("insert", "NULL", "NEW.")),
+		"update": fmt.Sprintf("AFTER UPDATE ON %s BEGIN %s %s END", quoteIdentifier(table), closeVersion, version("update", "NULL", "NEW.")),
+		"delete": fmt.Sprintf("AFTER DELETE ON %s BEGIN %s %s END", quoteIdentifier(table), closeVersion, version("delete", nowMillisExpr, "OLD.")),
+	}
+	for event, body := range triggers {
+		name := quoteIdentifier(history + "_" + event)
+		if _, err = exec.ExecContext(ctx, "DROP TRIGGER IF EXISTS "+name); err != nil {
+			return nil, err
+		}
+		if _, err = exec.ExecContext(ctx, fmt.Sprintf("CREATE TRIGGER %s %s", name, body)); err != nil {
+			return nil, errors.Wrap(err, "failed to create history trigger")
+		}
+	}
+
+	var n int64
+	if created {
+		snapshot := []string{quoteString("snapshot"), nowMillisExpr, "NULL"}
+		if len(pk) == 0 {
+			snapshot = append(snapshot, "rowid")
+		}
+		snapshot = append(snapshot, values...)
+		res, err := exec.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s", quoteIdentifier(history),
+			strings.Join(insertCols, ", "), strings.Join(snapshot, ", "), quoteIdentifier(table)))
+		if err != nil {
+			return nil, err
+		}
+		n, _ = res.RowsAffected()
+	}
+	if tx != nil {
+		if err = tx.Commit(); err != nil {
+			return nil, err
+		}
+	}
+	return map[interface{}]interface{}{
+		PARAM_TABLE: history,
+		"rows":      n,
+	}, nil
+}
+
+// handleQueryAsOf returns the rows of PARAM_TABLE as they were at PARAM_TIME
+// (ms since epoch), read from its history table, in the format of a query.
+// PARAM_KEY ({column: value}, rowid for the tables without primary key)
+// selects a single row.
+func (p *SqflitePlugin) handleQueryAsOf(arguments interface{}) (reply interface{}, err error) {
+	databaseId, db, err := p.getDatabase(arguments)
+	if err != nil {
+		return nil, err
+	}
+	args := arguments.(map[interface{}]interface{})
+	table, _ := args[PARAM_TABLE].(string)
+	if table == "" {
+		return nil, errors.New("table is not set")
+	}
+	at, ok := args[PARAM_TIME]
+	if !ok {
+		return nil, errors.New("time is not set")
+	}
+	if _, ok = toFloat(at); !ok {
+		return nil, errors.Errorf("invalid time %v", at)
+	}
+	ctx := p.ctx
+	exec := p.executor(databaseId, db, arguments)
+	cols, where, whereArgs, err := historyQuery(ctx, exec, table, args[PARAM_KEY])
+	if err != nil {
+		return nil, err
+	}
+	where = append(where, historyOperationColumn+" <> 'delete'", historyFromColumn+" <= ?",
+		"("+historyToColumn+" IS NULL OR "+historyToColumn+" > ?)")
+	whereArgs = append(whereArgs, at, at)
+	var quoted []string
+	for _, c := range cols {
+		quoted = append(quoted, quoteIdentifier(c))
+	}
+	rows, err := queryRowMaps(ctx, exec, fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY %s", strings.Join(quoted, ", "),
+		quoteIdentifier(table+historySuffix), strings.Join(where, " AND "), historyIdColumn), cols, whereArgs...)
+	if err != nil {
+		return nil, err
+	}
+	return rowMapsReply(cols, rows), nil
+}
+
+// handleGetRowHistory returns every version of the row PARAM_KEY of
+// PARAM_TABLE, oldest first, with its operation and validity period, in the
+// format of a query.
+func (p *SqflitePlugin) handleGetRowHistory(arguments interface{}) (reply interface{}, err error) {
+	databaseId, db, err := p.getDatabase(arguments)
+	if err != nil {
+		return nil, err
+	}
+	args := arguments.(map[interface{}]interface{})
+	table, _ := args[PARAM_TABLE].(string)
+	if table == "" {
+		return nil, errors.New("table is not set")
+	}
+	if args[PARAM_KEY] == nil {
+		return nil, errors.New("key is not set")
+	}
+	ctx := p.ctx
+	exec := p.executor(databaseId, db, arguments)
+	cols, where, whereArgs, err := historyQuery(ctx, exec, table, args[PARAM_KEY])
+	if err != nil {
+		return nil, err
+	}
+	cols = append([]string{historyOperationColumn, historyFromColumn, historyToColumn}, cols...)
+	var quoted []string
+	for _, c := range cols {
+		quoted = append(quoted, quoteIdentifier(c))
+	}
+	rows, err := queryRowMaps(ctx, exec, fmt.Sprintf("SELECT %s FROM %s WHERE %s ORDER BY %s", strings.Join(quoted, ", "),
+		quoteIdentifier(table+historySuffix), strings.Join(where, " AND "), historyIdColumn), cols, whereArgs...)
+	if err != nil {
+		return nil, err
+	}
+	return rowMapsReply(cols, rows), nil
+}
+
+// historyQuery returns the columns of the table of a history table and the
+// conditions selecting the versions of the row key, if not nil
+func historyQuery(ctx context.Context, q queryer, table string, key interface{}) (cols, where []string, whereArgs []interface{}, err error) {
+	historyCols, err := tableColumns(ctx, q, "main", table+historySuffix)
+	if err != nil {
+		return nil, nil, nil, errors.New("history is not enabled on " + table)
+	}
+	for _, c := range historyCols {
+		switch c {
+		case historyIdColumn, historyOperationColumn, historyFromColumn, historyToColumn, historyRowidColumn:
+		default:
+			cols = append(cols, c)
+		}
+	}
+	if key == nil {
+		return cols, nil, nil, nil
+	}
+	keyMap, ok := key.(map[interface{}]interface{})
+	if !ok || len(keyMap) == 0 {
+		return nil, nil, nil, errors.New("key must be a map of column to value")
+	}
+	for k, v := range keyMap {
+		c, _ := k.(string)
+		if strings.EqualFold(c, "rowid") && indexOf(historyCols, historyRowidColumn) >= 0 {
+			c = historyRowidColumn
+		} else if indexOf(cols, c) < 0 {
+			return nil, nil, nil, errors.Errorf("no column %v in %s", k, table)
+		}
+		where = append(where, quoteIdentifier(c)+" IS ?")
+		whereArgs = append(whereArgs, v)
+	}
+	return cols, where, whereArgs, nil
+}
+
+// rowMapsReply converts rows read by queryRowMaps to the result format of a
+// query, {columns, rows}
+func rowMapsReply(cols []string, rows []map[string]interface{}) map[interface{}]interface{} {
+	icols := make([]interface{}, 0, len(cols))
+	for _, c := range cols {
+		icols = append(icols, c)
+	}
+	resultRows := make([]interface{}, 0, len(rows))
+	for _, row := range rows {
+		var resultRow []interface{}
+		for _, c := range cols {
+			if b, ok := row[c].([]byte); ok {
+				resultRow = append(resultRow, string(b))
+			} else {
+				resultRow = append(resultRow, row[c])
+			}
+		}
+		resultRows = append(resultRows, resultRow)
+	}
+	return map[interface{}]interface{}{
+		"columns": icols,
+		"rows":    resultRows,
+	}
+}
@@ -0,0 +1,51 @@
+package sqflite
+
+import (
+	"testing"
+)
+
+// TestHistoryWithReservedConn checks that the history methods run on the
+// connection reserved to an open transaction or cursor instead of waiting
+func TestHistoryWithReservedConn(t *testing.T) {
+	for _, kind := range reservedConnKinds {
+		t.Run(kind, func(t *testing.T) {
+			p := newTestPlugin(t)
+			id := openTestDatabase(t, p, "history.db", nil)
+			mustCall(t, p.handleExecute, sqlArgs(id, "CREATE TABLE t (id INTEGER PRIMARY KEY, x)"))
+			mustCall(t, p.handleInsert, sqlArgs(id, "INSERT INTO t VALUES (1, 'a')"))
+			history := map[interface{}]interface{}{PARAM_ID: id, PARAM_TABLE: "t"}
+
+			release := reserveTestConn(t, p, id, kind)
+			if err := callWithin(t, "enableHistory", func() error {
+				_, err := p.handleEnableHistory(history)
+				return err
+			}); err != nil {
+				t.Fatal(err)
+			}
+			asOf := map[interface{}]interface{}{PARAM_ID: id, PARAM_TABLE: "t", PARAM_TIME: int64(1) << 60}
+			if err := callWithin(t, "queryAsOf", func() error {
+				_, err := p.handleQueryAsOf(asOf)
+				return err
+			}); err != nil {
+				t.Fatal(err)
+			}
+			rowHistory := map[interface{}]interface{}{PARAM_ID: id, PARAM_TABLE: "t", PARAM_KEY: map[interface{}]interface{}{"id": int64(1)}}
+			if err := callWithin(t, "getRowHistory", func() error {
+				_, err := p.handleGetRowHistory(rowHistory)
+				return err
+			}); err != nil {
+				t.Fatal(err)
+			}
+			release()
+
+			// created with the transaction, rolled back with it
+			want := int64(1)
+			if kind == "transaction" {
+				want = 0
+			}
+			if v := queryValue(t, p, id, "SELECT count(*) FROM sqlite_master WHERE name = 't_history'"); v != want {
+				t.Errorf("history tables = %v, want %d", v, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package sqflite
+
+import sqlite3 "github.com/mattn/go-sqlite3"
+
+// connHooks collects the update, commit and rollback hooks of a connection
+// and registers them together, sqlite keeping a single hook of each kind
+type connHooks struct {
+	update   []func(op int, db string, table string, rowid int64)
+	commit   []func() int
+	rollback []func()
+}
+
+func (h *connHooks) onUpdate(f func(op int, db string, table string, rowid int64)) {
+	h.update = append(h.update, f)
+}
+
+// onCommit adds f to the commit hooks, the commit being turned into a
+// rollback when one of them returns non-zero
+func (h *connHooks) onCommit(f func() int) {
+	h.commit = append(h.commit, f)
+}
+
+func (h *connHooks) onRollback(f func()) {
+	h.rollback = append(h.rollback, f)
+}
+
+// register registers the hooks on conn
+func (h *connHooks) register(conn *sqlite3.SQLiteConn) {
+	if update := h.update; len(update) > 0 {
+		conn.RegisterUpdateHook(func(op int, db string, table string, rowid int64) {
+			for _, f := range update {
+				f(op, db, table, rowid)
+			}
+		})
+	}
+	if commit := h.commit; len(commit) > 0 {
+		conn.RegisterCommitHook(func() int {
+			rc := 0
+			for _, f := range commit {
+				if f() != 0 {
+					rc = 1
+				}
+			}
+			return rc
+		})
+	}
+	if rollback := h.rollback; len(rollback) > 0 {
+		conn.RegisterRollbackHook(func() {
+			for _, f := range rollback {
+				f()
+			}
+		})
+	}
+}
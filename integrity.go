@@ -0,0 +1,163 @@
+package sqflite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// CheckIntegrity runs PRAGMA integrity_check on db, or the faster
+// quick_check, which skips the check of the index contents, and returns the
+// problems found, none when the database is sound. A check failing on a
+// corrupt page reports its error as the problem.
+func CheckIntegrity(ctx context.Context, db *sql.DB, quick bool) ([]string, error) {
+	return checkIntegrity(ctx, db, quick)
+}
+
+// checkIntegrity is CheckIntegrity run on the pool or the reserved connection
+// of a database
+func checkIntegrity(ctx context.Context, q queryer, quick bool) ([]string, error) {
+	problems, err := integrityProblems(ctx, q, quick)
+	if sqliteErr, ok := err.(sqlite3.Error); ok && (sqliteErr.Code == sqlite3.ErrCorrupt || sqliteErr.Code == sqlite3.ErrNotADB) {
+		return append(problems, err.Error()), nil
+	}
+	return problems, err
+}
+
+// integrityProblems returns the problems reported by the integrity check
+func integrityProblems(ctx context.Context, q queryer, quick bool) ([]string, error) {
+	pragma := "PRAGMA integrity_check"
+	if quick {
+		pragma = "PRAGMA quick_check"
+	}
+	rows, err := q.QueryContext(ctx, pragma)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var problems []string
+	for rows.Next() {
+		var problem string
+		if err = rows.Scan(&problem); err != nil {
+			return problems, err
+		}
+		if problem != "ok" {
+			problems = append(problems, problem)
+		}
+	}
+	return problems, rows.Err()
+}
+
+// handleCheckIntegrity checks the integrity of the database PARAM_ID, quickly
+// when PARAM_QUICK is true, and returns {ok, errors}, errors listing the
+// problems found
+func (p *SqflitePlugin) handleCheckIntegrity(arguments interface{}) (reply interface{}, err error) {
+	databaseId, db, err := p.getDatabase(arguments)
+	if err != nil {
+		return nil, err
+	}
+	quick := false
+	if q, ok := arguments.(map[interface{}]interface{})[PARAM_QUICK]; ok && q != nil {
+		if quick, ok = q.(bool); !ok {
+			return nil, errors.New("invalid quick")
+		}
+	}
+	problems, err := checkIntegrity(p.ctx, p.executor(databaseId, db, arguments), quick)
+	if err != nil {
+		return nil, err
+	}
+	list := make([]interface{}, len(problems))
+	for i, problem := range problems {
+		list[i] = problem
+	}
+	return map[interface{}]interface{}{
+		"ok":     len(problems) == 0,
+		"errors": list,
+	}, nil
+}
+
+// CorruptionError is the error of a database whose integrity check failed
+// when opening it
+type CorruptionError struct {
+	Path     string
+	Problems []string
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("database %s is corrupt: %s", e.Path, strings.Join(e.Problems, "; "))
+}
+
+// RecoveryHandler is called with the problems found in the database at
+// dbPath, closed, when its integrity check fails on open. It may repair or
+// replace the file and return true for the plugin to open it again, or false
+// to fail the openDatabase call with ERROR_CORRUPT.
+type RecoveryHandler func(dbPath string, problems []string) (retry bool, err error)
+
+// openVerified opens dsn with options, reads its schema, and with check runs
+// its quick_check. A corrupt database is passed to the recovery handler, and
+// opened again once when the handler asks to.
+func (p *SqflitePlugin) openVerified(dsn, dbPath string, options engineOptions, check bool) (*sql.DB, error) {
+	for retried := false; ; retried = true {
+		engine := p.openEngine(dsn, options)
+		err := verifyDatabase(p.ctx, engine)
+		if check {
+			err = corruptionError(p.ctx, engine, dbPath, err)
+		}
+		if err == nil {
+			return engine, nil
+		}
+		engine.Close()
+		corrupt := corruptDatabase(err)
+		if corrupt == nil || retried || p.recoveryHandler == nil {
+			return nil, err
+		}
+		retry, herr := p.recoveryHandler(dbPath, corrupt.Problems)
+		if herr != nil {
+			return nil, &codedError{code: ERROR_CORRUPT, err: errors.Wrap(herr, "failed to recover "+dbPath)}
+		}
+		if !retry {
+			return nil, err
+		}
+	}
+}
+
+// corruptionError returns the ERROR_CORRUPT error of the database opened on
+// dbPath when its schema cannot be read, verifyErr, or its quick_check fails,
+// else verifyErr
+func corruptionError(ctx context.Context, db *sql.DB, dbPath string, verifyErr error) error {
+	var problems []string
+	if sqliteErr, ok := verifyErr.(sqlite3.Error); ok && sqliteErr.Code == sqlite3.ErrCorrupt {
+		problems = []string{verifyErr.Error()}
+	} else if verifyErr != nil {
+		return verifyErr
+	} else {
+		var err error
+		if problems, err = CheckIntegrity(ctx, db, true); err != nil {
+			return err
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return &codedError{code: ERROR_CORRUPT, err: &CorruptionError{Path: dbPath, Problems: problems}}
+}
+
+// corruptDatabase returns the first *CorruptionError of the causes of err, if
+// any
+func corruptDatabase(err error) *CorruptionError {
+	for err != nil {
+		if e, ok := err.(*CorruptionError); ok {
+			return e
+		}
+		cause, ok := err.(interface{ Cause() error })
+		if !ok {
+			break
+		}
+		err = cause.Cause()
+	}
+	return nil
+}
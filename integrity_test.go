@@ -0,0 +1,32 @@
+package sqflite
+
+import (
+	"testing"
+)
+
+// TestCheckIntegrityWithReservedConn checks that checkIntegrity runs on the
+// connection reserved to an open transaction or cursor instead of waiting
+func TestCheckIntegrityWithReservedConn(t *testing.T) {
+	for _, kind := range reservedConnKinds {
+		t.Run(kind, func(t *testing.T) {
+			p := newTestPlugin(t)
+			id := openTestDatabase(t, p, "integrity.db", nil)
+			mustCall(t, p.handleExecute, sqlArgs(id, "CREATE TABLE t (id INTEGER PRIMARY KEY, x)"))
+
+			release := reserveTestConn(t, p, id, kind)
+			defer release()
+			for _, quick := range []bool{false, true} {
+				var reply interface{}
+				if err := callWithin(t, "checkIntegrity", func() (err error) {
+					reply, err = p.handleCheckIntegrity(map[interface{}]interface{}{PARAM_ID: id, PARAM_QUICK: quick})
+					return err
+				}); err != nil {
+					t.Fatal(err)
+				}
+				if ok := reply.(map[interface{}]interface{})["ok"]; ok != true {
+					t.Errorf("quick=%v: ok = %v", quick, ok)
+				}
+			}
+		})
+	}
+}
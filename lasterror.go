@@ -0,0 +1,71 @@
+package sqflite
+
+import (
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// lastError is the last error returned by a call made on a database
+type lastError struct {
+	code         int32 // sqlite result code, 0 when not a sqlite error
+	extendedCode int32
+	message      string
+	sql          string
+	method       string
+	time         time.Time
+}
+
+// recordError wraps the handler of method to remember its errors as the last
+// error of the database it was called on
+func (p *SqflitePlugin) recordError(method string, handler func(arguments interface{}) (interface{}, error)) func(arguments interface{}) (interface{}, error) {
+	return func(arguments interface{}) (interface{}, error) {
+		reply, err := handler(arguments)
+		if err == nil {
+			return reply, nil
+		}
+		args, ok := arguments.(map[interface{}]interface{})
+		if !ok {
+			return reply, err
+		}
+		id, ok := args[PARAM_ID].(int32)
+		if !ok {
+			return reply, err
+		}
+		e := &lastError{message: err.Error(), method: method, time: time.Now()}
+		e.sql, _ = args[PARAM_SQL].(string)
+		if sqliteErr, ok := errors.Cause(err).(sqlite3.Error); ok {
+			e.code, e.extendedCode = int32(sqliteErr.Code), int32(sqliteErr.ExtendedCode)
+		}
+		p.Lock()
+		if _, open := p.databases[id]; open {
+			p.lastErrors[id] = e
+		}
+		p.Unlock()
+		return reply, err
+	}
+}
+
+// handleGetLastError returns the last error of the database PARAM_ID, or nil
+// if no call made on it failed
+func (p *SqflitePlugin) handleGetLastError(arguments interface{}) (reply interface{}, err error) {
+	databaseId, _, err := p.getDatabase(arguments)
+	if err != nil {
+		return nil, err
+	}
+	p.Lock()
+	e := p.lastErrors[databaseId]
+	p.Unlock()
+	if e == nil {
+		return nil, nil
+	}
+	return map[interface{}]interface{}{
+		PARAM_ERROR_CODE:    e.code,
+		"extendedCode":      e.extendedCode,
+		PARAM_ERROR_MESSAGE: e.message,
+		PARAM_SQL:           e.sql,
+		PARAM_METHOD:        e.method,
+		"time":              e.time.UnixNano() / int64(time.Millisecond),
+	}, nil
+}
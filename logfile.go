@@ -0,0 +1,128 @@
+package sqflite
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// FileLogger is a LevelLogger writing the logs of the plugin to a file, for
+// the users of a desktop app to attach to their reports. When the file grows
+// beyond maxSize it is rotated: renamed with a .1 suffix, the previous .1
+// renamed .2, up to the keep most recent files.
+type FileLogger struct {
+	sync.Mutex
+	path    string
+	maxSize int64
+	keep    int
+	file    *os.File
+	size    int64
+}
+
+// NewFileLogger opens the log file at path, appending to it, and rotates it
+// when it grows beyond maxSize bytes, keeping keep rotated files
+func NewFileLogger(path string, maxSize int64, keep int) (*FileLogger, error) {
+	if path == "" {
+		return nil, errors.New("log file path is not set")
+	}
+	if maxSize <= 0 {
+		return nil, errors.New("log file size must be positive")
+	}
+	if keep < 0 {
+		return nil, errors.New("rotated log files kept must be positive")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, errors.Wrap(err, "failed to create log folder")
+	}
+	l := &FileLogger{path: path, maxSize: maxSize, keep: keep}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *FileLogger) open() error {
+	file, err := os.OpenFile(l.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return errors.Wrap(err, "failed to open log file")
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	l.file, l.size = file, info.Size()
+	return nil
+}
+
+// rotate renames the log file and the rotated ones, then opens a new file
+func (l *FileLogger) rotate() error {
+	l.file.Close()
+	l.file = nil
+	os.Remove(fmt.Sprintf("%s.%d", l.path, l.keep))
+	for i := l.keep - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+	}
+	if l.keep > 0 {
+		os.Rename(l.path, l.path+".1")
+	} else {
+		os.Remove(l.path)
+	}
+	return l.open()
+}
+
+func (l *FileLogger) write(level, format string, v ...interface{}) {
+	line := time.Now().Format("2006/01/02 15:04:05.000") + " " + level + " " +
+		strings.TrimSuffix(fmt.Sprintf(format, v...), "\n") + "\n"
+	l.Lock()
+	defer l.Unlock()
+	if l.file == nil {
+		// closed, or failed to reopen after a rotation
+		return
+	}
+	if l.size > 0 && l.size+int64(len(line)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			fmt.Fprintf(os.Stderr, errorFormat, err)
+			return
+		}
+	}
+	n, _ := l.file.WriteString(line)
+	l.size += int64(n)
+}
+
+// Debugf writes a DEBUG line
+func (l *FileLogger) Debugf(format string, v ...interface{}) {
+	l.write("DEBUG", format, v...)
+}
+
+// Infof writes an INFO line
+func (l *FileLogger) Infof(format string, v ...interface{}) {
+	l.write("INFO", format, v...)
+}
+
+// Warnf writes a WARN line
+func (l *FileLogger) Warnf(format string, v ...interface{}) {
+	l.write("WARN", format, v...)
+}
+
+// Errorf writes an ERROR line
+func (l *FileLogger) Errorf(format string, v ...interface{}) {
+	l.write("ERROR", format, v...)
+}
+
+// Close closes the log file, the logs written after are dropped
+func (l *FileLogger) Close() error {
+	l.Lock()
+	defer l.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
@@ -0,0 +1,81 @@
+package sqflite
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+// Logger prints the logs of the plugin, a *log.Logger is one
+type Logger interface {
+	Printf(format string, v ...interface{})
+	Println(v ...interface{})
+}
+
+// LevelLogger receives the logs of the plugin by level. The debug logs, the
+// statements run and the steps of the plugin, are only sent in debug mode or
+// at the log level set by the Dart side, see LOG_LEVEL_SQL.
+type LevelLogger interface {
+	Debugf(format string, v ...interface{})
+	Infof(format string, v ...interface{})
+	Warnf(format string, v ...interface{})
+	Errorf(format string, v ...interface{})
+}
+
+// stdLogger prints to the standard logger of the log package
+type stdLogger struct{}
+
+func (stdLogger) Debugf(format string, v ...interface{}) {
+	log.Output(2, fmt.Sprintf(format, v...))
+}
+
+func (stdLogger) Infof(format string, v ...interface{}) {
+	log.Output(2, fmt.Sprintf(format, v...))
+}
+
+func (stdLogger) Warnf(format string, v ...interface{}) {
+	log.Output(2, fmt.Sprintf(errorFormat, fmt.Sprintf(format, v...)))
+}
+
+func (stdLogger) Errorf(format string, v ...interface{}) {
+	log.Output(2, fmt.Sprintf(errorFormat, fmt.Sprintf(format, v...)))
+}
+
+// printLogger prints the logs of all levels to a Logger, the warnings and
+// errors prefixed like those of the standard logger
+type printLogger struct {
+	Logger
+}
+
+func (l printLogger) Debugf(format string, v ...interface{}) {
+	l.Println(fmt.Sprintf(format, v...))
+}
+
+func (l printLogger) Infof(format string, v ...interface{}) {
+	l.Println(fmt.Sprintf(format, v...))
+}
+
+func (l printLogger) Warnf(format string, v ...interface{}) {
+	l.Printf(errorFormat, fmt.Sprintf(format, v...))
+}
+
+func (l printLogger) Errorf(format string, v ...interface{}) {
+	l.Printf(errorFormat, fmt.Sprintf(format, v...))
+}
+
+// verbose tells if the steps of the plugin are logged, in debug mode or at
+// LOG_LEVEL_VERBOSE
+func (p *SqflitePlugin) verbose() bool {
+	return p.debug || atomic.LoadInt32(&p.logLevel) >= LOG_LEVEL_VERBOSE
+}
+
+// logSQL logs a statement run on databaseId, in debug mode or when the
+// database was opened at LOG_LEVEL_SQL or above
+func (p *SqflitePlugin) logSQL(databaseId int32, sqlStr string, args []interface{}) {
+	p.Lock()
+	logLevel := p.logLevels[databaseId]
+	p.Unlock()
+	if p.debug || logLevel >= LOG_LEVEL_SQL {
+		p.logger.Debugf("sql=%s args=%v", sqlStr, args)
+	}
+}
@@ -0,0 +1,141 @@
+package sqflite
+
+/*
+typedef struct sqlite3 sqlite3;
+extern long long sqlite3_memory_used(void);
+extern long long sqlite3_memory_highwater(int resetFlag);
+extern int sqlite3_db_status(sqlite3 *db, int op, int *pCur, int *pHiwtr, int resetFlg);
+*/
+import "C"
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"unsafe"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// sqlite3_db_status() counters reported per connection
+var dbStatusCounters = []struct {
+	op   int
+	name string
+}{
+	{0, "lookasideUsed"},
+	{1, "cacheUsed"},
+	{2, "schemaUsed"},
+	{3, "stmtUsed"},
+}
+
+// SetHeapLimits sets the soft and hard heap limits of sqlite in bytes, shared
+// by all the databases of the process, zero meaning no limit. Above the soft
+// limit sqlite frees its caches, allocations above the hard limit fail. The
+// hard limit requires sqlite 3.31, and is rejected by older versions.
+func (p *SqflitePlugin) SetHeapLimits(soft, hard int64) error {
+	if soft < 0 || hard < 0 {
+		return errors.New("heap limits must be positive")
+	}
+	db, err := sql.Open("sqlite3", MEMORY_DATABASE_PATH)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	// unknown pragmas are ignored, a supported one returns the new limit
+	var limit int64
+	err = db.QueryRowContext(p.ctx, fmt.Sprintf("PRAGMA hard_heap_limit = %d", hard)).Scan(&limit)
+	if err == sql.ErrNoRows && hard > 0 {
+		return errors.New("hard_heap_limit is not supported by sqlite " + sqlite3Version())
+	} else if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	_, err = db.ExecContext(p.ctx, fmt.Sprintf("PRAGMA soft_heap_limit = %d", soft))
+	return err
+}
+
+// handleSetHeapLimits sets the heap limits of sqlite from PARAM_SOFT_HEAP_LIMIT
+// and PARAM_HARD_HEAP_LIMIT, see SetHeapLimits
+func (p *SqflitePlugin) handleSetHeapLimits(arguments interface{}) (reply interface{}, err error) {
+	args, ok := arguments.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("invalid arguments")
+	}
+	soft, _ := toFloat(args[PARAM_SOFT_HEAP_LIMIT])
+	hard, _ := toFloat(args[PARAM_HARD_HEAP_LIMIT])
+	return nil, p.SetHeapLimits(int64(soft), int64(hard))
+}
+
+// handleGetMemoryUsage reports the memory used by sqlite in the process, its
+// heap limits, and the memory used by the connection of the database
+// PARAM_ID, when given.
+func (p *SqflitePlugin) handleGetMemoryUsage(arguments interface{}) (reply interface{}, err error) {
+	usage := map[interface{}]interface{}{
+		"memoryUsed":      int64(C.sqlite3_memory_used()),
+		"memoryHighwater": int64(C.sqlite3_memory_highwater(0)),
+	}
+	args, _ := arguments.(map[interface{}]interface{})
+	if _, ok := args[PARAM_ID]; !ok {
+		return usage, nil
+	}
+	databaseId, db, err := p.getDatabase(arguments)
+	if err != nil {
+		return nil, err
+	}
+	// the counters are the ones of the connection of the calls, the reserved
+	// one during a transaction or cursor
+	q := p.executor(databaseId, db, arguments).(rowQueryer)
+	var soft int64
+	if err = q.QueryRowContext(p.ctx, "PRAGMA soft_heap_limit").Scan(&soft); err != nil {
+		return nil, err
+	}
+	usage["softHeapLimit"] = soft
+	var hard int64
+	if err = q.QueryRowContext(p.ctx, "PRAGMA hard_heap_limit").Scan(&hard); err == nil {
+		usage["hardHeapLimit"] = hard
+	} else if err != sql.ErrNoRows {
+		return nil, err
+	}
+	connection := make(map[interface{}]interface{})
+	for _, counter := range dbStatusCounters {
+		var value int64
+		if err = q.QueryRowContext(p.ctx, "SELECT db_status(?)", counter.op).Scan(&value); err != nil {
+			return nil, err
+		}
+		connection[counter.name] = value
+	}
+	usage["connection"] = connection
+	return usage, nil
+}
+
+// registerMemoryFuncs registers db_status(op), returning the current value of
+// the sqlite3_db_status() counter op of the connection
+func registerMemoryFuncs(conn *sqlite3.SQLiteConn) error {
+	return conn.RegisterFunc("db_status", func(op int) (int64, error) {
+		db := connHandle(conn)
+		if db == nil {
+			return 0, errors.New("connection is closed")
+		}
+		var cur, highwater C.int
+		if rc := C.sqlite3_db_status(db, C.int(op), &cur, &highwater, 0); rc != 0 {
+			return 0, errors.Errorf("db_status(%d) failed with code %d", op, int(rc))
+		}
+		return int64(cur), nil
+	}, false)
+}
+
+// connHandle returns the sqlite3 handle of a connection. The driver does not
+// expose it, it is read from its db field.
+func connHandle(conn *sqlite3.SQLiteConn) *C.sqlite3 {
+	v := reflect.ValueOf(conn).Elem().FieldByName("db")
+	if !v.IsValid() || v.Kind() != reflect.Ptr {
+		return nil
+	}
+	return *(**C.sqlite3)(unsafe.Pointer(v.UnsafeAddr()))
+}
+
+// sqlite3Version returns the version of the linked sqlite library
+func sqlite3Version() string {
+	version, _, _ := sqlite3.Version()
+	return version
+}
@@ -0,0 +1,38 @@
+package sqflite
+
+import (
+	"testing"
+)
+
+// TestMemoryUsageWithReservedConn checks that getMemoryUsage reads the
+// counters of the connection reserved to an open transaction or cursor
+// instead of waiting for it
+func TestMemoryUsageWithReservedConn(t *testing.T) {
+	for _, kind := range reservedConnKinds {
+		t.Run(kind, func(t *testing.T) {
+			p := newTestPlugin(t)
+			id := openTestDatabase(t, p, "memory.db", nil)
+
+			release := reserveTestConn(t, p, id, kind)
+			defer release()
+			var reply interface{}
+			if err := callWithin(t, "getMemoryUsage", func() (err error) {
+				reply, err = p.handleGetMemoryUsage(map[interface{}]interface{}{PARAM_ID: id})
+				return err
+			}); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := reply.(map[interface{}]interface{})["connection"]; !ok {
+				t.Error("no connection counters")
+			}
+		})
+	}
+}
+
+func TestSetHeapLimitsAfterShutdown(t *testing.T) {
+	p := newTestPlugin(t)
+	p.Shutdown()
+	if err := p.SetHeapLimits(0, 0); err == nil {
+		t.Error("heap limits set after shutdown")
+	}
+}
@@ -0,0 +1,331 @@
+package sqflite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// merge conflict strategies
+	MERGE_SOURCE_WINS = "sourceWins"
+	MERGE_TARGET_WINS = "targetWins"
+	MERGE_NEWEST_WINS = "newestWins"
+	MERGE_CALLBACK    = "callback"
+
+	// alias of the source database while merging
+	mergeSchema = "merge_src"
+)
+
+// MergeConflict describes a row present in both databases with different
+// values. Target and Source map column names to values.
+type MergeConflict struct {
+	Table  string
+	Target map[string]interface{}
+	Source map[string]interface{}
+}
+
+// MergeResolver decides which values a conflicting row keeps when merging
+// with the "callback" strategy. Returning nil keeps the target row unchanged,
+// otherwise the values of every merged column must be returned.
+type MergeResolver func(conflict MergeConflict) (map[string]interface{}, error)
+
+// SetMergeResolver sets the resolver used by mergeDatabase calls made with the
+// "callback" strategy.
+func (p *SqflitePlugin) SetMergeResolver(resolver MergeResolver) {
+	p.Lock()
+	defer p.Unlock()
+	p.mergeResolver = resolver
+}
+
+type mergeTableReport struct {
+	inserted, updated, unchanged, kept int64
+}
+
+// handleMergeDatabase merges the rows of the database file at PARAM_PATH,
+// absolute or relative to the databases folder, into the open database. Rows
+// are matched on the key columns given per table in PARAM_TABLES, and
+// conflicts are resolved with PARAM_STRATEGY. It fails while a transaction or
+// cursor is open.
+func (p *SqflitePlugin) handleMergeDatabase(arguments interface{}) (reply interface{}, err error) {
+	databaseId, db, err := p.getDatabase(arguments)
+	if err != nil {
+		return nil, err
+	}
+	args := arguments.(map[interface{}]interface{})
+	srcPath, _ := args[PARAM_PATH].(string)
+	if srcPath == "" {
+		return nil, errors.New("merge source path is not set")
+	}
+	srcPath = p.resolvePath(srcPath)
+	tables, err := getTableColumns(args[PARAM_TABLES])
+	if err != nil {
+		return nil, err
+	}
+	strategy, _ := args[PARAM_STRATEGY].(string)
+	if strategy == "" {
+		strategy = MERGE_SOURCE_WINS
+	}
+	tsColumn, _ := args[PARAM_TIMESTAMP_COLUMN].(string)
+	p.Lock()
+	resolver := p.mergeResolver
+	p.Unlock()
+	switch strategy {
+	case MERGE_SOURCE_WINS, MERGE_TARGET_WINS:
+	case MERGE_NEWEST_WINS:
+		if tsColumn == "" {
+			return nil, errors.New("newestWins requires timestampColumn")
+		}
+	case MERGE_CALLBACK:
+		if resolver == nil {
+			return nil, errors.New("no merge resolver registered")
+		}
+	default:
+		return nil, errors.New("invalid merge strategy " + strategy)
+	}
+	resolve := func(c MergeConflict) (map[string]interface{}, error) {
+		switch strategy {
+		case MERGE_SOURCE_WINS:
+			return c.Source, nil
+		case MERGE_NEWEST_WINS:
+			if compareValues(c.Source[tsColumn], c.Target[tsColumn]) > 0 {
+				return c.Source, nil
+			}
+			return nil, nil
+		case MERGE_CALLBACK:
+			return resolver(c)
+		}
+		return nil, nil
+	}
+
+	ctx := p.ctx
+	// the source cannot be attached within a transaction
+	conn, err := p.exclusiveConn(databaseId, db, "merge a database")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if _, err = conn.ExecContext(ctx, "ATTACH DATABASE ? AS "+mergeSchema, srcPath); err != nil {
+		return nil, err
+	}
+	defer conn.ExecContext(ctx, "DETACH DATABASE "+mergeSchema)
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	report := make(map[interface{}]interface{})
+	for table, keys := range tables {
+		r, err := mergeTable(ctx, tx, table, keys, resolve)
+		if err != nil {
+			tx.Rollback()
+			return nil, errors.Wrap(err, "failed to merge "+table)
+		}
+		report[table] = map[interface{}]interface{}{
+			"inserted":  r.inserted,
+			"updated":   r.updated,
+			"unchanged": r.unchanged,
+			"kept":      r.kept,
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// mergeTable merges mergeSchema.table into main.table on the given key columns.
+// Only the columns present in both tables are merged.
+func mergeTable(ctx context.Context, tx *sql.Tx, table string, keys []string, resolve MergeResolver) (r mergeTableReport, err error) {
+	targetCols, err := tableColumns(ctx, tx, "main", table)
+	if err != nil {
+		return r, err
+	}
+	sourceCols, err := tableColumns(ctx, tx, mergeSchema, table)
+	if err != nil {
+		return r, err
+	}
+	var cols []string
+	for _, c := range sourceCols {
+		for _, t := range targetCols {
+			if c == t {
+				cols = append(cols, c)
+			}
+		}
+	}
+	for _, k := range keys {
+		if indexOf(cols, k) < 0 {
+			return r, errors.New("key column " + k + " missing")
+		}
+	}
+	var quoted, where []string
+	for _, c := range cols {
+		quoted = append(quoted, quoteIdentifier(c))
+	}
+	for _, k := range keys {
+		where = append(where, quoteIdentifier(k)+" IS ?")
+	}
+	colList := strings.Join(quoted, ", ")
+	sourceRows, err := queryRowMaps(ctx, tx, fmt.Sprintf("SELECT %s FROM %s.%s", colList, mergeSchema, quoteIdentifier(table)), cols)
+	if err != nil {
+		return r, err
+	}
+	selectTarget := fmt.Sprintf("SELECT %s FROM main.%s WHERE %s", colList, quoteIdentifier(table), strings.Join(where, " AND "))
+	insert := fmt.Sprintf("INSERT INTO main.%s (%s) VALUES (%s)", quoteIdentifier(table), colList, strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", "))
+	update := fmt.Sprintf("UPDATE main.%s SET %s = ? WHERE %s", quoteIdentifier(table), strings.Join(quoted, " = ?, "), strings.Join(where, " AND "))
+	for _, source := range sourceRows {
+		var keyValues []interface{}
+		for _, k := range keys {
+			keyValues = append(keyValues, source[k])
+		}
+		targets, err := queryRowMaps(ctx, tx, selectTarget, cols, keyValues...)
+		if err != nil {
+			return r, err
+		}
+		if len(targets) == 0 {
+			if _, err = tx.ExecContext(ctx, insert, rowValues(source, cols)...); err != nil {
+				return r, err
+			}
+			r.inserted++
+			continue
+		}
+		if reflect.DeepEqual(targets[0], source) {
+			r.unchanged++
+			continue
+		}
+		winner, err := resolve(MergeConflict{Table: table, Target: targets[0], Source: source})
+		if err != nil {
+			return r, err
+		}
+		if winner == nil {
+			r.kept++
+			continue
+		}
+		for _, c := range cols {
+			if _, ok := winner[c]; !ok {
+				return r, errors.New("merge resolver returned no value for column " + c)
+			}
+		}
+		if _, err = tx.ExecContext(ctx, update, append(rowValues(winner, cols), keyValues...)...); err != nil {
+			return r, err
+		}
+		r.updated++
+	}
+	return r, nil
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// tableColumns returns the column names of schema.table
+func tableColumns(ctx context.Context, q queryer, schema, table string) ([]string, error) {
+	rows, err := q.QueryContext(ctx, fmt.Sprintf("PRAGMA %s.table_info(%s)", schema, quoteIdentifier(table)))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var cols []string
+	for rows.Next() {
+		var cid, notNull, pk int64
+		var name, ctype string
+		var dflt interface{}
+		if err = rows.Scan(&cid, &name, &ctype, &notNull, &dflt, &pk); err != nil {
+			return nil, err
+		}
+		cols = append(cols, name)
+	}
+	if len(cols) == 0 {
+		return nil, errors.New("no such table: " + schema + "." + table)
+	}
+	return cols, rows.Err()
+}
+
+// queryRowMaps runs a query and returns its rows as column name to value maps
+func queryRowMaps(ctx context.Context, q queryer, query string, cols []string, args ...interface{}) ([]map[string]interface{}, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []map[string]interface{}
+	for rows.Next() {
+		values := make([]interface{}, len(cols))
+		dest := make([]interface{}, len(cols))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]interface{}, len(cols))
+		for i, c := range cols {
+			row[c] = values[i]
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+func rowValues(row map[string]interface{}, cols []string) []interface{} {
+	values := make([]interface{}, len(cols))
+	for i, c := range cols {
+		values[i] = row[c]
+	}
+	return values
+}
+
+// compareValues orders two sqlite values, NULL being the smallest
+func compareValues(a, b interface{}) int {
+	if a == nil || b == nil {
+		switch {
+		case a == b:
+			return 0
+		case a == nil:
+			return -1
+		}
+		return 1
+	}
+	if fa, ok := toFloat(a); ok {
+		if fb, ok := toFloat(b); ok {
+			switch {
+			case fa < fb:
+				return -1
+			case fa > fb:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func indexOf(list []string, s string) int {
+	for i, v := range list {
+		if v == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// quoteIdentifier quotes a table or column name for use in generated SQL
+func quoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
@@ -0,0 +1,75 @@
+package sqflite
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// newMergeTestDatabases opens target.db and creates source.db next to it,
+// both with a table t holding the row 1 with different values
+func newMergeTestDatabases(t *testing.T, p *SqflitePlugin) int32 {
+	t.Helper()
+	source := openTestDatabase(t, p, "source.db", nil)
+	mustCall(t, p.handleExecute, sqlArgs(source, "CREATE TABLE t (id INTEGER PRIMARY KEY, x, y)"))
+	mustCall(t, p.handleInsert, sqlArgs(source, "INSERT INTO t VALUES (1, 'source', 'source'), (2, 'new', 'new')"))
+	if _, err := p.handleCloseDatabase(map[interface{}]interface{}{PARAM_ID: source}); err != nil {
+		t.Fatal(err)
+	}
+	id := openTestDatabase(t, p, "target.db", nil)
+	mustCall(t, p.handleExecute, sqlArgs(id, "CREATE TABLE t (id INTEGER PRIMARY KEY, x, y)"))
+	mustCall(t, p.handleInsert, sqlArgs(id, "INSERT INTO t VALUES (1, 'target', 'target')"))
+	return id
+}
+
+func TestMergeDatabaseRelativePath(t *testing.T) {
+	p := newTestPlugin(t)
+	id := newMergeTestDatabases(t, p)
+	mustCall(t, p.handleMergeDatabase, map[interface{}]interface{}{
+		PARAM_ID:     id,
+		PARAM_PATH:   "source.db",
+		PARAM_TABLES: map[interface{}]interface{}{"t": "id"},
+	})
+	if v := queryValue(t, p, id, "SELECT group_concat(x) FROM (SELECT x FROM t ORDER BY id)"); v != "source,new" {
+		t.Errorf("merged x = %v, want source,new", v)
+	}
+}
+
+func TestMergeDatabaseIncompleteResolution(t *testing.T) {
+	p := newTestPlugin(t)
+	id := newMergeTestDatabases(t, p)
+	p.SetMergeResolver(func(c MergeConflict) (map[string]interface{}, error) {
+		return map[string]interface{}{"id": c.Source["id"], "x": c.Source["x"]}, nil
+	})
+	if _, err := p.handleMergeDatabase(map[interface{}]interface{}{
+		PARAM_ID:       id,
+		PARAM_PATH:     filepath.Join(p.getDatabasesPath(), "source.db"),
+		PARAM_TABLES:   map[interface{}]interface{}{"t": "id"},
+		PARAM_STRATEGY: MERGE_CALLBACK,
+	}); err == nil {
+		t.Error("merged a resolution without y")
+	}
+	if v := queryValue(t, p, id, "SELECT y FROM t WHERE id = 1"); v != "target" {
+		t.Errorf("y = %v after a failed merge, want target", v)
+	}
+}
+
+func TestMergeDatabaseWithReservedConn(t *testing.T) {
+	for _, kind := range reservedConnKinds {
+		t.Run(kind, func(t *testing.T) {
+			p := newTestPlugin(t)
+			id := newMergeTestDatabases(t, p)
+			release := reserveTestConn(t, p, id, kind)
+			defer release()
+			if err := callWithin(t, "mergeDatabase", func() error {
+				_, err := p.handleMergeDatabase(map[interface{}]interface{}{
+					PARAM_ID:     id,
+					PARAM_PATH:   "source.db",
+					PARAM_TABLES: map[interface{}]interface{}{"t": "id"},
+				})
+				return err
+			}); err == nil {
+				t.Error("merged while the connection is reserved")
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package sqflite
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/go-flutter-desktop/go-flutter/plugin"
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// methodChannel dispatches the method calls of the plugin channel like the
+// go-flutter MethodChannel, each call in its own goroutine, but replies to a
+// failed call with a single error envelope in the format of sqflite, whose
+// details also carry the Go stack trace and the call context when verbose
+// errors are enabled. In test mode the calls are run one at a time in their
+// order of arrival.
+type methodChannel struct {
+	sync.RWMutex
+	plugin   *SqflitePlugin
+	codec    plugin.StandardMethodCodec
+	name     string
+	handlers map[string]func(arguments interface{}) (reply interface{}, err error)
+	serial   chan func() // calls run in order in test mode
+}
+
+func newMethodChannel(messenger plugin.BinaryMessenger, name string, p *SqflitePlugin) *methodChannel {
+	c := &methodChannel{
+		plugin:   p,
+		name:     name,
+		handlers: make(map[string]func(arguments interface{}) (reply interface{}, err error)),
+		serial:   make(chan func(), 64),
+	}
+	go func() {
+		for call := range c.serial {
+			call()
+		}
+	}()
+	messenger.SetChannelHandler(name, c.handleMessage)
+	return c
+}
+
+// HandleFunc registers the handler of method
+func (c *methodChannel) HandleFunc(method string, f func(arguments interface{}) (reply interface{}, err error)) {
+	c.Lock()
+	defer c.Unlock()
+	c.handlers[method] = f
+}
+
+func (c *methodChannel) handleMessage(binaryMessage []byte, r plugin.ResponseSender) error {
+	call, err := decodeMethodCall(binaryMessage)
+	if err != nil {
+		c.plugin.logger.Errorf("failed to decode incoming message: %v", err)
+		data, _ := c.codec.EncodeErrorEnvelope(ERROR_BAD_PARAM, err.Error(), nil)
+		r.Send(data)
+		return nil
+	}
+	c.RLock()
+	handler, ok := c.handlers[call.Method]
+	c.RUnlock()
+	if !ok {
+		// not implemented
+		c.plugin.logger.Errorf("no handler for method %s", call.Method)
+		r.Send(nil)
+		return nil
+	}
+	if c.plugin.getTestSource() != nil {
+		c.serial <- func() { c.handleCall(handler, call, r) }
+		return nil
+	}
+	go c.handleCall(handler, call, r)
+	return nil
+}
+
+func (c *methodChannel) handleCall(handler func(arguments interface{}) (interface{}, error), call plugin.MethodCall, r plugin.ResponseSender) {
+	reply, err := invokeHandler(handler, call.Arguments)
+	var data []byte
+	if err == nil {
+		data, err = c.codec.EncodeSuccessEnvelope(reply)
+	}
+	if err != nil {
+		c.plugin.logger.Errorf("%s failed: %v", call.Method, err)
+		code, message, details := c.plugin.platformError(call, err)
+		data, err = c.codec.EncodeErrorEnvelope(code, message, details)
+		if err != nil {
+			c.plugin.logger.Errorf("failed to encode error of %s: %v", call.Method, err)
+		}
+	}
+	r.Send(data)
+}
+
+// codedError is an error sent to Dart with a dedicated error code
+type codedError struct {
+	code string
+	err  error
+}
+
+func (e *codedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *codedError) Cause() error {
+	return e.err
+}
+
+// errorCode returns the code of the first codedError of the causes of err,
+// ERROR_READ_ONLY for a write refused by a read-only database, or "error"
+func errorCode(err error) string {
+	for err != nil {
+		if e, ok := err.(*codedError); ok {
+			return e.code
+		}
+		if e, ok := err.(sqlite3.Error); ok && e.Code == sqlite3.ErrReadonly {
+			return ERROR_READ_ONLY
+		}
+		cause, ok := err.(interface{ Cause() error })
+		if !ok {
+			break
+		}
+		err = cause.Cause()
+	}
+	return "error"
+}
+
+// sqfliteError returns the code, message and data of the PlatformException
+// of an error, as sent by sqflite: the SQLITE_ERROR code and a message ending
+// with the extended sqlite result code, "(code 2067)", for sqlite errors, and
+// the failed sql and its arguments in data.
+func sqfliteError(err error, sqlStr string, args []interface{}) (code, message string, data map[interface{}]interface{}) {
+	code, message = errorCode(err), err.Error()
+	if sqliteErr, ok := errors.Cause(err).(sqlite3.Error); ok {
+		if code == "error" {
+			code = SQLITE_ERROR
+		}
+		message = fmt.Sprintf("%s (code %d)", message, int(sqliteErr.ExtendedCode))
+	}
+	if sqlStr != "" {
+		data = map[interface{}]interface{}{
+			PARAM_SQL:           sqlStr,
+			PARAM_SQL_ARGUMENTS: args,
+		}
+	}
+	return code, message, data
+}
+
+// platformError returns the code, message and details of the error envelope
+// of a failed call
+func (p *SqflitePlugin) platformError(call plugin.MethodCall, err error) (code, message string, details interface{}) {
+	args, _ := call.Arguments.(map[interface{}]interface{})
+	sqlStr, _ := args[PARAM_SQL].(string)
+	sqlArgs, _ := args[PARAM_SQL_ARGUMENTS].([]interface{})
+	scriptErr := scriptError(err)
+	if scriptErr != nil {
+		sqlStr = scriptErr.SQL
+	}
+	code, message, data := sqfliteError(err, sqlStr, sqlArgs)
+	if scriptErr != nil {
+		data["index"] = int64(scriptErr.Index)
+		data["line"] = int64(scriptErr.Line)
+	}
+	if corrupt := corruptDatabase(err); corrupt != nil {
+		problems := make([]interface{}, len(corrupt.Problems))
+		for i, problem := range corrupt.Problems {
+			problems[i] = problem
+		}
+		data = map[interface{}]interface{}{
+			PARAM_PATH: corrupt.Path,
+			"errors":   problems,
+		}
+	}
+	if verbose, ok := p.errorDetails(call, err).(map[interface{}]interface{}); ok {
+		if data == nil {
+			data = verbose
+		} else {
+			for k, v := range verbose {
+				data[k] = v
+			}
+		}
+	}
+	if data == nil {
+		return code, message, nil
+	}
+	return code, message, data
+}
+
+// scriptError returns the first *ScriptError of the causes of err, if any
+func scriptError(err error) *ScriptError {
+	for err != nil {
+		if e, ok := err.(*ScriptError); ok {
+			return e
+		}
+		cause, ok := err.(interface{ Cause() error })
+		if !ok {
+			break
+		}
+		err = cause.Cause()
+	}
+	return nil
+}
+
+// panicError is returned for a handler that panicked
+type panicError struct {
+	value interface{}
+	stack []byte
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.value)
+}
+
+// invokeHandler calls handler, turning a panic into an error
+func invokeHandler(handler func(arguments interface{}) (interface{}, error), arguments interface{}) (reply interface{}, err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = &panicError{value: v, stack: debug.Stack()}
+		}
+	}()
+	return handler(arguments)
+}
+
+// SetVerboseErrors attaches the Go stack trace and the context of the call to
+// the details of the errors sent to Dart. It is meant for debug builds only,
+// the details may contain the SQL and arguments of the failed calls. The Dart
+// side can only turn them on with WithVerboseErrors.
+func (p *SqflitePlugin) SetVerboseErrors(verbose bool) {
+	p.Lock()
+	defer p.Unlock()
+	p.verboseErrors = verbose
+}
+
+// errorDetails returns the details of the error of a call, nil unless verbose
+// errors are enabled
+func (p *SqflitePlugin) errorDetails(call plugin.MethodCall, err error) interface{} {
+	p.Lock()
+	verbose := p.verboseErrors
+	p.Unlock()
+	if !verbose {
+		return nil
+	}
+	details := map[interface{}]interface{}{
+		"errorType": fmt.Sprintf("%T", errors.Cause(err)),
+		"method":    call.Method,
+		"callArgs":  call.Arguments,
+		"goVersion": runtime.Version(),
+		"platform":  runtime.GOOS + "/" + runtime.GOARCH,
+		"time":      time.Now().UnixNano() / int64(time.Millisecond),
+	}
+	if e, ok := errors.Cause(err).(*panicError); ok {
+		details["stack"] = string(e.stack)
+	} else if _, ok := err.(interface{ StackTrace() errors.StackTrace }); ok {
+		// errors created or wrapped by this package carry their stack
+		details["stack"] = fmt.Sprintf("%+v", err)
+	}
+	return details
+}
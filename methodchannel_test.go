@@ -0,0 +1,33 @@
+package sqflite
+
+import (
+	"testing"
+
+	"github.com/go-flutter-desktop/go-flutter/plugin"
+	"github.com/pkg/errors"
+)
+
+func TestVerboseErrorsFromDart(t *testing.T) {
+	call := plugin.MethodCall{Method: METHOD_QUERY}
+	err := errors.New("failed")
+	debugMode := map[interface{}]interface{}{PARAM_VERBOSE_ERRORS: true}
+
+	p := newTestPlugin(t)
+	mustCall(t, p.handleDebugMode, debugMode)
+	if details := p.errorDetails(call, err); details != nil {
+		t.Errorf("verbose errors enabled by Dart without WithVerboseErrors: %v", details)
+	}
+
+	p = newTestPlugin(t, WithVerboseErrors(true))
+	if details := p.errorDetails(call, err); details == nil {
+		t.Error("verbose errors disabled with WithVerboseErrors")
+	}
+	mustCall(t, p.handleDebugMode, map[interface{}]interface{}{PARAM_VERBOSE_ERRORS: false})
+	if details := p.errorDetails(call, err); details != nil {
+		t.Error("verbose errors not disabled by Dart")
+	}
+	mustCall(t, p.handleDebugMode, debugMode)
+	if details := p.errorDetails(call, err); details == nil {
+		t.Error("verbose errors not enabled by Dart with WithVerboseErrors")
+	}
+}
@@ -0,0 +1,154 @@
+package sqflite
+
+import (
+	"database/sql"
+	"expvar"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Metrics are the counters and gauges of the plugin, for the host app to
+// export. The counters grow from the start of the plugin, the ops/sec of a
+// method being the rate of its calls.
+type Metrics struct {
+	Calls         map[string]int64 // method calls by method
+	Errors        map[string]int64 // failed method calls by method
+	SlowQueries   int64            // calls above the slow query threshold
+	OpenDatabases int
+	Connections   PoolMetrics // connections of the open databases and of their read pools
+}
+
+// PoolMetrics sums the usage of connection pools
+type PoolMetrics struct {
+	Open         int           // connections open
+	InUse        int           // connections running a statement or reserved
+	Idle         int           // connections open but unused
+	WaitCount    int64         // statements that waited for a connection
+	WaitDuration time.Duration // cumulative time waited for a connection
+}
+
+// callMetrics counts the method calls of the plugin
+type callMetrics struct {
+	sync.Mutex
+	calls       map[string]int64
+	errors      map[string]int64
+	slowQueries int64
+}
+
+func newCallMetrics() *callMetrics {
+	return &callMetrics{
+		calls:  make(map[string]int64),
+		errors: make(map[string]int64),
+	}
+}
+
+// countCall wraps the handler of method to count its calls and errors
+func (p *SqflitePlugin) countCall(method string, handler func(arguments interface{}) (interface{}, error)) func(arguments interface{}) (interface{}, error) {
+	return func(arguments interface{}) (interface{}, error) {
+		reply, err := handler(arguments)
+		p.metrics.Lock()
+		p.metrics.calls[method]++
+		if err != nil {
+			p.metrics.errors[method]++
+		}
+		p.metrics.Unlock()
+		return reply, err
+	}
+}
+
+// countSlowQuery counts a call reported as a slow query
+func (p *SqflitePlugin) countSlowQuery() {
+	p.metrics.Lock()
+	p.metrics.slowQueries++
+	p.metrics.Unlock()
+}
+
+// Metrics returns the current metrics of the plugin
+func (p *SqflitePlugin) Metrics() Metrics {
+	p.metrics.Lock()
+	m := Metrics{
+		Calls:       make(map[string]int64, len(p.metrics.calls)),
+		Errors:      make(map[string]int64, len(p.metrics.errors)),
+		SlowQueries: p.metrics.slowQueries,
+	}
+	for method, n := range p.metrics.calls {
+		m.Calls[method] = n
+	}
+	for method, n := range p.metrics.errors {
+		m.Errors[method] = n
+	}
+	p.metrics.Unlock()
+	p.Lock()
+	m.OpenDatabases = len(p.databases)
+	pools := make([]*sql.DB, 0, len(p.databases)+len(p.readPools))
+	for _, db := range p.databases {
+		pools = append(pools, db)
+	}
+	for _, pool := range p.readPools {
+		pools = append(pools, pool)
+	}
+	p.Unlock()
+	for _, pool := range pools {
+		stats := pool.Stats()
+		m.Connections.Open += stats.OpenConnections
+		m.Connections.InUse += stats.InUse
+		m.Connections.Idle += stats.Idle
+		m.Connections.WaitCount += stats.WaitCount
+		m.Connections.WaitDuration += stats.WaitDuration
+	}
+	return m
+}
+
+// PublishExpvar publishes the metrics as the expvar variable name, read on
+// each request of /debug/vars. Like expvar.Publish, it panics if the name is
+// already used.
+func (p *SqflitePlugin) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return p.Metrics()
+	}))
+}
+
+// WritePrometheus writes the metrics to w in the Prometheus text exposition
+// format, the metric names prefixed with sqflite_, for the host app to serve
+// on its /metrics endpoint
+func (p *SqflitePlugin) WritePrometheus(w io.Writer) error {
+	m := p.Metrics()
+	var err error
+	write := func(format string, args ...interface{}) {
+		if err == nil {
+			_, err = fmt.Fprintf(w, format, args...)
+		}
+	}
+	write("# HELP sqflite_calls_total Method calls handled by the plugin.\n# TYPE sqflite_calls_total counter\n")
+	for _, method := range sortedMethods(m.Calls) {
+		write("sqflite_calls_total{method=%q} %d\n", method, m.Calls[method])
+	}
+	write("# HELP sqflite_errors_total Method calls that failed.\n# TYPE sqflite_errors_total counter\n")
+	for _, method := range sortedMethods(m.Errors) {
+		write("sqflite_errors_total{method=%q} %d\n", method, m.Errors[method])
+	}
+	write("# HELP sqflite_slow_queries_total Calls above the slow query threshold.\n# TYPE sqflite_slow_queries_total counter\n")
+	write("sqflite_slow_queries_total %d\n", m.SlowQueries)
+	write("# HELP sqflite_open_databases Databases open.\n# TYPE sqflite_open_databases gauge\n")
+	write("sqflite_open_databases %d\n", m.OpenDatabases)
+	write("# HELP sqflite_connections Connections of the databases and of their read pools.\n# TYPE sqflite_connections gauge\n")
+	write("sqflite_connections{state=\"in_use\"} %d\n", m.Connections.InUse)
+	write("sqflite_connections{state=\"idle\"} %d\n", m.Connections.Idle)
+	write("# HELP sqflite_connection_waits_total Statements that waited for a connection.\n# TYPE sqflite_connection_waits_total counter\n")
+	write("sqflite_connection_waits_total %d\n", m.Connections.WaitCount)
+	write("# HELP sqflite_connection_wait_seconds_total Time waited for a connection.\n# TYPE sqflite_connection_wait_seconds_total counter\n")
+	write("sqflite_connection_wait_seconds_total %g\n", m.Connections.WaitDuration.Seconds())
+	return err
+}
+
+func sortedMethods(counts map[string]int64) []string {
+	methods := make([]string, 0, len(counts))
+	for method := range counts {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+	return methods
+}
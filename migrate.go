@@ -0,0 +1,34 @@
+package sqflite
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+// migrate applies the migrations registered with WithMigrations for the
+// database opened on dbPath, upgrading it to their latest version
+func (p *SqflitePlugin) migrate(db *sql.DB, dbPath string) error {
+	for path, set := range p.migrations {
+		if p.resolvePath(path) != dbPath {
+			continue
+		}
+		ctx := p.ctx
+		var version int
+		if err := db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+			return err
+		}
+		if version >= set.Latest() {
+			return nil
+		}
+		result, err := set.Migrate(ctx, db, set.Latest(), false)
+		if err != nil {
+			return errors.Wrap(err, "failed to migrate "+dbPath)
+		}
+		if p.verbose() {
+			p.logger.Infof("migrated %s from version %d to %d", dbPath, result.From, result.To)
+		}
+		return nil
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+// Package migrations applies ordered, versioned schema migrations to a sqlite
+// database, tracking the version of its schema in PRAGMA user_version.
+//
+// The host app registers the migrations of a database with the plugin option
+// sqflite.WithMigrations, which migrates it to the latest version when it is
+// opened, or runs them itself with Set.Migrate.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// Step changes the schema within the transaction of a migration
+type Step func(ctx context.Context, tx *sql.Tx) error
+
+// SQL returns a Step running statements, separated by semicolons
+func SQL(statements string) Step {
+	return func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, statements)
+		return err
+	}
+}
+
+// Migration upgrades the schema from the previous version to Version with
+// Up, and downgrades it back with Down. A migration without Down cannot be
+// reverted.
+type Migration struct {
+	Version int    // version of the schema after Up, from 1
+	Name    string // describes the migration in the errors
+	Up      Step
+	Down    Step
+}
+
+func (m Migration) String() string {
+	if m.Name == "" {
+		return fmt.Sprintf("migration %d", m.Version)
+	}
+	return fmt.Sprintf("migration %d (%s)", m.Version, m.Name)
+}
+
+// Set is the ordered set of the migrations of a database
+type Set struct {
+	migrations []Migration
+}
+
+// New returns the set of the given migrations, see Register
+func New(migrations ...Migration) (*Set, error) {
+	s := &Set{}
+	for _, m := range migrations {
+		if err := s.Register(m); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Register adds a migration to the set. Its version must be positive and not
+// already registered, and it must have an Up step.
+func (s *Set) Register(m Migration) error {
+	if m.Version < 1 {
+		return errors.Errorf("invalid version %d of %s", m.Version, m)
+	}
+	if m.Up == nil {
+		return errors.Errorf("%s has no Up step", m)
+	}
+	i := sort.Search(len(s.migrations), func(i int) bool { return s.migrations[i].Version >= m.Version })
+	if i < len(s.migrations) && s.migrations[i].Version == m.Version {
+		return errors.Errorf("%s is already registered", m)
+	}
+	s.migrations = append(s.migrations, Migration{})
+	copy(s.migrations[i+1:], s.migrations[i:])
+	s.migrations[i] = m
+	return nil
+}
+
+// Latest returns the highest version of the set, 0 when empty
+func (s *Set) Latest() int {
+	if len(s.migrations) == 0 {
+		return 0
+	}
+	return s.migrations[len(s.migrations)-1].Version
+}
+
+// Plan returns the migrations leading from the version from to the version
+// to: the Up steps of the versions above from up to to in ascending order, or
+// the Down steps of the versions above to up to from in descending order.
+func (s *Set) Plan(from, to int) (migrations []Migration, down bool, err error) {
+	if to < 0 || to > s.Latest() {
+		return nil, false, errors.Errorf("unknown target version %d, the latest is %d", to, s.Latest())
+	}
+	if to >= from {
+		for _, m := range s.migrations {
+			if m.Version > from && m.Version <= to {
+				migrations = append(migrations, m)
+			}
+		}
+		return migrations, false, nil
+	}
+	if from > s.Latest() {
+		return nil, true, errors.Errorf("unknown version %d, the latest is %d", from, s.Latest())
+	}
+	for i := len(s.migrations) - 1; i >= 0; i-- {
+		m := s.migrations[i]
+		if m.Version > to && m.Version <= from {
+			if m.Down == nil {
+				return nil, true, errors.Errorf("%s cannot be reverted, it has no Down step", m)
+			}
+			migrations = append(migrations, m)
+		}
+	}
+	return migrations, true, nil
+}
+
+// Result reports the migrations applied by Migrate
+type Result struct {
+	From    int   // version before the migration
+	To      int   // version after the migration, From for a dry run
+	Applied []int // versions whose Up or Down step ran, in order
+	Down    bool  // the Down steps ran
+	DryRun  bool
+}
+
+// Migrate migrates db from its PRAGMA user_version to the version to, in a
+// single transaction, and sets its user_version to to. A failed step rolls
+// all the migration back. With dryRun, the steps run and are rolled back,
+// checking that they succeed without changing the database.
+func (s *Set) Migrate(ctx context.Context, db *sql.DB, to int, dryRun bool) (*Result, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	result := &Result{DryRun: dryRun}
+	if err = tx.QueryRowContext(ctx, "PRAGMA user_version").Scan(&result.From); err != nil {
+		return nil, err
+	}
+	migrations, down, err := s.Plan(result.From, to)
+	if err != nil {
+		return nil, err
+	}
+	result.Down = down
+	for _, m := range migrations {
+		step := m.Up
+		if down {
+			step = m.Down
+		}
+		if err = step(ctx, tx); err != nil {
+			return nil, errors.Wrapf(err, "%s failed", m)
+		}
+		result.Applied = append(result.Applied, m.Version)
+	}
+	result.To = result.From
+	if dryRun || to == result.From {
+		return result, nil
+	}
+	if _, err = tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", to)); err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	result.To = to
+	return result, nil
+}
@@ -0,0 +1,112 @@
+package sqflite
+
+import (
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// policies applied when a single instance path is opened again with options
+// incompatible with the ones it was first opened with
+const (
+	OPEN_CONFLICT_ERROR       = "error"      // fail the second open
+	OPEN_CONFLICT_REUSE_FIRST = "reuseFirst" // keep the first options, with a warning
+	OPEN_CONFLICT_UPGRADE     = "upgrade"    // make the database writable if either open is
+)
+
+// accessMode is the read-only state of a database, shared with its connector
+// so that a reopened connection gets the current one. Read-only connections
+// are opened with readOnlyDSN, and refuse writes with PRAGMA query_only.
+type accessMode struct {
+	readOnly    int32
+	readOnlyDSN string // mode=ro URI of the database file, empty in memory
+}
+
+func newAccessMode(readOnly bool) *accessMode {
+	m := &accessMode{}
+	m.setReadOnly(readOnly)
+	return m
+}
+
+func (m *accessMode) isReadOnly() bool {
+	return atomic.LoadInt32(&m.readOnly) != 0
+}
+
+func (m *accessMode) setReadOnly(readOnly bool) {
+	var v int32
+	if readOnly {
+		v = 1
+	}
+	atomic.StoreInt32(&m.readOnly, v)
+}
+
+// SetOpenConflictPolicy sets the policy applied when a single instance path
+// is opened again with a different readOnly option, OPEN_CONFLICT_REUSE_FIRST
+// by default. The openConflict option of openDatabase overrides it.
+func (p *SqflitePlugin) SetOpenConflictPolicy(policy string) error {
+	if err := checkOpenConflictPolicy(policy); err != nil {
+		return err
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.openConflictPolicy = policy
+	return nil
+}
+
+// makeWritable reopens the read-only connection of databaseId writable,
+// losing its temp tables and session pragmas
+func (p *SqflitePlugin) makeWritable(databaseId int32, db *sql.DB, dbPath string, mode *accessMode) error {
+	p.Lock()
+	_, reserved := p.reservedConns[databaseId]
+	p.Unlock()
+	if reserved {
+		return errors.New("cannot make " + dbPath + " writable while a transaction or cursor is open")
+	}
+	mode.setReadOnly(false)
+	// close the idle read-only connection, the next call opens a writable one
+	db.SetMaxIdleConns(0)
+	db.SetMaxIdleConns(1)
+	return nil
+}
+
+func checkOpenConflictPolicy(policy string) error {
+	switch policy {
+	case OPEN_CONFLICT_ERROR, OPEN_CONFLICT_REUSE_FIRST, OPEN_CONFLICT_UPGRADE:
+		return nil
+	}
+	return errors.New("invalid openConflict " + policy)
+}
+
+// resolveOpenConflict applies policy, or the policy of the plugin if empty,
+// when the database databaseId open on dbPath is reused by an open asking for
+// readOnly
+func (p *SqflitePlugin) resolveOpenConflict(databaseId int32, dbPath string, readOnly bool, policy string) error {
+	p.Lock()
+	mode := p.accessModes[databaseId]
+	db := p.databases[databaseId]
+	if policy == "" {
+		policy = p.openConflictPolicy
+	}
+	p.Unlock()
+	if mode == nil || db == nil || mode.isReadOnly() == readOnly {
+		return nil
+	}
+	current := "writable"
+	if mode.isReadOnly() {
+		current = "read-only"
+	}
+	switch policy {
+	case OPEN_CONFLICT_ERROR:
+		return errors.Errorf("%s is already open %s", dbPath, current)
+	case OPEN_CONFLICT_UPGRADE:
+		if readOnly {
+			// already writable
+			return nil
+		}
+		return p.makeWritable(databaseId, db, dbPath, mode)
+	}
+	p.warn(WARNING_OPEN_CONFLICT, dbPath, fmt.Sprintf("%s is already open %s, the readOnly option of the new open is ignored", dbPath, current))
+	return nil
+}
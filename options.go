@@ -0,0 +1,226 @@
+package sqflite
+
+import (
+	"path/filepath"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"github.com/nealwon/go-flutter-plugin-sqlite/migrations"
+)
+
+// Option configures the plugin created by NewSqflitePlugin
+type Option func(p *SqflitePlugin)
+
+// WithAssetsPath sets the flutter assets folder the seed databases are read
+// from, by default the flutter_assets folder next to the executable
+func WithAssetsPath(dir string) Option {
+	return func(p *SqflitePlugin) {
+		p.assetsPath = dir
+	}
+}
+
+// WithAutoRepair repairs the corrupt databases found on open with
+// RepairDatabase, and opens the repaired file. What was salvaged is reported
+// as a WARNING_REPAIRED warning. It replaces the handler set with
+// WithRecoveryHandler.
+func WithAutoRepair() Option {
+	return func(p *SqflitePlugin) {
+		p.recoveryHandler = p.repairCorrupt
+	}
+}
+
+// WithBusyTimeout sets how long the statements wait for the database to be
+// unlocked by another connection or process before failing, 5s by default.
+// The busyTimeout option of openDatabase overrides it.
+func WithBusyTimeout(timeout time.Duration) Option {
+	return func(p *SqflitePlugin) {
+		p.busyTimeout = timeout
+	}
+}
+
+// WithBusyRetry runs the statements failing with "database is locked" again,
+// at most attempts times, after waiting backoff, then twice as long at each
+// retry. Only the statements run outside of a transaction, and the ones
+// beginning a transaction, are retried.
+func WithBusyRetry(attempts int, backoff time.Duration) Option {
+	return func(p *SqflitePlugin) {
+		p.busyRetry = busyRetry{attempts: attempts, backoff: backoff}
+	}
+}
+
+// WithDatabasesPath sets the databases folder, see SetDatabasesPath. A
+// relative dir is resolved against the working directory when the option is
+// applied, so that changing it later does not move the databases.
+func WithDatabasesPath(dir string) Option {
+	return func(p *SqflitePlugin) {
+		if abs, err := filepath.Abs(dir); err == nil {
+			dir = abs
+		}
+		p.databasesPath = dir
+	}
+}
+
+// WithDatabaseExtensions loads the extension libraries on each connection of
+// the database at dbPath, absolute or relative to the databases folder. They
+// must be allowed with WithExtensions.
+func WithDatabaseExtensions(dbPath string, libraries ...string) Option {
+	return func(p *SqflitePlugin) {
+		p.dbExtensions[dbPath] = append(p.dbExtensions[dbPath], libraries...)
+	}
+}
+
+// WithDebug enables the debug logs, as the debugMode method does
+func WithDebug(debug bool) Option {
+	return func(p *SqflitePlugin) {
+		p.debug = debug
+	}
+}
+
+// WithDriver opens the connections of the databases with driver, such as a
+// go-sqlite3 driver loading extensions or with a ConnectHook, run before the
+// plugin sets up the connection. The SQL functions, hooks and virtual tables
+// of the plugin are bound to go-sqlite3, other drivers are not supported.
+func WithDriver(driver *sqlite3.SQLiteDriver) Option {
+	return func(p *SqflitePlugin) {
+		p.driver = driver
+	}
+}
+
+// WithExtensions allows the databases to load the given sqlite extension
+// libraries, such as "/usr/lib/sqlite3/spellfix.so", with the extensions
+// option of openDatabase or WithDatabaseExtensions. A library is only loaded
+// when given exactly as allowed, no extension is loaded by default, and the
+// load_extension() SQL function stays disabled.
+func WithExtensions(libraries ...string) Option {
+	return func(p *SqflitePlugin) {
+		for _, lib := range libraries {
+			p.extensions[lib] = true
+		}
+	}
+}
+
+// WithIgnoreAlreadyExists makes the execute method succeed when it fails
+// because the table, index or other object it creates already exists, as the
+// earlier versions of the plugin did. By default the sqlite error is returned,
+// use CREATE ... IF NOT EXISTS instead.
+func WithIgnoreAlreadyExists(ignore bool) Option {
+	return func(p *SqflitePlugin) {
+		p.ignoreAlreadyExists = ignore
+	}
+}
+
+// WithIntegrityCheck runs PRAGMA quick_check on the databases when opening
+// them, failing with ERROR_CORRUPT, or calling the handler set with
+// WithRecoveryHandler, instead of returning a corrupt database. The
+// integrityCheck option of openDatabase overrides it.
+func WithIntegrityCheck(check bool) Option {
+	return func(p *SqflitePlugin) {
+		p.integrityCheck = check
+	}
+}
+
+// WithJournalMode sets the journal mode of the databases, such as "WAL" to
+// let the queries read while another connection writes. The journalMode
+// option of openDatabase overrides it. By default, as sqflite on Android,
+// the databases use a rollback journal, and keep the WAL mode set by the app.
+func WithJournalMode(mode string) Option {
+	return func(p *SqflitePlugin) {
+		p.journalMode = mode
+	}
+}
+
+// WithLogger sends the logs of the plugin to logger instead of the standard
+// logger, whose flags are then left unchanged
+func WithLogger(logger Logger) Option {
+	return func(p *SqflitePlugin) {
+		p.logger = printLogger{logger}
+	}
+}
+
+// WithLevelLogger sends the logs of the plugin to logger by level, instead of
+// the standard logger, whose flags are then left unchanged
+func WithLevelLogger(logger LevelLogger) Option {
+	return func(p *SqflitePlugin) {
+		p.logger = logger
+	}
+}
+
+// WithMigrations migrates the database at dbPath, absolute or relative to the
+// databases folder, to the latest version of set each time the plugin opens
+// it writable. A database whose user_version is above the latest version, as
+// written by a newer version of the app, is left unchanged.
+func WithMigrations(dbPath string, set *migrations.Set) Option {
+	return func(p *SqflitePlugin) {
+		p.migrations[dbPath] = set
+	}
+}
+
+// WithRecoveryHandler calls handler when the integrity check of a database
+// fails on open, see RecoveryHandler and WithIntegrityCheck
+func WithRecoveryHandler(handler RecoveryHandler) Option {
+	return func(p *SqflitePlugin) {
+		p.recoveryHandler = handler
+	}
+}
+
+// WithSeedDatabase copies the pre-populated database file source to dbPath,
+// absolute or relative to the databases folder, when the plugin opens it and
+// it does not exist yet. A relative source is a flutter asset, such as
+// "assets/prefilled.db".
+func WithSeedDatabase(dbPath, source string) Option {
+	return func(p *SqflitePlugin) {
+		p.seeds[dbPath] = &seed{source: source}
+	}
+}
+
+// WithEmbeddedDatabase writes the database file data to dbPath, absolute or
+// relative to the databases folder, when the plugin opens it and it does not
+// exist yet. data is typically embedded in the executable:
+//
+//	//go:embed mydata.db
+//	var mydata []byte
+func WithEmbeddedDatabase(dbPath string, data []byte) Option {
+	return func(p *SqflitePlugin) {
+		p.seeds[dbPath] = &seed{data: data}
+	}
+}
+
+// WithTransactionListener calls listener after each transaction of the
+// databases opened by the plugin commits or rolls back, such as to invalidate
+// a cache or trigger a sync. The calls are made in order from a single
+// goroutine, once the plugin is initialized.
+func WithTransactionListener(listener func(TransactionEvent)) Option {
+	return func(p *SqflitePlugin) {
+		p.transactionListener = listener
+	}
+}
+
+// WithStatementTracer calls tracer after each statement run for the Dart side,
+// by the insert, update, query and execute methods and the operations of the
+// batches, such as to feed a tracing system. The calls are made from the
+// goroutines of the method calls, tracer must return quickly.
+func WithStatementTracer(tracer func(StatementTrace)) Option {
+	return func(p *SqflitePlugin) {
+		p.statementTracer = tracer
+	}
+}
+
+// WithPragmas runs the given pragmas, such as "foreign_keys = ON", on each
+// connection opened by the plugin, in order
+func WithPragmas(pragmas ...string) Option {
+	return func(p *SqflitePlugin) {
+		p.pragmas = append(p.pragmas, pragmas...)
+	}
+}
+
+// WithVerboseErrors attaches the Go stack trace and the context of the call to
+// the details of the errors sent to Dart, see SetVerboseErrors, and lets the
+// Dart side turn them on and off with the verboseErrors argument of debugMode.
+// Without it that argument is ignored, the details being meant for debug
+// builds only.
+func WithVerboseErrors(verbose bool) Option {
+	return func(p *SqflitePlugin) {
+		p.verboseErrors = verbose
+		p.dartVerboseErrors = verbose
+	}
+}
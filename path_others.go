@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package sqflite
+
+// normalizePath returns dbPath, only Windows paths need to be rewritten
+func normalizePath(dbPath string) string {
+	return dbPath
+}
+
+// samePath tells if two normalized paths name the same file
+func samePath(a, b string) bool {
+	return a == b
+}
@@ -0,0 +1,39 @@
+//go:build windows
+// +build windows
+
+package sqflite
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// prefixes of the extended-length paths, which are not limited to MAX_PATH
+const (
+	extendedPathPrefix = `\\?\`
+	extendedUNCPrefix  = `\\?\UNC\`
+)
+
+// normalizePath returns the absolute extended-length form of dbPath, so that
+// databases deeper than MAX_PATH can be opened and the different spellings of
+// a path, UNC ones included, match: C:/db/a.db gives \\?\C:\db\a.db and
+// \\server\share\a.db gives \\?\UNC\server\share\a.db.
+func normalizePath(dbPath string) string {
+	if dbPath == MEMORY_DATABASE_PATH || strings.HasPrefix(dbPath, extendedPathPrefix) {
+		return dbPath
+	}
+	abs, err := filepath.Abs(dbPath)
+	if err != nil {
+		return dbPath
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return extendedUNCPrefix + abs[2:]
+	}
+	return extendedPathPrefix + abs
+}
+
+// samePath tells if two normalized paths name the same file, Windows paths
+// being case-insensitive
+func samePath(a, b string) bool {
+	return strings.EqualFold(a, b)
+}
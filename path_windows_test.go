@@ -0,0 +1,26 @@
+//go:build windows
+// +build windows
+
+package sqflite
+
+import "testing"
+
+func TestNormalizePath(t *testing.T) {
+	for _, test := range []struct {
+		path, want string
+	}{
+		{`C:/db/a.db`, `\\?\C:\db\a.db`},
+		{`C:\db\..\db\a.db`, `\\?\C:\db\a.db`},
+		{`\\server\share\a.db`, `\\?\UNC\server\share\a.db`},
+		{`\\?\C:\db\a.db`, `\\?\C:\db\a.db`},
+		{`\\?\UNC\server\share\a.db`, `\\?\UNC\server\share\a.db`},
+		{MEMORY_DATABASE_PATH, MEMORY_DATABASE_PATH},
+	} {
+		if got := normalizePath(test.path); got != test.want {
+			t.Errorf("normalizePath(%s) = %s, want %s", test.path, got, test.want)
+		}
+	}
+	if !samePath(`\\?\C:\DB\A.db`, `\\?\c:\db\a.db`) {
+		t.Error("paths differing in case are not the same")
+	}
+}
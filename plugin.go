@@ -3,6 +3,7 @@
 // Extra dependencies:
 //   github.com/go-flutter-desktop/go-flutter
 //   github.com/go-flutter-desktop/go-flutter/plugin
+//   github.com/go-gl/glfw/v3.2/glfw
 //	 github.com/mattn/go-sqlite3
 //   github.com/mitchellh/go-homedir
 //   github.com/pkg/errors
@@ -10,20 +11,24 @@
 package sqflite
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"os"
-	"path"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-flutter-desktop/go-flutter"
 	"github.com/go-flutter-desktop/go-flutter/plugin"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/go-gl/glfw/v3.2/glfw"
+	sqlite3 "github.com/mattn/go-sqlite3"
 	"github.com/mitchellh/go-homedir"
+	"github.com/nealwon/go-flutter-plugin-sqlite/migrations"
 	"github.com/pkg/errors"
 )
 
@@ -34,6 +39,7 @@ const errorFormat = "[SQFLITE] %v\n"
 const (
 	METHOD_GET_PLATFORM_VERSION = "getPlatformVersion"
 	METHOD_GET_DATABASES_PATH   = "getDatabasesPath"
+	METHOD_SET_DATABASES_PATH   = "setDatabasesPath"
 	METHOD_DEBUG_MODE           = "debugMode"
 	METHOD_OPTIONS              = "options"
 	METHOD_OPEN_DATABASE        = "openDatabase"
@@ -43,19 +49,147 @@ const (
 	METHOD_QUERY                = "query"
 	METHOD_UPDATE               = "update"
 	METHOD_BATCH                = "batch"
+	METHOD_GET_BACKUP_STATUS    = "getBackupStatus"
+	METHOD_RESTORE_TO_TIME      = "restoreToTime"
+	METHOD_MERGE_DATABASE       = "mergeDatabase"
+	METHOD_DIFF_DATABASES       = "diffDatabases"
+	METHOD_EXPORT_DATABASE      = "exportDatabase"
+	METHOD_WIPE_SUBJECT         = "wipeSubject"
+	METHOD_ADD_ROW_CHECKSUM     = "addRowChecksum"
+	METHOD_VERIFY_ROW_CHECKSUMS = "verifyRowChecksums"
+	METHOD_REMOVE_ROW_CHECKSUM  = "removeRowChecksum"
+	METHOD_GET_LAST_ERROR       = "getLastError"
+	METHOD_ENABLE_HISTORY       = "enableHistory"
+	METHOD_QUERY_AS_OF          = "queryAsOf"
+	METHOD_GET_ROW_HISTORY      = "getRowHistory"
+	METHOD_ENABLE_SOFT_DELETE   = "enableSoftDelete"
+	METHOD_SOFT_DELETE          = "softDelete"
+	METHOD_RESTORE_DELETED      = "restoreDeleted"
+	METHOD_PURGE_DELETED        = "purgeDeleted"
+	METHOD_ENABLE_AUDIT         = "enableAudit"
+	METHOD_QUERY_AUDIT_LOG      = "queryAuditLog"
+	METHOD_SNAPSHOT_SCHEMA      = "snapshotSchema"
+	METHOD_RESTORE_SCHEMA       = "restoreSchema"
+	METHOD_SET_HEAP_LIMITS      = "setHeapLimits"
+	METHOD_GET_MEMORY_USAGE     = "getMemoryUsage"
+	METHOD_QUERY_CURSOR_NEXT    = "queryCursorNext"
+	METHOD_GET_STMT_CACHE_STATS = "getStatementCacheStats"
+	METHOD_EXECUTE_SCRIPT       = "executeScript"
+	METHOD_WATCH_QUERY          = "watchQuery"
+	METHOD_UNWATCH_QUERY        = "unwatchQuery"
+	METHOD_GET_CAPABILITIES     = "getCapabilities"
+	METHOD_SYNC_DATABASE        = "syncDatabase"
+	METHOD_BACKUP_DATABASE      = "backupDatabase"
+	METHOD_VACUUM_INTO          = "vacuumInto"
+	METHOD_SCHEDULE_BACKUP      = "scheduleBackup"
+	METHOD_CANCEL_BACKUP        = "cancelBackup"
+	METHOD_IMPORT_DUMP          = "importDump"
+	METHOD_CHECK_INTEGRITY      = "checkIntegrity"
+	METHOD_REPAIR_DATABASE      = "repairDatabase"
+	METHOD_ATTACH_DATABASE      = "attachDatabase"
+	METHOD_DETACH_DATABASE      = "detachDatabase"
+	METHOD_CANCEL_QUERY         = "cancelQuery"
+	METHOD_RESET                = "reset"
+	METHOD_DEBUG                = "debug"
+	METHOD_GET_STATS            = "getStats"
 	PARAM_ID                    = "id"
 	PARAM_PATH                  = "path"
 	// when opening a database
 	PARAM_READ_ONLY       = "readOnly"       // boolean
 	PARAM_SINGLE_INSTANCE = "singleInstance" // boolean
+	PARAM_OPEN_CONFLICT   = "openConflict"   // policy when already open with another readOnly, see OPEN_CONFLICT_ERROR
 	// Result when opening a database
 	PARAM_RECOVERED         = "recovered"
 	PARAM_QUERY_AS_MAP_LIST = "queryAsMapList" // boolean
+	PARAM_QUERY_CACHE       = "queryCache"     // max number of cached query results
+	PARAM_READ_POOL_SIZE    = "readPoolSize"   // read-only connections running the queries, 0 by default
+	PARAM_BUSY_TIMEOUT      = "busyTimeout"    // ms waited for a locked database
+	PARAM_JOURNAL_MODE      = "journalMode"    // journal_mode pragma, "WAL" or a rollback journal mode
+	PARAM_AUTO_CHECKPOINT   = "autoCheckpoint" // WAL pages written before a checkpoint
+	PARAM_FOREIGN_KEYS      = "foreignKeys"    // boolean, enforce the foreign key constraints
+	PARAM_INTEGRITY_CHECK   = "integrityCheck" // boolean, quick_check the database before returning it
+	PARAM_PRAGMAS           = "pragmas"        // map of pragma name to value, set on each connection
+	PARAM_STATEMENT_CACHE   = "statementCache" // max number of cached prepared statements
+	PARAM_MAX_WRITE_RATE    = "maxWritesPerSecond"
+	PARAM_WRITE_BURST       = "writeBurst"  // writes allowed at once above the rate
+	PARAM_TEMP_STORE        = "tempStore"   // where temp tables are stored: "default", "file" or "memory"
+	PARAM_SHARED_CACHE      = "sharedCache" // boolean, share the page cache of the handles of a path
+	PARAM_PASSWORD          = "password"    // sqflite_sqlcipher key, only null or empty is supported
+	PARAM_SEED_ASSET        = "seedAsset"   // flutter asset copied to the path when missing
+	PARAM_EXTENSIONS        = "extensions"  // extension libraries loaded, allowed with WithExtensions
 
 	PARAM_SQL               = "sql"
 	PARAM_SQL_ARGUMENTS     = "arguments"
 	PARAM_NO_RESULT         = "noResult"
 	PARAM_CONTINUE_OR_ERROR = "continueOnError"
+	PARAM_TRANSACTION_ID    = "transactionId"  // id of the transaction of the call, see TRANSACTION_ID_FORCE
+	PARAM_IN_TRANSACTION    = "inTransaction"  // boolean, when beginning or ending a transaction
+	PARAM_CURSOR_PAGE_SIZE  = "cursorPageSize" // rows returned by a query opening a cursor
+	PARAM_CURSOR_ID         = "cursorId"
+	PARAM_CANCEL            = "cancel" // boolean, closes the cursor
+	PARAM_WATCH_ID          = "watchId"
+	PARAM_CANCEL_TOKEN      = "cancelToken" // string or int chosen by the caller, see cancelQuery
+
+	// in debug mode
+	PARAM_VERBOSE_ERRORS = "verboseErrors" // boolean, Go stack traces in error details
+	PARAM_TEST_MODE      = "testMode"      // false or {time, seed}, see TestMode
+
+	// in options
+	PARAM_LOG_LEVEL = "logLevel" // see LOG_LEVEL_NONE
+
+	// in debug
+	PARAM_CMD = "cmd" // see DEBUG_CMD_GET
+
+	// when merging databases
+	PARAM_TABLES           = "tables" // map of table to columns
+	PARAM_STRATEGY         = "strategy"
+	PARAM_TIMESTAMP_COLUMN = "timestampColumn"
+
+	// when diffing databases
+	PARAM_PATH_A       = "pathA"
+	PARAM_PATH_B       = "pathB"
+	PARAM_INCLUDE_KEYS = "includeKeys" // boolean
+
+	// when exporting a database
+	PARAM_ANONYMIZE = "anonymize" // map of table to {column: scrub rule}
+	PARAM_SALT      = "salt"
+
+	// when wiping the data of a subject
+	PARAM_SUBJECT = "subject"
+
+	// when adding or verifying row checksums
+	PARAM_TABLE  = "table"
+	PARAM_COLUMN = "column"
+
+	// when reading the history of a table
+	PARAM_TIME = "time" // ms since epoch
+	PARAM_KEY  = "key"  // map of key column to value
+
+	// when soft deleting rows
+	PARAM_WHERE = "where"
+
+	// when reading the audit trail
+	PARAM_LIMIT = "limit"
+
+	// when executing a script
+	PARAM_SCRIPT = "script" // statements separated by semicolons
+
+	// when attaching a database
+	PARAM_ALIAS  = "alias"  // schema name of the attached database
+	PARAM_ATOMIC = "atomic" // boolean, fail if the transactions cannot commit atomically across both
+
+	// when resetting after a hot restart
+	PARAM_KEEP_SINGLE_INSTANCE = "keepSingleInstance" // boolean, true by default
+
+	// when checking the integrity of a database
+	PARAM_QUICK = "quick" // boolean, quick_check instead of integrity_check
+
+	// when restoring a schema
+	PARAM_SNAPSHOT = "snapshot" // result of snapshotSchema
+
+	// when setting the heap limits, in bytes
+	PARAM_SOFT_HEAP_LIMIT = "softHeapLimit"
+	PARAM_HARD_HEAP_LIMIT = "hardHeapLimit"
 
 	// in batch
 	PARAM_OPERATIONS = "operations"
@@ -72,6 +206,10 @@ const (
 	ERROR_BAD_PARAM       = "bad_param"       // internal only
 	ERROR_OPEN_FAILED     = "open_failed"     // msg
 	ERROR_DATABASE_CLOSED = "database_closed" // msg
+	ERROR_INVALID_KEY     = "invalid_key"     // file is not a database, or encrypted with another key
+	ERROR_READ_ONLY       = "read_only"       // write on a database opened read-only
+	ERROR_CORRUPT         = "corrupt"         // integrity check failed when opening, problems in data
+	ERROR_CANCELLED       = "cancelled"       // query interrupted by cancelQuery
 
 	// memory database path
 	MEMORY_DATABASE_PATH = ":memory:"
@@ -83,26 +221,150 @@ type SqflitePlugin struct {
 	ApplicationName string
 
 	userConfigFolder string
+	databasesPath    string // databases folder, relative paths are resolved against it
 	codec            plugin.StandardMessageCodec
-	databases        map[int32]*sql.DB // store database handlers
-	databasePaths    map[int32]string  // store database file path
-	databaseId       int32             // store max database id
+	databases        map[int32]*sql.DB              // store database handlers
+	databasePaths    map[int32]string               // store database file path
+	databaseId       int32                          // store max database id
+	backups          map[string]*backupWorker       // scheduled backups by database path
+	syncs            map[string]*syncWorker         // scheduled syncs by database path
+	mergeResolver    MergeResolver                  // resolves merge conflicts with the callback strategy
+	columnKeys       *keyRing                       // keys of encrypt_col/decrypt_col
+	vtables          map[string]VirtualTableFactory // virtual table modules by name
+	functions        map[string]interface{}         // SQL functions registered by the app by name
+	aggregates       map[string]interface{}         // aggregate constructors registered by the app by name
+	csvDirs          []string                       // directories readable by the csv module
+	queryCaches      map[int32]*queryCache          // query result caches by database id
+	writeLimiters    map[int32]*writeLimiter        // write throttles by database id
+	lastErrors       map[int32]*lastError           // last error by database id
+	accessModes      map[int32]*accessMode          // read-only state by database id
+	transactions     map[int32]*transaction         // open transactions by database id
+	reservedConns    map[int32]*reservedConn        // connections reserved to transactions and cursors by database id
+	connWaiters      map[*connWaiter]bool           // exclusiveConn calls waiting for a connection
+	cursors          map[int32]*cursor              // open query cursors by cursor id
+	cancelables      map[string]*cancelable         // running queries by cancel token
+	readPools        map[int32]*sql.DB              // read-only connections by database id
+	readPoolOpeners  map[int32]func() *sql.DB       // open the read pools again by database id
+	connPragmas      map[int32]*connPragmas         // pragmas of the connections by database id
+	attachments      map[int32]*connAttachments     // databases attached to the connections by database id
+	singleInstances  map[int32]bool                 // databases opened with singleInstance by id
+	logLevels        map[int32]int32                // log level when opened by database id
+	stats            map[int32]*dbStats             // calls made on the databases by database id
+	sessionStates    map[int32]bool                 // databases whose connection has temp or attached state by id
+	stmtCaches       map[int32]*stmtCache           // prepared statement caches by database id
+	migrations       map[string]*migrations.Set     // migrations applied on open by database path
+	seeds            map[string]*seed               // content copied to the missing databases by path
+	extensions       map[string]bool                // extension libraries allowed by WithExtensions
+	dbExtensions     map[string][]string            // extension libraries loaded by database path
+	warnings         *eventChannel                  // non-fatal warnings sent to Dart
+	changes          *eventChannel                  // committed changes sent to Dart
+	changeQueue      *batchQueue                    // committed changes waiting to be sent
+	txEvents         *eventChannel                  // ends of the transactions sent to Dart
+	watches          map[int32]*queryWatch          // watched queries by watch id
+	watchEvents      *eventChannel                  // results of the watched queries sent to Dart
+	backupEvents     *eventChannel                  // scheduled backups sent to Dart
+	importEvents     *eventChannel                  // progress of the dump imports sent to Dart
+
+	openConflictPolicy string // applied when a path is reopened with another readOnly
+	lastTransactionId  int32  // id of the last transaction begun
+	lastCursorId       int32  // id of the last cursor opened
+	lastWatchId        int32  // id of the last query watched
+
+	slowQueryThreshold time.Duration   // calls reported as slow queries
+	walSizeThreshold   int64           // WAL size reported as too large
+	walWarned          map[string]bool // database paths with a too large WAL
+	metrics            *callMetrics    // calls counted for Metrics
+
+	driver  *sqlite3.SQLiteDriver // opens the connections of the databases
+	logger  LevelLogger           // prints the logs of the plugin
+	pragmas []string              // run on each new connection
+
+	busyTimeout time.Duration // default busy_timeout of the connections
+	busyRetry   busyRetry     // retries of the statements failing with SQLITE_BUSY
+	journalMode string        // default journal mode of the databases
+	assetsPath  string        // flutter assets folder, next to the executable if empty
+
+	transactionListener func(TransactionEvent) // called at the end of the transactions
+	recoveryHandler     RecoveryHandler        // called for the corrupt databases found on open
+	statementTracer     func(StatementTrace)   // called after each statement run for Dart
+
+	integrityCheck      bool // quick_check the databases when opening them
+	ignoreAlreadyExists bool // execute succeeds when creating an existing object, see WithIgnoreAlreadyExists
+	dartVerboseErrors   bool // verboseErrors of debugMode honored, see WithVerboseErrors
 
 	queryAsMapList bool
-	debug          bool // debug mode
+	debug          bool        // debug mode
+	logLevel       int32       // sqflite log level, see LOG_LEVEL_NONE, accessed atomically
+	verboseErrors  bool        // send Go stack traces with the errors
+	testSource     *testSource // clock and random source of the test mode
+
+	capabilities *Capabilities // probed on first use
+
+	ctx      context.Context    // context of the statements, cancelled by Shutdown
+	shutdown context.CancelFunc // cancels ctx
 }
 
-var _ flutter.Plugin = &SqflitePlugin{} // compile-time type check
+var _ flutter.PluginGLFW = &SqflitePlugin{} // compile-time type check
 
-// NewSqflitePlugin initialize the plugin
-func NewSqflitePlugin(vendor, appName string) *SqflitePlugin {
-	log.SetFlags(log.Lshortfile | log.LstdFlags)
-	return &SqflitePlugin{
+// NewSqflitePlugin initialize the plugin, configured by the given options
+func NewSqflitePlugin(vendor, appName string, options ...Option) *SqflitePlugin {
+	p := &SqflitePlugin{
 		VendorName:      vendor,
 		ApplicationName: appName,
 		databases:       make(map[int32]*sql.DB),
 		databasePaths:   make(map[int32]string),
+		backups:         make(map[string]*backupWorker),
+		syncs:           make(map[string]*syncWorker),
+		columnKeys:      newKeyRing(),
+		vtables:         make(map[string]VirtualTableFactory),
+		functions:       make(map[string]interface{}),
+		aggregates:      make(map[string]interface{}),
+		queryCaches:     make(map[int32]*queryCache),
+		writeLimiters:   make(map[int32]*writeLimiter),
+		lastErrors:      make(map[int32]*lastError),
+		accessModes:     make(map[int32]*accessMode),
+		transactions:    make(map[int32]*transaction),
+		reservedConns:   make(map[int32]*reservedConn),
+		connWaiters:     make(map[*connWaiter]bool),
+		cursors:         make(map[int32]*cursor),
+		cancelables:     make(map[string]*cancelable),
+		readPools:       make(map[int32]*sql.DB),
+		readPoolOpeners: make(map[int32]func() *sql.DB),
+		connPragmas:     make(map[int32]*connPragmas),
+		attachments:     make(map[int32]*connAttachments),
+		singleInstances: make(map[int32]bool),
+		logLevels:       make(map[int32]int32),
+		stats:           make(map[int32]*dbStats),
+		sessionStates:   make(map[int32]bool),
+		stmtCaches:      make(map[int32]*stmtCache),
+		migrations:      make(map[string]*migrations.Set),
+		watches:         make(map[int32]*queryWatch),
+		seeds:           make(map[string]*seed),
+		extensions:      make(map[string]bool),
+		dbExtensions:    make(map[string][]string),
+
+		openConflictPolicy: OPEN_CONFLICT_REUSE_FIRST,
+
+		slowQueryThreshold: defaultSlowQueryThreshold,
+		walSizeThreshold:   defaultWALSizeThreshold,
+		walWarned:          make(map[string]bool),
+		metrics:            newCallMetrics(),
+	}
+	p.ctx, p.shutdown = context.WithCancel(context.Background())
+	if vtableSupported {
+		p.vtables[csvModule] = p.openCSVTable
+	}
+	for _, option := range options {
+		option(p)
 	}
+	if p.driver == nil {
+		p.driver = &sqlite3.SQLiteDriver{}
+	}
+	if p.logger == nil {
+		log.SetFlags(log.Lshortfile | log.LstdFlags)
+		p.logger = stdLogger{}
+	}
+	return p
 }
 
 func (p *SqflitePlugin) InitPlugin(messenger plugin.BinaryMessenger) error {
@@ -135,47 +397,230 @@ func (p *SqflitePlugin) InitPlugin(messenger plugin.BinaryMessenger) error {
 		}
 	}
 	p.userConfigFolder = filepath.Join(p.userConfigFolder, p.VendorName, p.ApplicationName)
+	p.Lock()
+	if p.databasesPath == "" {
+		p.databasesPath = filepath.Join(p.userConfigFolder, "databases")
+	}
+	p.Unlock()
 
-	if p.debug {
-		log.Println("home dir=", p.userConfigFolder)
-	}
-
-	channel := plugin.NewMethodChannel(messenger, channelName, plugin.StandardMethodCodec{})
-	channel.HandleFunc(METHOD_INSERT, p.handleInsert)
-	channel.HandleFunc(METHOD_BATCH, p.handleBatch)
-	channel.HandleFunc(METHOD_DEBUG_MODE, p.handleDebugMode)
-	channel.HandleFunc(METHOD_OPTIONS, p.handleOptions)
-	channel.HandleFunc(METHOD_CLOSE_DATABASE, p.handleCloseDatabase)
-	channel.HandleFunc(METHOD_OPEN_DATABASE, p.handleOpenDatabase)
-	channel.HandleFunc(METHOD_EXECUTE, p.handleExecute)
-	channel.HandleFunc(METHOD_UPDATE, p.handleUpdate)
-	channel.HandleFunc(METHOD_QUERY, p.handleQuery)
-	channel.HandleFunc(METHOD_GET_PLATFORM_VERSION, p.handleGetPlatformVersion)
-	channel.HandleFunc(METHOD_GET_DATABASES_PATH, p.handleGetDatabasePath)
-	channel.HandleFunc("deleteDatabase", p.handleDeleteDatabase)
-	channel.HandleFunc("databaseExists", p.handleDatabaseExists)
+	if p.verbose() {
+		p.logger.Debugf("home dir=%s", p.userConfigFolder)
+		caps := p.Capabilities()
+		if !caps.FTS5 {
+			p.logger.Infof("fts5 is not built in, build with -tags sqlite_fts5")
+		}
+		if !caps.JSON1 {
+			p.logger.Infof("json1 is not built in, build with -tags sqlite_json")
+		}
+	}
+
+	channel := newMethodChannel(messenger, channelName, p)
+	p.Lock()
+	p.warnings = newEventChannel(messenger, warningChannelName, p.logger)
+	p.changes = newEventChannel(messenger, changeChannelName, p.logger)
+	p.watchEvents = newEventChannel(messenger, watchChannelName, p.logger)
+	p.txEvents = newEventChannel(messenger, transactionChannelName, p.logger)
+	p.backupEvents = newEventChannel(messenger, backupChannelName, p.logger)
+	p.importEvents = newEventChannel(messenger, importChannelName, p.logger)
+	p.changeQueue = newBatchQueue()
+	go p.dispatchChanges(p.changeQueue)
+	p.Unlock()
+	// keep the last error of each database for getLastError, report slow
+	// calls and other non-fatal issues as warnings, and count the calls for
+	// getStats and Metrics, and trace the statements
+	handle := func(method string, handler func(arguments interface{}) (reply interface{}, err error)) {
+		channel.HandleFunc(method, p.countCall(method, p.recordError(method, p.watchCall(method, p.recordStats(method, p.traceCall(method, handler))))))
+	}
+	handle(METHOD_INSERT, p.handleInsert)
+	handle(METHOD_BATCH, p.handleBatch)
+	handle(METHOD_DEBUG_MODE, p.handleDebugMode)
+	handle(METHOD_OPTIONS, p.handleOptions)
+	handle(METHOD_CLOSE_DATABASE, p.handleCloseDatabase)
+	handle(METHOD_OPEN_DATABASE, p.handleOpenDatabase)
+	handle(METHOD_EXECUTE, p.handleExecute)
+	handle(METHOD_UPDATE, p.handleUpdate)
+	handle(METHOD_QUERY, p.handleQuery)
+	handle(METHOD_GET_PLATFORM_VERSION, p.handleGetPlatformVersion)
+	handle(METHOD_GET_DATABASES_PATH, p.handleGetDatabasePath)
+	handle(METHOD_SET_DATABASES_PATH, p.handleSetDatabasesPath)
+	handle("deleteDatabase", p.handleDeleteDatabase)
+	handle("databaseExists", p.handleDatabaseExists)
+	handle(METHOD_GET_BACKUP_STATUS, p.handleGetBackupStatus)
+	handle(METHOD_RESTORE_TO_TIME, p.handleRestoreToTime)
+	handle(METHOD_MERGE_DATABASE, p.handleMergeDatabase)
+	handle(METHOD_DIFF_DATABASES, p.handleDiffDatabases)
+	handle(METHOD_EXPORT_DATABASE, p.handleExportDatabase)
+	handle(METHOD_WIPE_SUBJECT, p.handleWipeSubject)
+	handle(METHOD_ADD_ROW_CHECKSUM, p.handleAddRowChecksum)
+	handle(METHOD_VERIFY_ROW_CHECKSUMS, p.handleVerifyRowChecksums)
+	handle(METHOD_REMOVE_ROW_CHECKSUM, p.handleRemoveRowChecksum)
+	handle(METHOD_GET_LAST_ERROR, p.handleGetLastError)
+	handle(METHOD_ENABLE_HISTORY, p.handleEnableHistory)
+	handle(METHOD_QUERY_AS_OF, p.handleQueryAsOf)
+	handle(METHOD_GET_ROW_HISTORY, p.handleGetRowHistory)
+	handle(METHOD_ENABLE_SOFT_DELETE, p.handleEnableSoftDelete)
+	handle(METHOD_SOFT_DELETE, p.handleSoftDelete)
+	handle(METHOD_RESTORE_DELETED, p.handleRestoreDeleted)
+	handle(METHOD_PURGE_DELETED, p.handlePurgeDeleted)
+	handle(METHOD_ENABLE_AUDIT, p.handleEnableAudit)
+	handle(METHOD_QUERY_AUDIT_LOG, p.handleQueryAuditLog)
+	handle(METHOD_SNAPSHOT_SCHEMA, p.handleSnapshotSchema)
+	handle(METHOD_RESTORE_SCHEMA, p.handleRestoreSchema)
+	handle(METHOD_SET_HEAP_LIMITS, p.handleSetHeapLimits)
+	handle(METHOD_GET_MEMORY_USAGE, p.handleGetMemoryUsage)
+	handle(METHOD_QUERY_CURSOR_NEXT, p.handleQueryCursorNext)
+	handle(METHOD_GET_STMT_CACHE_STATS, p.handleGetStatementCacheStats)
+	handle(METHOD_EXECUTE_SCRIPT, p.handleExecuteScript)
+	handle(METHOD_WATCH_QUERY, p.handleWatchQuery)
+	handle(METHOD_UNWATCH_QUERY, p.handleUnwatchQuery)
+	handle(METHOD_GET_CAPABILITIES, p.handleGetCapabilities)
+	handle(METHOD_SYNC_DATABASE, p.handleSyncDatabase)
+	handle(METHOD_BACKUP_DATABASE, p.handleBackupDatabase)
+	handle(METHOD_VACUUM_INTO, p.handleVacuumInto)
+	handle(METHOD_SCHEDULE_BACKUP, p.handleScheduleBackup)
+	handle(METHOD_CANCEL_BACKUP, p.handleCancelBackup)
+	handle(METHOD_IMPORT_DUMP, p.handleImportDump)
+	handle(METHOD_CHECK_INTEGRITY, p.handleCheckIntegrity)
+	handle(METHOD_REPAIR_DATABASE, p.handleRepairDatabase)
+	handle(METHOD_ATTACH_DATABASE, p.handleAttachDatabase)
+	handle(METHOD_DETACH_DATABASE, p.handleDetachDatabase)
+	handle(METHOD_CANCEL_QUERY, p.handleCancelQuery)
+	handle(METHOD_RESET, p.handleReset)
+	handle(METHOD_DEBUG, p.handleDebug)
+	handle(METHOD_GET_STATS, p.handleGetStats)
 	return nil
 }
 
+// InitPluginGLFW shuts the plugin down when the window of the app closes
+func (p *SqflitePlugin) InitPluginGLFW(window *glfw.Window) error {
+	var previous glfw.CloseCallback
+	previous = window.SetCloseCallback(func(w *glfw.Window) {
+		if previous != nil {
+			previous(w)
+		}
+		// the previous callback may keep the window open
+		if w.ShouldClose() {
+			p.Shutdown()
+		}
+	})
+	return nil
+}
+
+// Shutdown cancels the statements running on the databases, which fail with
+// "context canceled", the calls waiting for a connection, and the retries of
+// the locked statements, and stops the scheduled backups and syncs and the
+// watched queries. It then closes all the databases, rolling back their open
+// transactions and finalizing their statements, the last connection to a
+// database checkpointing and removing its WAL file. The calls made after it
+// fail. It is called when the window of the app closes, so that no goroutine
+// stays blocked on a long query and no journal file is left behind.
+func (p *SqflitePlugin) Shutdown() {
+	p.shutdown()
+	p.Lock()
+	databases := make(map[int32]*sql.DB, len(p.databases))
+	for id, db := range p.databases {
+		databases[id] = db
+	}
+	p.Unlock()
+	for id, db := range databases {
+		if err := p.closeDatabase(id, db); err != nil {
+			p.logger.Errorf("failed to close database %d: %v", id, err)
+		}
+	}
+}
+
 func (p *SqflitePlugin) handleGetPlatformVersion(arguments interface{}) (reply interface{}, err error) {
 	version := fmt.Sprintf("%s %s", runtime.GOOS, runtime.GOARCH)
 	return version, nil
 }
 
+// handleGetDatabasePath returns the databases folder, created if missing
 func (p *SqflitePlugin) handleGetDatabasePath(arguments interface{}) (reply interface{}, err error) {
-	return p.userConfigFolder, nil
+	dir := p.getDatabasesPath()
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return dir, nil
+}
+
+// SetDatabasesPath changes the databases folder, by default the databases
+// subfolder of the user config directory, creating it if missing. The
+// relative paths of the databases opened after are resolved against it, the
+// open databases are not moved.
+func (p *SqflitePlugin) SetDatabasesPath(dir string) error {
+	if !filepath.IsAbs(dir) {
+		return errors.New("databases path " + dir + " is not absolute")
+	}
+	dir = filepath.Clean(dir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.Wrap(err, "cannot create databases path")
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return errors.New("databases path " + dir + " is not a directory")
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.databasesPath = dir
+	return nil
 }
 
-// Not implemented
+// handleSetDatabasesPath sets the databases folder to PARAM_PATH, see
+// SetDatabasesPath
+func (p *SqflitePlugin) handleSetDatabasesPath(arguments interface{}) (reply interface{}, err error) {
+	dir, err := pathArgument(arguments)
+	if err != nil {
+		return nil, err
+	}
+	return nil, p.SetDatabasesPath(dir)
+}
+
+func (p *SqflitePlugin) getDatabasesPath() string {
+	p.Lock()
+	defer p.Unlock()
+	return p.databasesPath
+}
+
+// resolvePath returns the normalized path of a database, a relative path
+// being resolved against the databases path like sqflite does on mobile
+func (p *SqflitePlugin) resolvePath(dbPath string) string {
+	if dbPath != MEMORY_DATABASE_PATH && !filepath.IsAbs(dbPath) {
+		dbPath = filepath.Join(p.getDatabasesPath(), dbPath)
+	}
+	return normalizePath(dbPath)
+}
+
+// pathArgument returns the path of the deleteDatabase, databaseExists and
+// setDatabasesPath calls, given as PARAM_PATH, or as the only argument by
+// older clients
+func pathArgument(arguments interface{}) (string, error) {
+	if args, ok := arguments.(map[interface{}]interface{}); ok {
+		arguments = args[PARAM_PATH]
+	}
+	dbPath, ok := arguments.(string)
+	if !ok || dbPath == "" {
+		return "", errors.New("invalid dbpath")
+	}
+	return dbPath, nil
+}
+
+// handleOptions sets the options of sqflite, PARAM_QUERY_AS_MAP_LIST and
+// PARAM_LOG_LEVEL, the log level of the databases opened next
 func (p *SqflitePlugin) handleOptions(arguments interface{}) (reply interface{}, err error) {
-	var args map[string]interface{}
+	var args map[interface{}]interface{}
 	var ok bool
-	if args, ok = arguments.(map[string]interface{}); !ok {
+	if args, ok = arguments.(map[interface{}]interface{}); !ok {
 		return nil, errors.New("invalid param for option call")
 	}
-	paramAsList, ok := args["PARAM_QUERY_AS_MAP_LIST"]
+	paramAsList, ok := args[PARAM_QUERY_AS_MAP_LIST]
 	if ok {
-		p.queryAsMapList = paramAsList.(bool)
+		p.queryAsMapList, _ = paramAsList.(bool)
+	}
+	if logLevel, ok := args[PARAM_LOG_LEVEL].(int32); ok {
+		atomic.StoreInt32(&p.logLevel, logLevel)
 	}
 	return nil, nil
 }
@@ -185,12 +630,42 @@ func (p *SqflitePlugin) handleCloseDatabase(arguments interface{}) (reply interf
 	if err != nil {
 		return nil, err
 	}
-	err = db.Close()
+	return nil, p.closeDatabase(databaseId, db)
+}
+
+// closeDatabase closes the cursors, watched queries, transaction, prepared
+// statements and connections of databaseId. The read pool is closed first, so
+// that the connection of the database is the last one of the process on its
+// file and removes its WAL file.
+func (p *SqflitePlugin) closeDatabase(databaseId int32, db *sql.DB) error {
+	p.closeCursors(databaseId)
+	p.unwatchDatabase(databaseId)
+	p.endTransaction(databaseId)
+	if cache := p.getStmtCache(databaseId); cache != nil {
+		cache.close()
+	}
+	if pool := p.getReadPool(databaseId); pool != nil {
+		pool.Close()
+	}
+	err := db.Close()
 	p.Lock()
 	defer p.Unlock()
+	delete(p.readPools, databaseId)
+	delete(p.connPragmas, databaseId)
+	delete(p.attachments, databaseId)
+	delete(p.singleInstances, databaseId)
+	delete(p.logLevels, databaseId)
+	delete(p.stats, databaseId)
+	delete(p.sessionStates, databaseId)
+	delete(p.readPoolOpeners, databaseId)
+	delete(p.stmtCaches, databaseId)
 	delete(p.databasePaths, databaseId)
 	delete(p.databases, databaseId)
-	return nil, err
+	delete(p.queryCaches, databaseId)
+	delete(p.writeLimiters, databaseId)
+	delete(p.lastErrors, databaseId)
+	delete(p.accessModes, databaseId)
+	return err
 }
 
 func (p *SqflitePlugin) handleOpenDatabase(arguments interface{}) (reply interface{}, err error) {
@@ -203,48 +678,215 @@ func (p *SqflitePlugin) handleOpenDatabase(arguments interface{}) (reply interfa
 	var dbpath string
 	var readOnly bool
 	var singleInstance bool
-	if dpath, ok := args[PARAM_PATH]; ok {
-		dbpath = dpath.(string)
+	var sharedCache bool
+	var openConflict string
+	var options engineOptions
+	var limiter *writeLimiter
+	var readPoolSize int
+	var statementCache int
+	if dpath, ok := args[PARAM_PATH]; ok && dpath != nil {
+		if dbpath, ok = dpath.(string); !ok {
+			return nil, errors.New("invalid dbpath")
+		}
+	}
+	if rdo, ok := args[PARAM_READ_ONLY]; ok && rdo != nil {
+		if readOnly, ok = rdo.(bool); !ok {
+			return nil, errors.New("invalid readOnly")
+		}
+	}
+	if si, ok := args[PARAM_SINGLE_INSTANCE]; ok && si != nil {
+		if singleInstance, ok = si.(bool); !ok {
+			return nil, errors.New("invalid singleInstance")
+		}
+		singleInstance = singleInstance && MEMORY_DATABASE_PATH != dbpath
 	}
-	if rdo, ok := args[PARAM_READ_ONLY]; ok {
-		readOnly = rdo.(bool)
+	if oc, ok := args[PARAM_OPEN_CONFLICT]; ok && oc != nil {
+		if openConflict, ok = oc.(string); !ok {
+			return nil, errors.New("invalid openConflict")
+		}
+		if err = checkOpenConflictPolicy(openConflict); err != nil {
+			return nil, err
+		}
+	}
+	if sc, ok := args[PARAM_SHARED_CACHE]; ok && sc != nil {
+		if sharedCache, ok = sc.(bool); !ok {
+			return nil, errors.New("invalid sharedCache")
+		}
+	}
+	// the driver is built without SQLCipher, opening a database given a
+	// password would silently leave it unencrypted
+	if password, _ := args[PARAM_PASSWORD].(string); password != "" {
+		return nil, &codedError{code: ERROR_OPEN_FAILED, err: errors.New("cannot open " + dbpath + " with a password, database encryption is not supported")}
 	}
-	if si, ok := args[PARAM_SINGLE_INSTANCE]; ok {
-		singleInstance = si.(bool) && MEMORY_DATABASE_PATH != dbpath
+	if n, ok := args[PARAM_QUERY_CACHE].(int32); ok && n > 0 {
+		options.cache = newQueryCache(int(n))
+	}
+	if ts, ok := args[PARAM_TEMP_STORE].(string); ok {
+		switch ts {
+		case "default", "file", "memory":
+			options.tempStore = ts
+		default:
+			return nil, errors.New("invalid tempStore " + ts)
+		}
+	}
+	if rate, ok := toFloat(args[PARAM_MAX_WRITE_RATE]); ok && rate > 0 {
+		burst, _ := args[PARAM_WRITE_BURST].(int32)
+		limiter = newWriteLimiter(rate, int(burst))
+	}
+	options.busyTimeout = p.busyTimeout
+	options.journalMode = p.journalMode
+	if jm, ok := args[PARAM_JOURNAL_MODE].(string); ok {
+		options.journalMode = jm
+	}
+	switch options.journalMode = strings.ToUpper(options.journalMode); options.journalMode {
+	case "", "DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF":
+	default:
+		return nil, errors.New("invalid journalMode " + options.journalMode)
+	}
+	options.pragmas = newConnPragmas()
+	options.attachments = newConnAttachments()
+	if fk, ok := args[PARAM_FOREIGN_KEYS].(bool); ok && fk {
+		options.pragmas.set("foreign_keys", "ON")
+	}
+	if pragmas, ok := args[PARAM_PRAGMAS]; ok && pragmas != nil {
+		if err = options.pragmas.setAll(pragmas); err != nil {
+			return nil, err
+		}
+	}
+	if n, ok := args[PARAM_AUTO_CHECKPOINT].(int32); ok && n > 0 {
+		options.checkpoint = n
+	}
+	if ms, ok := toFloat(args[PARAM_BUSY_TIMEOUT]); ok && ms > 0 {
+		options.busyTimeout = time.Duration(ms) * time.Millisecond
+	}
+	if n, ok := args[PARAM_STATEMENT_CACHE].(int32); ok && n > 0 {
+		statementCache = int(n)
+	}
+	seedAsset, _ := args[PARAM_SEED_ASSET].(string)
+	integrityCheck := p.integrityCheck
+	if ic, ok := args[PARAM_INTEGRITY_CHECK]; ok && ic != nil {
+		if integrityCheck, ok = ic.(bool); !ok {
+			return nil, errors.New("invalid integrityCheck")
+		}
+	}
+	var extensions []string
+	if ext, ok := args[PARAM_EXTENSIONS]; ok && ext != nil {
+		if extensions, err = getExtensions(ext); err != nil {
+			return nil, err
+		}
+	}
+	if n, ok := args[PARAM_READ_POOL_SIZE].(int32); ok && n > 0 {
+		if n > maxReadPoolSize {
+			return nil, errors.Errorf("invalid readPoolSize %d, at most %d", n, maxReadPoolSize)
+		}
+		readPoolSize = int(n)
 	}
 	if dbpath == "" {
-		log.Printf(errorFormat, "invalid dbpath")
+		p.logger.Errorf("invalid dbpath")
 		return nil, errors.New("invalid dbpath")
 	}
-	log.Println("dbpath=", dbpath)
-	if readOnly {
-		log.Printf(errorFormat, "readonly not supported")
+	dbpath = p.resolvePath(dbpath)
+	if p.verbose() {
+		p.logger.Debugf("dbpath=%s", dbpath)
 	}
 	if MEMORY_DATABASE_PATH != dbpath {
-		err = os.MkdirAll(path.Dir(dbpath), 0755)
+		err = os.MkdirAll(filepath.Dir(dbpath), 0755)
 		if err != nil {
-			log.Printf(errorFormat, err.Error())
+			p.logger.Errorf("%v", err)
+		}
+		if seed := p.seedOf(dbpath, seedAsset); seed != nil {
+			seeded, err := seedDatabase(dbpath, seed)
+			if err != nil {
+				return nil, &codedError{code: ERROR_OPEN_FAILED, err: err}
+			}
+			if seeded && p.verbose() {
+				p.logger.Infof("seeded %s from %v", dbpath, seed)
+			}
+		}
+		if inCloudFolder(dbpath) {
+			p.warn(WARNING_CLOUD_FOLDER, dbpath, dbpath+" is in a cloud-synced folder, the database may get corrupted by the synchronization")
 		}
 	}
 	if singleInstance {
 		dbId, ok := p.getDatabaseByPath(dbpath)
 		if ok {
+			if err = p.resolveOpenConflict(dbId, dbpath, readOnly, openConflict); err != nil {
+				return nil, err
+			}
+			p.Lock()
+			p.singleInstances[dbId] = true
+			p.Unlock()
 			return map[interface{}]interface{}{
 				PARAM_ID:        dbId,
 				PARAM_RECOVERED: true,
 			}, nil
 		}
 	}
-	var engine *sql.DB
-	engine, err = sql.Open("sqlite3", dbpath)
+	if options.extensions, err = p.extensionsOf(dbpath, extensions); err != nil {
+		return nil, err
+	}
+	dsn := pathDSN(dbpath)
+	var params []string
+	if sharedCache && MEMORY_DATABASE_PATH != dbpath {
+		// the handles opened on the path with sharedCache share their pages
+		params = append(params, "cache=shared")
+		dsn = uriDSN(dbpath, params...)
+	}
+	options.accessMode = newAccessMode(readOnly)
+	options.changes = &changeSource{path: dbpath}
+	if MEMORY_DATABASE_PATH != dbpath {
+		options.accessMode.readOnlyDSN = uriDSN(dbpath, append(params, "mode=ro")...)
+		// a reopened connection keeps the WAL mode set by the app
+		options.keepWAL = dbpath
+	}
+	engine, err := p.openVerified(dsn, dbpath, options, integrityCheck && MEMORY_DATABASE_PATH != dbpath)
 	if err != nil {
 		return nil, err
 	}
+	if !readOnly {
+		if err = p.migrate(engine, dbpath); err != nil {
+			engine.Close()
+			return nil, &codedError{code: ERROR_OPEN_FAILED, err: err}
+		}
+		if err = p.setupSync(engine, dbpath); err != nil {
+			engine.Close()
+			return nil, &codedError{code: ERROR_OPEN_FAILED, err: err}
+		}
+	}
 	p.Lock()
 	defer p.Unlock()
 	p.databaseId++
 	p.databases[p.databaseId] = engine
 	p.databasePaths[p.databaseId] = dbpath
+	atomic.StoreInt32(&options.changes.databaseId, p.databaseId)
+	p.accessModes[p.databaseId] = options.accessMode
+	p.connPragmas[p.databaseId] = options.pragmas
+	p.attachments[p.databaseId] = options.attachments
+	if singleInstance {
+		p.singleInstances[p.databaseId] = true
+	}
+	p.logLevels[p.databaseId] = atomic.LoadInt32(&p.logLevel)
+	p.stats[p.databaseId] = &dbStats{}
+	if statementCache > 0 {
+		p.stmtCaches[p.databaseId] = newStmtCache(statementCache)
+	}
+	// an in-memory database is not shared with other connections
+	if readPoolSize > 0 && MEMORY_DATABASE_PATH != dbpath {
+		poolDSN := dsn
+		if readOnly {
+			poolDSN = options.accessMode.readOnlyDSN
+		}
+		p.readPoolOpeners[p.databaseId] = func() *sql.DB {
+			return p.openReadPool(poolDSN, readPoolSize, options)
+		}
+		p.readPools[p.databaseId] = p.readPoolOpeners[p.databaseId]()
+	}
+	if options.cache != nil {
+		p.queryCaches[p.databaseId] = options.cache
+	}
+	if limiter != nil {
+		p.writeLimiters[p.databaseId] = limiter
+	}
 	return map[interface{}]interface{}{
 		PARAM_ID:        p.databaseId,
 		PARAM_RECOVERED: false,
@@ -252,45 +894,64 @@ func (p *SqflitePlugin) handleOpenDatabase(arguments interface{}) (reply interfa
 }
 
 func (p *SqflitePlugin) handleInsert(arguments interface{}) (reply interface{}, err error) {
-	_, db, err := p.getDatabase(arguments)
+	databaseId, db, err := p.getDatabase(arguments)
 	if err != nil {
 		return nil, err
 	}
 	sqlStr, args, err := p.getSqlCommand(arguments)
-	if p.debug {
-		log.Println("sql=", sqlStr, "args=", args)
-	}
+	p.logSQL(databaseId, sqlStr, args)
 	if err != nil {
 		return nil, err
 	}
-	result, err := db.Exec(sqlStr, args...)
-	if err != nil {
+	p.throttleWrite(databaseId)
+	result, err := p.execStatement(databaseId, db, arguments, sqlStr, args)
+	p.clearQueryCache(databaseId, sqlStr)
+	if err != nil || noResult(arguments) {
 		return nil, err
 	}
 	return result.LastInsertId()
 }
 
+// handleBatch runs the PARAM_OPERATIONS, and returns the list of their
+// results: {result: value} for each operation. With PARAM_CONTINUE_OR_ERROR,
+// a failed operation gives {error: {code, message, data}} and the next ones
+// are run, otherwise the batch stops at the first error. With
+// PARAM_NO_RESULT, the results are not read and nil is returned. Outside of
+// a transaction, the operations run in their own transaction, rolled back
+// when the batch fails.
 func (p *SqflitePlugin) handleBatch(arguments interface{}) (reply interface{}, err error) {
-	_, db, err := p.getDatabase(arguments)
+	databaseId, db, err := p.getDatabase(arguments)
 	if err != nil {
 		return nil, err
 	}
-	args, ok := arguments.(map[string]interface{})
-	if !ok {
-		return nil, errors.New("invalid args")
-	}
+	args := arguments.(map[interface{}]interface{})
 	ioperations, ok := args[PARAM_OPERATIONS]
 	if !ok {
 		return nil, errors.New("invalid operation")
 	}
-	operations, ok := ioperations.([]map[string]interface{})
+	operations, ok := ioperations.([]interface{})
 	if !ok {
 		return nil, errors.New("invalid operation data format")
 	}
-	if err != nil {
-		return nil, err
+	continueOnError, _ := args[PARAM_CONTINUE_OR_ERROR].(bool)
+	noResult, _ := args[PARAM_NO_RESULT].(bool)
+	exec := p.executor(databaseId, db, arguments)
+	var tx *sql.Tx
+	if _, open := p.transactionId(databaseId); !open {
+		if tx, err = exec.(txBeginner).BeginTx(p.ctx, nil); err != nil {
+			return nil, err
+		}
+		defer tx.Rollback()
+		exec = tx
 	}
-	for _, operate := range operations {
+	stmts := newBatchStatements(p.ctx, exec, operations)
+	defer stmts.close()
+	results := make([]interface{}, 0, len(operations))
+	for _, ioperate := range operations {
+		operate, ok := ioperate.(map[interface{}]interface{})
+		if !ok {
+			return nil, errors.New("invalid operation data format")
+		}
 		mtd, ok := operate[PARAM_METHOD]
 		if !ok {
 			return nil, errors.New("empty method")
@@ -303,26 +964,83 @@ func (p *SqflitePlugin) handleBatch(arguments interface{}) (reply interface{}, e
 		if err != nil {
 			return nil, err
 		}
+		var result interface{}
+		opExec, err := stmts.executor(sqlStr)
+		if err == nil {
+			start := time.Now()
+			result, err = p.batchOperation(databaseId, opExec, method, sqlStr, xargs, noResult)
+			p.traceStatement(databaseId, method, sqlStr, xargs, time.Since(start), err)
+		}
+		if err != nil && continueOnError {
+			results = append(results, map[interface{}]interface{}{
+				PARAM_ERROR: batchError(err, sqlStr, xargs),
+			})
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if !noResult {
+			results = append(results, map[interface{}]interface{}{
+				PARAM_RESULT: result,
+			})
+		}
+	}
+	if tx != nil {
+		if err = tx.Commit(); err != nil {
+			return nil, err
+		}
+	}
+	if noResult {
+		return nil, nil
+	}
+	return results, nil
+}
+
+// batchError returns the error entry of a failed batch operation
+func batchError(err error, sqlStr string, args []interface{}) map[interface{}]interface{} {
+	code, message, data := sqfliteError(err, sqlStr, args)
+	return map[interface{}]interface{}{
+		PARAM_ERROR_CODE:    code,
+		PARAM_ERROR_MESSAGE: message,
+		PARAM_ERROR_DATA:    data,
+	}
+}
+
+// batchOperation runs an operation of a batch, returning its result as the
+// method would, or nil without reading it for noResult
+func (p *SqflitePlugin) batchOperation(databaseId int32, exec executor, method, sqlStr string, args []interface{}, noResult bool) (interface{}, error) {
+	switch method {
+	case METHOD_INSERT, METHOD_UPDATE, METHOD_EXECUTE:
+		p.throttleWrite(databaseId)
+		result, err := exec.ExecContext(p.ctx, sqlStr, args...)
+		p.clearQueryCache(databaseId, sqlStr)
+		if err == nil {
+			p.trackSessionState(databaseId, sqlStr)
+		}
+		if err != nil || noResult {
+			return nil, err
+		}
 		switch method {
-		case METHOD_UPDATE:
-			fallthrough
 		case METHOD_INSERT:
-			fallthrough
-		case METHOD_EXECUTE:
-			_, err = db.Exec(sqlStr, xargs...)
-			if err != nil {
-				return nil, err
-			}
-		case METHOD_QUERY:
-			_, err = db.Query(sqlStr, xargs...)
-			if err != nil {
-				return nil, err
+			return result.LastInsertId()
+		case METHOD_UPDATE:
+			return result.RowsAffected()
+		}
+		return nil, nil
+	case METHOD_QUERY:
+		rows, err := exec.QueryContext(p.ctx, sqlStr, args...)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		if noResult {
+			for rows.Next() {
 			}
-		default:
-			return nil, errors.New("Invalid batch param")
+			return nil, rows.Err()
 		}
+		return queryReply(rows)
 	}
-	return nil, nil
+	return nil, errors.New("Invalid batch param")
 }
 
 func (p *SqflitePlugin) handleDebugMode(arguments interface{}) (reply interface{}, err error) {
@@ -331,6 +1049,23 @@ func (p *SqflitePlugin) handleDebugMode(arguments interface{}) (reply interface{
 	if args, ok = arguments.(map[interface{}]interface{}); !ok {
 		return nil, errors.New("Invalid argument type")
 	}
+	if verbose, ok := args[PARAM_VERBOSE_ERRORS].(bool); ok {
+		// the details expose the SQL and arguments, the host opts in
+		p.Lock()
+		allowed := p.dartVerboseErrors
+		if allowed {
+			p.verboseErrors = verbose
+		}
+		p.Unlock()
+		if !allowed && verbose {
+			p.logger.Warnf("verboseErrors of debugMode ignored, enable them with WithVerboseErrors")
+		}
+	}
+	if mode, ok := args[PARAM_TEST_MODE]; ok {
+		if err = p.setTestModeParam(mode); err != nil {
+			return nil, err
+		}
+	}
 	v, ok := args[METHOD_DEBUG_MODE]
 	if !ok {
 		return nil, nil
@@ -344,118 +1079,226 @@ func (p *SqflitePlugin) handleDebugMode(arguments interface{}) (reply interface{
 }
 
 func (p *SqflitePlugin) handleExecute(arguments interface{}) (reply interface{}, err error) {
-	_, db, err := p.getDatabase(arguments)
+	databaseId, db, err := p.getDatabase(arguments)
 	if err != nil {
 		return nil, err
 	}
 	sqlStr, args, err := p.getSqlCommand(arguments)
-	if p.debug {
-		log.Println("sql=", sqlStr, "args=", args)
-	}
+	p.logSQL(databaseId, sqlStr, args)
 	if err != nil {
 		return nil, err
 	}
 	var r sql.Result
-	r, err = db.Exec(sqlStr, args...)
-	if p.debug {
-		log.Printf("result=%#v err=%v\n", r, err)
+	p.throttleWrite(databaseId)
+	r, err = p.execStatement(databaseId, db, arguments, sqlStr, args)
+	p.clearQueryCache(databaseId, sqlStr)
+	if p.verbose() {
+		p.logger.Debugf("result=%#v err=%v", r, err)
 	}
-	if err != nil && !strings.Contains(err.Error(), "already exists") {
+	if err != nil && !(p.ignoreAlreadyExists && strings.Contains(err.Error(), "already exists")) {
 		return nil, err
 	}
-
+	if in, _ := arguments.(map[interface{}]interface{})[PARAM_IN_TRANSACTION].(bool); in {
+		// sqflite 2 clients pass the id to the calls of the transaction
+		if id, ok := p.transactionId(databaseId); ok {
+			return map[interface{}]interface{}{
+				PARAM_TRANSACTION_ID: id,
+			}, nil
+		}
+	}
 	return nil, nil
 }
 
 func (p *SqflitePlugin) handleUpdate(arguments interface{}) (reply interface{}, err error) {
-	_, db, err := p.getDatabase(arguments)
+	databaseId, db, err := p.getDatabase(arguments)
 	if err != nil {
 		return 0, err
 	}
 	sqlStr, args, err := p.getSqlCommand(arguments)
-	if p.debug {
-		log.Println("sql=", sqlStr, "args=", args)
-	}
+	p.logSQL(databaseId, sqlStr, args)
 	if err != nil {
 		return nil, err
 	}
-	result, err := db.Exec(sqlStr, args...)
+	p.throttleWrite(databaseId)
+	result, err := p.execStatement(databaseId, db, arguments, sqlStr, args)
+	p.clearQueryCache(databaseId, sqlStr)
 	if err != nil {
 		return 0, err
 	}
+	if noResult(arguments) {
+		return nil, nil
+	}
 	return result.RowsAffected()
 }
 
 func (p *SqflitePlugin) handleQuery(arguments interface{}) (reply interface{}, err error) {
-	_, db, err := p.getDatabase(arguments)
+	databaseId, db, err := p.getDatabase(arguments)
 	if err != nil {
 		return nil, err
 	}
 	sqlStr, args, err := p.getSqlCommand(arguments)
-	if p.debug {
-		log.Println("sql=", sqlStr, "args=", args)
+	p.logSQL(databaseId, sqlStr, args)
+	if err != nil {
+		return nil, err
+	}
+	if pageSize, ok := toFloat(arguments.(map[interface{}]interface{})[PARAM_CURSOR_PAGE_SIZE]); ok && pageSize > 0 {
+		return p.openCursor(databaseId, db, sqlStr, args, int(pageSize))
+	}
+	exec := p.executor(databaseId, db, arguments)
+	cache := p.getQueryCache(databaseId)
+	if exec != executor(db) {
+		// results read within a transaction may be rolled back
+		cache = nil
 	}
+	var cacheKeyStr string
+	var generation int64
+	if cache != nil {
+		var hit bool
+		cacheKeyStr = cacheKey(sqlStr, args)
+		if reply, generation, hit = cache.get(cacheKeyStr); hit {
+			if p.verbose() {
+				p.logger.Debugf("query cache hit")
+			}
+			return reply, nil
+		}
+	}
+	token, withToken, err := getCancelToken(arguments)
 	if err != nil {
 		return nil, err
 	}
-	rows, err := db.Query(sqlStr, args...)
+	if withToken {
+		reply, err = p.queryCancelable(token, databaseId, db, exec, sqlStr, args)
+		if err == nil && exec == executor(db) {
+			p.recordPragma(databaseId, sqlStr)
+		}
+	} else if exec == executor(db) {
+		err = p.retryBusy(func() error {
+			reply, err = readQuery(p.queryRead(databaseId, db, sqlStr, args))
+			return err
+		})
+		if err == nil {
+			p.recordPragma(databaseId, sqlStr)
+		}
+	} else {
+		reply, err = readQuery(exec.QueryContext(p.ctx, sqlStr, args...))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cache != nil {
+		if tables, ok := cache.tablesOf(p.ctx, db, sqlStr, args); ok {
+			cache.put(cacheKeyStr, tables, reply, generation)
+		}
+	}
+	return reply, nil
+}
+
+// readQuery returns the queryReply of the rows of a query, closing them
+func readQuery(rows *sql.Rows, err error) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+	return queryReply(rows)
+}
+
+// queryReply reads rows into the result of a query: {columns, rows}
+func queryReply(rows *sql.Rows) (interface{}, error) {
 	cols, err := rows.Columns()
 	if err != nil {
 		return nil, err
 	}
-	var resultRows []interface{}
-	for {
+	resultRows, _, err := readRows(rows, rowValueTypes(rows), len(cols), -1)
+	if err != nil {
+		return nil, err
+	}
+	return map[interface{}]interface{}{
+		"columns": columnList(cols),
+		"rows":    resultRows,
+	}, nil
+}
+
+// readRows reads up to limit rows, or all of them for a negative limit, and
+// tells if rows may remain
+func readRows(rows *sql.Rows, types valueTypes, ncols int, limit int) (resultRows []interface{}, more bool, err error) {
+	for limit < 0 || len(resultRows) < limit {
 		if !rows.Next() {
-			break
+			return resultRows, false, rows.Err()
 		}
 		var resultRow []interface{}
-		dest := make([]interface{}, len(cols))
-		for k, _ := range cols {
+		dest := make([]interface{}, ncols)
+		for k := range dest {
 			var ignore interface{}
 			dest[k] = &ignore
 		}
-		err = rows.Scan(dest...)
-		for _, cval := range dest {
+		if err = rows.Scan(dest...); err != nil {
+			return nil, false, err
+		}
+		for k, cval := range dest {
 			var val interface{}
 			val = *cval.(*interface{})
 			var out interface{}
 			switch val.(type) {
 			case []byte:
-				out = string(val.([]byte))
+				// TEXT values are read as []byte too
+				if types.isBlob(k) {
+					out = val
+				} else {
+					out = string(val.([]byte))
+				}
+			case bool, time.Time:
+				out = types.value(k, val)
 			default:
 				out = val
 			}
 			resultRow = append(resultRow, out)
 		}
-		//log.Printf("resultrow=%#v\n", resultRow)
 		resultRows = append(resultRows, resultRow)
 	}
+	return resultRows, true, nil
+}
+
+func columnList(cols []string) []interface{} {
 	var icols []interface{}
 	for _, col := range cols {
 		icols = append(icols, col)
 	}
-	return map[interface{}]interface{}{
-		"columns": icols,
-		"rows":    resultRows,
-	}, nil
+	return icols
 }
 
 func (p *SqflitePlugin) handleDatabaseExists(arguments interface{}) (reply interface{}, err error) {
-	return false, nil
+	dbPath, err := pathArgument(arguments)
+	if err != nil {
+		return nil, err
+	}
+	if dbPath == MEMORY_DATABASE_PATH {
+		return false, nil
+	}
+	_, err = os.Stat(p.resolvePath(dbPath))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
 }
 
 func (p *SqflitePlugin) handleDeleteDatabase(arguments interface{}) (reply interface{}, err error) {
-	if dbPath, ok := arguments.(string); ok {
-		if dbPath != MEMORY_DATABASE_PATH {
-			err = os.Remove(dbPath)
-		}
+	dbPath, err := pathArgument(arguments)
+	if err != nil {
+		return nil, err
+	}
+	if dbPath != MEMORY_DATABASE_PATH {
+		err = os.Remove(p.resolvePath(dbPath))
 	}
 	return nil, err
 }
 
+// noResult tells if the caller does not read the result, with PARAM_NO_RESULT
+func noResult(arguments interface{}) bool {
+	args, _ := arguments.(map[interface{}]interface{})
+	noResult, _ := args[PARAM_NO_RESULT].(bool)
+	return noResult
+}
+
 func (p *SqflitePlugin) getDatabase(arguments interface{}) (int32, *sql.DB, error) {
 	var args map[interface{}]interface{}
 	var ok bool
@@ -472,6 +1315,8 @@ func (p *SqflitePlugin) getDatabase(arguments interface{}) (int32, *sql.DB, erro
 		if db, ok := p.databases[id]; ok {
 			return id, db, nil
 		}
+		// ids are not reused, an unknown one is that of a closed database
+		return -1, nil, &codedError{code: ERROR_DATABASE_CLOSED, err: errors.Errorf("database %d is closed", id)}
 	}
 	return -1, nil, errors.New("invalid database")
 }
@@ -480,16 +1325,31 @@ func (p *SqflitePlugin) getDatabaseByPath(dbPath string) (int32, bool) {
 	if dbPath == MEMORY_DATABASE_PATH {
 		return -1, false
 	}
+	dbPath = normalizePath(dbPath)
 	p.Lock()
 	defer p.Unlock()
 	for id, pt := range p.databasePaths {
-		if pt == dbPath {
+		if samePath(pt, dbPath) {
 			return id, true
 		}
 	}
 	return -1, false
 }
 
+func (p *SqflitePlugin) getQueryCache(databaseId int32) *queryCache {
+	p.Lock()
+	defer p.Unlock()
+	return p.queryCaches[databaseId]
+}
+
+// clearQueryCache clears the query cache of a database after running a
+// statement the cache hooks cannot follow
+func (p *SqflitePlugin) clearQueryCache(databaseId int32, sqlStr string) {
+	if cache := p.getQueryCache(databaseId); cache != nil {
+		cache.clearAfter(sqlStr)
+	}
+}
+
 func (p *SqflitePlugin) getSqlCommand(arguments interface{}) (sqlStr string, xargs []interface{}, err error) {
 	var args map[interface{}]interface{}
 	var ok bool
@@ -500,13 +1360,22 @@ func (p *SqflitePlugin) getSqlCommand(arguments interface{}) (sqlStr string, xar
 	if !ok {
 		return "", nil, errors.New("SQL is not set")
 	}
-	sqlStr = tsql.(string)
+	if sqlStr, ok = tsql.(string); !ok {
+		return "", nil, errors.New("SQL is not a string")
+	}
 	if sqlStr == "" {
 		return "", nil, errors.New("SQL is empty")
 	}
 	targs, ok := args[PARAM_SQL_ARGUMENTS]
 	if ok && targs != nil {
-		xargs, _ = targs.([]interface{})
+		if xargs, ok = targs.([]interface{}); !ok {
+			return "", nil, errors.New("arguments is not a list")
+		}
+	}
+	for i, arg := range xargs {
+		if xargs[i], err = sqlArgument(arg); err != nil {
+			return "", nil, err
+		}
 	}
 	return
 }
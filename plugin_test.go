@@ -0,0 +1,111 @@
+package sqflite
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestPlugin returns a plugin storing its databases in a temporary folder
+func newTestPlugin(t *testing.T, options ...Option) *SqflitePlugin {
+	t.Helper()
+	p := NewSqflitePlugin("vendor", "app", append([]Option{WithDatabasesPath(t.TempDir())}, options...)...)
+	t.Cleanup(p.Shutdown)
+	return p
+}
+
+// openTestDatabase opens the database path with the extra openDatabase
+// arguments args, and returns its id
+func openTestDatabase(t *testing.T, p *SqflitePlugin, path string, args map[interface{}]interface{}) int32 {
+	t.Helper()
+	arguments := map[interface{}]interface{}{PARAM_PATH: path}
+	for k, v := range args {
+		arguments[k] = v
+	}
+	reply, err := p.handleOpenDatabase(arguments)
+	if err != nil {
+		t.Fatalf("openDatabase %s: %v", path, err)
+	}
+	return reply.(map[interface{}]interface{})[PARAM_ID].(int32)
+}
+
+// sqlArgs returns the arguments of a call running sqlStr on databaseId
+func sqlArgs(databaseId int32, sqlStr string, args ...interface{}) map[interface{}]interface{} {
+	arguments := map[interface{}]interface{}{PARAM_ID: databaseId, PARAM_SQL: sqlStr}
+	if len(args) > 0 {
+		arguments[PARAM_SQL_ARGUMENTS] = args
+	}
+	return arguments
+}
+
+// mustCall calls handler with arguments and fails the test on error
+func mustCall(t *testing.T, handler func(interface{}) (interface{}, error), arguments map[interface{}]interface{}) interface{} {
+	t.Helper()
+	reply, err := handler(arguments)
+	if err != nil {
+		t.Fatalf("%v: %v", arguments[PARAM_SQL], err)
+	}
+	return reply
+}
+
+// queryRows runs the query sqlStr on databaseId and returns its rows
+func queryRows(t *testing.T, p *SqflitePlugin, databaseId int32, sqlStr string, args ...interface{}) []interface{} {
+	t.Helper()
+	reply := mustCall(t, p.handleQuery, sqlArgs(databaseId, sqlStr, args...))
+	return reply.(map[interface{}]interface{})["rows"].([]interface{})
+}
+
+// queryValue runs the query sqlStr on databaseId and returns the first column
+// of its single row
+func queryValue(t *testing.T, p *SqflitePlugin, databaseId int32, sqlStr string, args ...interface{}) interface{} {
+	t.Helper()
+	rows := queryRows(t, p, databaseId, sqlStr, args...)
+	if len(rows) != 1 {
+		t.Fatalf("%s returned %d rows, want 1", sqlStr, len(rows))
+	}
+	return rows[0].([]interface{})[0]
+}
+
+// reservedConnKinds are the ways the connection of a database is reserved,
+// see reserveTestConn
+var reservedConnKinds = []string{"transaction", "cursor"}
+
+// reserveTestConn reserves the connection of databaseId to an open
+// transaction or cursor, as kind says, and returns the function ending it
+func reserveTestConn(t *testing.T, p *SqflitePlugin, databaseId int32, kind string) func() {
+	t.Helper()
+	if kind == "cursor" {
+		query := sqlArgs(databaseId, "SELECT 1 UNION ALL SELECT 2")
+		query[PARAM_CURSOR_PAGE_SIZE] = int32(1)
+		reply := mustCall(t, p.handleQuery, query).(map[interface{}]interface{})
+		return func() {
+			mustCall(t, p.handleQueryCursorNext, map[interface{}]interface{}{
+				PARAM_CURSOR_ID: reply[PARAM_CURSOR_ID],
+				PARAM_CANCEL:    true,
+			})
+		}
+	}
+	begin := sqlArgs(databaseId, "BEGIN IMMEDIATE")
+	begin[PARAM_IN_TRANSACTION] = true
+	mustCall(t, p.handleExecute, begin)
+	return func() {
+		rollback := sqlArgs(databaseId, "ROLLBACK")
+		rollback[PARAM_IN_TRANSACTION] = false
+		mustCall(t, p.handleExecute, rollback)
+	}
+}
+
+// callWithin returns the error of call, failing the test when it blocks
+func callWithin(t *testing.T, name string, call func() error) error {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() {
+		done <- call()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(10 * time.Second):
+		t.Fatalf("%s blocked", name)
+		return nil
+	}
+}
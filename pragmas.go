@@ -0,0 +1,119 @@
+package sqflite
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// connPragmas are the pragmas of a database applied to each of its new
+// connections, in the order they were first set. The per-connection pragmas
+// set by the app, such as foreign_keys, are recorded so that a reopened
+// connection keeps them.
+type connPragmas struct {
+	sync.Mutex
+	names  []string
+	values map[string]string
+}
+
+func newConnPragmas() *connPragmas {
+	return &connPragmas{values: make(map[string]string)}
+}
+
+func (c *connPragmas) set(name, value string) {
+	c.Lock()
+	defer c.Unlock()
+	name = strings.ToLower(name)
+	if _, ok := c.values[name]; !ok {
+		c.names = append(c.names, name)
+	}
+	c.values[name] = value
+}
+
+// pragma names, and the values given as strings, such as "NORMAL" or "-2000"
+var (
+	pragmaName  = regexp.MustCompile(`^\w+$`)
+	pragmaValue = regexp.MustCompile(`^-?[\w.]+$`)
+)
+
+// setAll sets the pragmas of the pragmas map of openDatabase, by name order:
+// bool values are set as ON or OFF
+func (c *connPragmas) setAll(pragmas interface{}) error {
+	m, ok := pragmas.(map[interface{}]interface{})
+	if !ok {
+		return errors.New("invalid pragmas")
+	}
+	names := make([]string, 0, len(m))
+	values := make(map[string]string, len(m))
+	for k, v := range m {
+		name, ok := k.(string)
+		if !ok || !pragmaName.MatchString(name) {
+			return errors.Errorf("invalid pragma name %v", k)
+		}
+		var value string
+		switch v := v.(type) {
+		case bool:
+			value = "OFF"
+			if v {
+				value = "ON"
+			}
+		case int32, int64, float64:
+			value = fmt.Sprint(v)
+		case string:
+			if !pragmaValue.MatchString(v) {
+				return errors.Errorf("invalid value %q of pragma %s", v, name)
+			}
+			value = v
+		default:
+			return errors.Errorf("invalid value %v of pragma %s", v, name)
+		}
+		names = append(names, name)
+		values[name] = value
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c.set(name, values[name])
+	}
+	return nil
+}
+
+// statements returns the PRAGMA statements applying the pragmas
+func (c *connPragmas) statements() []string {
+	if c == nil {
+		return nil
+	}
+	c.Lock()
+	defer c.Unlock()
+	stmts := make([]string, 0, len(c.names))
+	for _, name := range c.names {
+		stmts = append(stmts, "PRAGMA "+name+" = "+c.values[name])
+	}
+	return stmts
+}
+
+// recordedPragmas are the pragmas recorded when set by the app
+var recordedPragmas = map[string]bool{
+	"foreign_keys": true,
+}
+
+// pragmaAssignment matches PRAGMA name = value and PRAGMA name(value)
+var pragmaAssignment = regexp.MustCompile(`(?i)^\s*PRAGMA\s+(\w+)\s*(?:=\s*(\w+)|\(\s*(\w+)\s*\))\s*;?\s*$`)
+
+// recordPragma records the pragma set by sqlStr, if any and recorded, in
+// the pragmas of databaseId
+func (p *SqflitePlugin) recordPragma(databaseId int32, sqlStr string) {
+	m := pragmaAssignment.FindStringSubmatch(sqlStr)
+	if m == nil || !recordedPragmas[strings.ToLower(m[1])] {
+		return
+	}
+	p.Lock()
+	pragmas := p.connPragmas[databaseId]
+	p.Unlock()
+	if pragmas != nil {
+		pragmas.set(m[1], m[2]+m[3])
+	}
+}
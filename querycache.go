@@ -0,0 +1,262 @@
+package sqflite
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// functions whose result can change between two identical queries
+var volatileFunctions = []string{
+	"random", "randomblob", "changes", "total_changes", "last_insert_rowid",
+	"date", "time", "datetime", "julianday", "strftime", "encrypt_col",
+	"datetime_local", "now_ms", "uuid4", "db_status",
+}
+
+var withoutRowid = regexp.MustCompile(`(?i)\bwithout\s+rowid\b`)
+
+// queryCache keeps the results of the queries of a database, keyed by sql and
+// arguments. Each entry depends on the tables read by its query and is dropped
+// when the update hook reports a change in one of them. Changes the update hook
+// does not report (schema changes, truncating deletes, rollbacks) clear the
+// whole cache. Only the changes made through the database handler are seen.
+type queryCache struct {
+	sync.Mutex
+	max        int
+	entries    map[string]*list.Element
+	lru        *list.List
+	generation int64            // incremented on every invalidation
+	rootPages  map[int64]string // table of each btree root page, nil when unknown
+	noRowid    map[string]bool  // WITHOUT ROWID tables, never reported by the update hook
+}
+
+type cacheEntry struct {
+	key    string
+	tables []string
+	reply  interface{}
+}
+
+func newQueryCache(max int) *queryCache {
+	return &queryCache{
+		max:     max,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// cacheKey identifies a query and its arguments, keeping the argument types
+func cacheKey(sqlStr string, args []interface{}) string {
+	return sqlStr + "\x00" + fmt.Sprintf("%#v", args)
+}
+
+// get returns the cached reply of key, and the cache generation to pass to put
+// on a miss
+func (c *queryCache) get(key string) (interface{}, int64, bool) {
+	c.Lock()
+	defer c.Unlock()
+	if e, ok := c.entries[key]; ok {
+		c.lru.MoveToFront(e)
+		return e.Value.(*cacheEntry).reply, c.generation, true
+	}
+	return nil, c.generation, false
+}
+
+// put caches reply unless the cache was invalidated since generation
+func (c *queryCache) put(key string, tables []string, reply interface{}, generation int64) {
+	c.Lock()
+	defer c.Unlock()
+	if generation != c.generation {
+		return
+	}
+	if e, ok := c.entries[key]; ok {
+		c.lru.Remove(e)
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, tables: tables, reply: reply})
+	for c.lru.Len() > c.max {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.entries, e.Value.(*cacheEntry).key)
+	}
+}
+
+// invalidate drops the entries depending on the given tables
+func (c *queryCache) invalidate(tables map[string]bool) {
+	c.Lock()
+	defer c.Unlock()
+	c.generation++
+	for e := c.lru.Front(); e != nil; {
+		next := e.Next()
+		for _, t := range e.Value.(*cacheEntry).tables {
+			if tables[t] {
+				c.lru.Remove(e)
+				delete(c.entries, e.Value.(*cacheEntry).key)
+				break
+			}
+		}
+		e = next
+	}
+}
+
+func (c *queryCache) clear() {
+	c.Lock()
+	defer c.Unlock()
+	c.generation++
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+	c.rootPages = nil
+	c.noRowid = nil
+}
+
+// clearAfter clears the cache after running sqlStr if the statement can change
+// data without the update hook knowing
+func (c *queryCache) clearAfter(sqlStr string) {
+	s := strings.TrimSpace(sqlStr)
+	if i := strings.Index(s, ";"); i >= 0 && strings.TrimSpace(s[i+1:]) != "" {
+		// several statements
+		c.clear()
+		return
+	}
+	upper := strings.ToUpper(s)
+	fields := strings.Fields(upper)
+	if len(fields) == 0 {
+		return
+	}
+	switch strings.SplitN(fields[0], "(", 2)[0] {
+	case "SELECT", "INSERT", "REPLACE", "UPDATE", "BEGIN", "COMMIT", "END", "SAVEPOINT", "RELEASE":
+		return
+	case "DELETE", "WITH":
+		// without a WHERE clause sqlite truncates the table, skipping the update hook
+		if !strings.Contains(upper, "DELETE") || strings.Contains(upper, "WHERE") {
+			return
+		}
+	}
+	c.clear()
+}
+
+// hook registers the update, commit and rollback hooks invalidating the cache
+// on a connection
+func (c *queryCache) hook(hooks *connHooks) {
+	// tables changed by the current transaction of the connection
+	changed := make(map[string]bool)
+	hooks.onUpdate(func(op int, db string, table string, rowid int64) {
+		if db != "main" {
+			return
+		}
+		if !changed[table] {
+			changed[table] = true
+			c.invalidate(map[string]bool{table: true})
+		}
+	})
+	hooks.onCommit(func() int {
+		if len(changed) == 0 {
+			// schema change or truncation
+			c.clear()
+		} else {
+			// the other connections may have cached rows read before the commit
+			c.invalidate(changed)
+			changed = make(map[string]bool)
+		}
+		return 0
+	})
+	hooks.onRollback(func() {
+		changed = make(map[string]bool)
+		c.clear()
+	})
+}
+
+// tablesOf returns the tables read by a query run with args, and false when
+// its result cannot be cached
+func (c *queryCache) tablesOf(ctx context.Context, db queryer, sqlStr string, args []interface{}) ([]string, bool) {
+	s := strings.TrimSpace(sqlStr)
+	if i := strings.Index(s, ";"); i >= 0 && strings.TrimSpace(s[i+1:]) != "" {
+		return nil, false
+	}
+	upper := strings.ToUpper(s)
+	if !strings.HasPrefix(upper, "SELECT") && !strings.HasPrefix(upper, "WITH") {
+		return nil, false
+	}
+	if err := c.loadSchema(ctx, db); err != nil {
+		return nil, false
+	}
+	rows, err := db.QueryContext(ctx, "EXPLAIN "+s, args...)
+	if err != nil {
+		return nil, false
+	}
+	defer rows.Close()
+	c.Lock()
+	defer c.Unlock()
+	seen := make(map[string]bool)
+	var tables []string
+	for rows.Next() {
+		var addr, p1, p2, p3, p5 int64
+		var opcode string
+		var p4, comment sql.NullString
+		if err = rows.Scan(&addr, &opcode, &p1, &p2, &p3, &p4, &p5, &comment); err != nil {
+			return nil, false
+		}
+		switch opcode {
+		case "OpenRead", "ReopenIdx":
+			table, ok := c.rootPages[p2]
+			if p3 != 0 || !ok || c.noRowid[table] {
+				// temp or attached schema, or not reported by the update hook
+				return nil, false
+			}
+			if !seen[table] {
+				seen[table] = true
+				tables = append(tables, table)
+			}
+		case "VOpen", "OpenWrite":
+			return nil, false
+		case "Function", "Function0", "PureFunc", "PureFunc0":
+			name := strings.ToLower(strings.SplitN(p4.String, "(", 2)[0])
+			for _, f := range volatileFunctions {
+				if name == f {
+					return nil, false
+				}
+			}
+		}
+	}
+	return tables, rows.Err() == nil
+}
+
+// loadSchema maps the btree root pages of the main schema to their table
+func (c *queryCache) loadSchema(ctx context.Context, db queryer) error {
+	c.Lock()
+	loaded, generation := c.rootPages != nil, c.generation
+	c.Unlock()
+	if loaded {
+		return nil
+	}
+	rows, err := db.QueryContext(ctx, "SELECT rootpage, tbl_name, type, sql FROM main.sqlite_master WHERE rootpage > 0")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	rootPages := map[int64]string{1: "sqlite_master"}
+	noRowid := make(map[string]bool)
+	for rows.Next() {
+		var page int64
+		var table, kind string
+		var stmt sql.NullString
+		if err = rows.Scan(&page, &table, &kind, &stmt); err != nil {
+			return err
+		}
+		rootPages[page] = table
+		if kind == "table" && withoutRowid.MatchString(stmt.String) {
+			noRowid[table] = true
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	c.Lock()
+	if generation == c.generation {
+		c.rootPages, c.noRowid = rootPages, noRowid
+	}
+	c.Unlock()
+	return nil
+}
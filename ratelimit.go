@@ -0,0 +1,86 @@
+package sqflite
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// writeLimiter is a token bucket throttling the writes of a database. A
+// write arriving when the bucket is empty waits for the next token.
+type writeLimiter struct {
+	sync.Mutex
+	rate      float64 // tokens per second
+	burst     float64
+	tokens    float64
+	last      time.Time
+	throttled int64     // writes delayed since the last warning
+	warned    time.Time // time of the last warning
+}
+
+// interval between two throttling warnings of a database
+const throttleWarningInterval = 10 * time.Second
+
+func newWriteLimiter(rate float64, burst int) *writeLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &writeLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// reserve takes a token and returns how long the write has to wait for it
+func (l *writeLimiter) reserve(now time.Time) time.Duration {
+	l.Lock()
+	defer l.Unlock()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// wait blocks until the write is allowed, calling warn with the number of
+// delayed writes when throttling engages
+func (l *writeLimiter) wait(warn func(throttled int64)) {
+	delay := l.reserve(time.Now())
+	if delay <= 0 {
+		return
+	}
+	l.Lock()
+	l.throttled++
+	throttled := l.throttled
+	if time.Since(l.warned) >= throttleWarningInterval {
+		l.warned = time.Now()
+		l.throttled = 0
+	} else {
+		throttled = 0
+	}
+	l.Unlock()
+	if throttled > 0 {
+		warn(throttled)
+	}
+	time.Sleep(delay)
+}
+
+// throttleWrite waits for the write limiter of a database, if any
+func (p *SqflitePlugin) throttleWrite(databaseId int32) {
+	p.Lock()
+	limiter := p.writeLimiters[databaseId]
+	dbPath := p.databasePaths[databaseId]
+	p.Unlock()
+	if limiter != nil {
+		limiter.wait(func(throttled int64) {
+			p.warn(WARNING_WRITE_THROTTLED, dbPath, fmt.Sprintf("write rate limit of %s reached, %d writes delayed, check for a write loop", dbPath, throttled))
+		})
+	}
+}
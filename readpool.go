@@ -0,0 +1,110 @@
+package sqflite
+
+import (
+	"database/sql"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// maximum number of read connections of a database
+const maxReadPoolSize = 16
+
+// openReadPool opens size connections on the file of a database, running its
+// queries concurrently with the writes, which stay serialized on the
+// connection of the database, opened with options. They refuse writes with
+// PRAGMA query_only, as opening them with mode=ro fails on WAL databases whose
+// -shm file has to be written.
+func (p *SqflitePlugin) openReadPool(dsn string, size int, options engineOptions) *sql.DB {
+	pool := p.openEngine(dsn, engineOptions{
+		accessMode:  newAccessMode(true),
+		keepWAL:     options.keepWAL,
+		busyTimeout: options.busyTimeout,
+		pragmas:     options.pragmas,
+		extensions:  options.extensions,
+		attachments: options.attachments,
+	})
+	pool.SetMaxOpenConns(size)
+	pool.SetMaxIdleConns(size)
+	return pool
+}
+
+func (p *SqflitePlugin) getReadPool(databaseId int32) *sql.DB {
+	p.Lock()
+	defer p.Unlock()
+	return p.readPools[databaseId]
+}
+
+// readStatement tells if sqlStr only reads, and can run on the read pool. A
+// WITH clause may be followed by a write, it runs on the database connection.
+func readStatement(sqlStr string) bool {
+	words := strings.Fields(strings.ToUpper(sqlStr))
+	return len(words) > 0 && words[0] == "SELECT"
+}
+
+// sessionStatement matches the statements using or leaving state on the
+// connection of a database that the connections of its read pool do not
+// have: temp tables and triggers, databases attached with SQL, and pragmas
+// set with SQL and not recorded, see recordedPragmas
+var sessionStatement = regexp.MustCompile(`(?i)\bCREATE\s+(TEMP|TEMPORARY)\b|\btemp\s*\.|\bATTACH\b|\bPRAGMA\s+[\w.]+\s*=`)
+
+// trackSessionState keeps the queries of databaseId on its connection once
+// sqlStr, run on it, left temp or attached state there
+func (p *SqflitePlugin) trackSessionState(databaseId int32, sqlStr string) {
+	if !sessionStatement.MatchString(sqlStr) {
+		return
+	}
+	if m := pragmaAssignment.FindStringSubmatch(sqlStr); m != nil && recordedPragmas[strings.ToLower(m[1])] {
+		return
+	}
+	p.Lock()
+	defer p.Unlock()
+	if _, open := p.databases[databaseId]; open {
+		p.sessionStates[databaseId] = true
+	}
+}
+
+// readPoolOf returns the read pool running the query sqlStr of databaseId,
+// nil when it runs on the connection of the database: the database has no
+// read pool, the query may write, or it may use the temp or attached state
+// of the connection
+func (p *SqflitePlugin) readPoolOf(databaseId int32, sqlStr string) *sql.DB {
+	if !readStatement(sqlStr) || sessionStatement.MatchString(sqlStr) {
+		return nil
+	}
+	p.Lock()
+	defer p.Unlock()
+	if p.sessionStates[databaseId] {
+		return nil
+	}
+	return p.readPools[databaseId]
+}
+
+// queryRead runs the query sqlStr on the read pool of databaseId, see
+// readPoolOf, or on db. A query failing on the pool because a table is
+// missing there runs again on db, which may have it in its temp schema.
+func (p *SqflitePlugin) queryRead(databaseId int32, db *sql.DB, sqlStr string, args []interface{}) (*sql.Rows, error) {
+	if pool := p.readPoolOf(databaseId, sqlStr); pool != nil {
+		rows, err := pool.QueryContext(p.ctx, sqlStr, args...)
+		if err == nil || !strings.Contains(err.Error(), "no such table") {
+			return rows, err
+		}
+	}
+	return p.queryCached(databaseId, db, sqlStr, args)
+}
+
+// isWALFile tells if the database file dbPath is in WAL mode, from the file
+// format versions of its header
+func isWALFile(dbPath string) bool {
+	f, err := os.Open(dbPath)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	header := make([]byte, 20)
+	if _, err = io.ReadFull(f, header); err != nil {
+		return false
+	}
+	return header[18] == 2 && header[19] == 2
+}
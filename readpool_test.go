@@ -0,0 +1,44 @@
+package sqflite
+
+import "testing"
+
+func TestReadPoolTempTable(t *testing.T) {
+	p := newTestPlugin(t)
+	id := openTestDatabase(t, p, "pool.db", map[interface{}]interface{}{
+		PARAM_JOURNAL_MODE:   "WAL",
+		PARAM_READ_POOL_SIZE: int32(2),
+	})
+	mustCall(t, p.handleExecute, sqlArgs(id, "CREATE TABLE t (x)"))
+	mustCall(t, p.handleInsert, sqlArgs(id, "INSERT INTO t VALUES (1)"))
+	// a plain table is read on the pool
+	if pool := p.readPoolOf(id, "SELECT * FROM t"); pool == nil {
+		t.Error("query not run on the read pool")
+	}
+	mustCall(t, p.handleExecute, sqlArgs(id, "CREATE TEMP TABLE tt (x)"))
+	mustCall(t, p.handleInsert, sqlArgs(id, "INSERT INTO tt VALUES (?)", int64(2)))
+	if v := queryValue(t, p, id, "SELECT x FROM tt"); v != int64(2) {
+		t.Errorf("SELECT x FROM tt = %v, want 2", v)
+	}
+	if v := queryValue(t, p, id, "SELECT count(*) FROM t JOIN temp.tt"); v != int64(1) {
+		t.Errorf("join = %v, want 1", v)
+	}
+	if pool := p.readPoolOf(id, "SELECT * FROM t"); pool != nil {
+		t.Error("query run on the read pool after creating a temp table")
+	}
+}
+
+func TestReadPoolMissingTableFallback(t *testing.T) {
+	p := newTestPlugin(t)
+	id := openTestDatabase(t, p, "pool.db", map[interface{}]interface{}{
+		PARAM_JOURNAL_MODE:   "WAL",
+		PARAM_READ_POOL_SIZE: int32(2),
+	})
+	// a temp table created outside of the calls tracking the session state
+	db := p.databases[id]
+	if _, err := db.Exec("CREATE TEMP TABLE hidden (x)"); err != nil {
+		t.Fatal(err)
+	}
+	if v := queryValue(t, p, id, "SELECT count(*) FROM hidden"); v != int64(0) {
+		t.Errorf("count = %v, want 0", v)
+	}
+}
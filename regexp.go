@@ -0,0 +1,58 @@
+package sqflite
+
+import (
+	"regexp"
+	"sync"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// compiled patterns of regexp() kept, the cache being emptied when full
+const maxRegexpCache = 64
+
+// regexpCache keeps the patterns compiled by regexp(), shared by the
+// connections
+var regexpCache = struct {
+	sync.Mutex
+	patterns map[string]*regexp.Regexp
+}{patterns: make(map[string]*regexp.Regexp)}
+
+// registerRegexpFunc registers regexp(pattern, value), called by sqlite for
+// value REGEXP pattern, with the syntax of the Go regexp package. Numbers are
+// matched as their text representation, NULL does not match.
+func registerRegexpFunc(conn *sqlite3.SQLiteConn) error {
+	return conn.RegisterFunc("regexp", regexpFunc, true)
+}
+
+func regexpFunc(pattern string, value interface{}) (bool, error) {
+	b := columnBytes(value)
+	if b == nil {
+		return false, nil
+	}
+	re, err := compileRegexp(pattern)
+	if err != nil {
+		return false, err
+	}
+	return re.Match(b), nil
+}
+
+func compileRegexp(pattern string) (*regexp.Regexp, error) {
+	regexpCache.Lock()
+	re, ok := regexpCache.patterns[pattern]
+	regexpCache.Unlock()
+	if ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid REGEXP pattern")
+	}
+	regexpCache.Lock()
+	defer regexpCache.Unlock()
+	if len(regexpCache.patterns) >= maxRegexpCache {
+		regexpCache.patterns = make(map[string]*regexp.Regexp)
+	}
+	regexpCache.patterns[pattern] = re
+	return re, nil
+}
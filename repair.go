@@ -0,0 +1,232 @@
+package sqflite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RepairReport reports what RepairDatabase salvaged from a corrupt database
+type RepairReport struct {
+	CorruptPath string          // path the corrupt file was renamed to
+	Tables      []RepairedTable // tables of the database, in schema order
+	Errors      []string        // schema objects that could not be recreated
+}
+
+// RepairedTable reports the rows of a table copied to the repaired database
+type RepairedTable struct {
+	Name  string
+	Rows  int64
+	Error string // error that stopped the copy of the table, empty when complete
+}
+
+// RepairDatabase salvages the readable rows of the database at dbPath,
+// absolute or relative to the databases folder, which must not be open. Its
+// schema and rows are copied table by table to a fresh file, the rows after a
+// corrupt page being lost, then the corrupt file is renamed aside with a
+// ".corrupt-<time>" suffix and replaced by the fresh one. The virtual tables
+// are recreated empty.
+func (p *SqflitePlugin) RepairDatabase(dbPath string) (*RepairReport, error) {
+	if dbPath == "" || dbPath == MEMORY_DATABASE_PATH {
+		return nil, errors.New("invalid repair database path")
+	}
+	dbPath = p.resolvePath(dbPath)
+	if _, open := p.getDatabaseByPath(dbPath); open {
+		return nil, errors.New("cannot repair " + dbPath + " while it is open")
+	}
+	if _, err := os.Stat(dbPath); err != nil {
+		return nil, err
+	}
+	tmp := dbPath + ".repair"
+	os.Remove(tmp)
+	report, err := p.salvageDatabase(dbPath, tmp)
+	if err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	report.CorruptPath = corruptPath(dbPath)
+	// the journal files belong to the corrupt file
+	os.Remove(dbPath + "-shm")
+	if err = moveDatabaseFiles(dbPath, report.CorruptPath); err != nil {
+		moveDatabaseFiles(report.CorruptPath, dbPath)
+		os.Remove(tmp)
+		return nil, errors.Wrap(err, "failed to move "+filepath.Base(dbPath)+" aside")
+	}
+	if err = os.Rename(tmp, dbPath); err != nil {
+		os.Remove(tmp)
+		if rerr := moveDatabaseFiles(report.CorruptPath, dbPath); rerr != nil {
+			return nil, errors.Wrapf(err, "failed to replace %s, kept as %s (%v)", dbPath, report.CorruptPath, rerr)
+		}
+		return nil, errors.Wrap(err, "failed to replace "+filepath.Base(dbPath))
+	}
+	return report, nil
+}
+
+// corruptPath returns the path the corrupt file dbPath is renamed to, one
+// that no file uses yet
+func corruptPath(dbPath string) string {
+	path := dbPath + ".corrupt-" + time.Now().UTC().Format(backupTimeFormat)
+	for i := 2; ; i++ {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return path
+		}
+		path = fmt.Sprintf("%s.corrupt-%s-%d", dbPath, time.Now().UTC().Format(backupTimeFormat), i)
+	}
+}
+
+// salvageDatabase copies the schema and the readable rows of the database at
+// src to a new database at dest, both opened on connections of the plugin,
+// with its driver, functions and busy timeout
+func (p *SqflitePlugin) salvageDatabase(src, dest string) (*RepairReport, error) {
+	ctx := p.ctx
+	from := p.openEngine(fileDSN(src), engineOptions{busyTimeout: p.busyTimeout, keepWAL: src})
+	defer from.Close()
+	objects, err := schemaObjects(ctx, from)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read the schema of the corrupt database")
+	}
+	to := p.openEngine(pathDSN(dest), engineOptions{busyTimeout: p.busyTimeout})
+	defer to.Close()
+	report := &RepairReport{}
+	for _, kind := range schemaObjectOrder {
+		for _, o := range objects {
+			if o.kind != kind {
+				continue
+			}
+			if _, err = to.ExecContext(ctx, o.sql); err != nil {
+				report.Errors = append(report.Errors, fmt.Sprintf("%s %s: %v", o.kind, o.name, err))
+				continue
+			}
+			if kind != "table" {
+				continue
+			}
+			table := RepairedTable{Name: o.name}
+			if isVirtualTable(o) {
+				table.Error = "virtual table recreated empty"
+			} else if table.Rows, err = copyReadableRows(ctx, from, to, o.name); err != nil {
+				table.Error = err.Error()
+			}
+			report.Tables = append(report.Tables, table)
+		}
+	}
+	var userVersion int64
+	if err = from.QueryRowContext(ctx, "PRAGMA user_version").Scan(&userVersion); err == nil {
+		if _, err = to.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", userVersion)); err != nil {
+			return nil, err
+		}
+	}
+	return report, nil
+}
+
+// copyReadableRows copies the rows of table from the database from to the
+// database to until one cannot be read, and returns the number copied
+func copyReadableRows(ctx context.Context, from, to *sql.DB, table string) (n int64, err error) {
+	rows, err := from.QueryContext(ctx, "SELECT * FROM "+quoteIdentifier(table))
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	tx, err := to.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	insert, err := tx.PrepareContext(ctx, fmt.Sprintf("INSERT INTO %s VALUES (%s)",
+		quoteIdentifier(table), strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", ")))
+	if err != nil {
+		return 0, err
+	}
+	defer insert.Close()
+	values := make([]interface{}, len(cols))
+	dest := make([]interface{}, len(cols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			break
+		}
+		if _, err = insert.ExecContext(ctx, values...); err != nil {
+			break
+		}
+		n++
+	}
+	if err == nil {
+		err = rows.Err()
+	}
+	// the rows read before the error are kept
+	if cerr := tx.Commit(); cerr != nil {
+		return 0, cerr
+	}
+	return n, err
+}
+
+// handleRepairDatabase repairs the closed database file PARAM_PATH with
+// RepairDatabase, and returns {corruptPath, tables: [{name, rows, error}],
+// errors}
+func (p *SqflitePlugin) handleRepairDatabase(arguments interface{}) (reply interface{}, err error) {
+	args, ok := arguments.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("invalid arguments")
+	}
+	dbPath, _ := args[PARAM_PATH].(string)
+	report, err := p.RepairDatabase(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	return report.reply(), nil
+}
+
+func (r *RepairReport) reply() map[interface{}]interface{} {
+	tables := make([]interface{}, len(r.Tables))
+	for i, t := range r.Tables {
+		table := map[interface{}]interface{}{
+			"name":      t.Name,
+			"rows":      t.Rows,
+			PARAM_ERROR: nil,
+		}
+		if t.Error != "" {
+			table[PARAM_ERROR] = t.Error
+		}
+		tables[i] = table
+	}
+	errs := make([]interface{}, len(r.Errors))
+	for i, e := range r.Errors {
+		errs[i] = e
+	}
+	return map[interface{}]interface{}{
+		"corruptPath": r.CorruptPath,
+		"tables":      tables,
+		"errors":      errs,
+	}
+}
+
+// repairCorrupt is the recovery handler set by WithAutoRepair, repairing the
+// corrupt database at dbPath and reporting what was salvaged as a warning
+func (p *SqflitePlugin) repairCorrupt(dbPath string, problems []string) (bool, error) {
+	report, err := p.RepairDatabase(dbPath)
+	if err != nil {
+		return false, err
+	}
+	var salvaged []string
+	for _, t := range report.Tables {
+		s := fmt.Sprintf("%s: %d rows", t.Name, t.Rows)
+		if t.Error != "" {
+			s += " (" + t.Error + ")"
+		}
+		salvaged = append(salvaged, s)
+	}
+	p.warn(WARNING_REPAIRED, dbPath, fmt.Sprintf("%s was corrupt and repaired, the corrupt file is %s, salvaged %s",
+		dbPath, report.CorruptPath, strings.Join(salvaged, ", ")))
+	return true, nil
+}
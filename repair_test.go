@@ -0,0 +1,36 @@
+package sqflite
+
+import (
+	"os"
+	"testing"
+)
+
+func TestRepairDatabasePluginFunctions(t *testing.T) {
+	p := newTestPlugin(t)
+	if err := p.RegisterFunction("is_even", func(x int64) bool { return x%2 == 0 }); err != nil {
+		t.Fatal(err)
+	}
+	id := openTestDatabase(t, p, "repair.db", nil)
+	mustCall(t, p.handleExecute, sqlArgs(id, "CREATE TABLE t (x INTEGER CHECK (is_even(x)))"))
+	mustCall(t, p.handleInsert, sqlArgs(id, "INSERT INTO t VALUES (2), (4)"))
+	mustCall(t, p.handleCloseDatabase, map[interface{}]interface{}{PARAM_ID: id})
+
+	// the rows are inserted in the repaired database through its CHECK
+	report, err := p.RepairDatabase("repair.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Tables) != 1 || report.Tables[0].Rows != 2 || report.Tables[0].Error != "" {
+		t.Errorf("tables = %+v, want t with 2 rows", report.Tables)
+	}
+	if _, err = os.Stat(report.CorruptPath); err != nil {
+		t.Errorf("corrupt file: %v", err)
+	}
+	if _, err = os.Stat(p.resolvePath("repair.db.repair")); !os.IsNotExist(err) {
+		t.Errorf("temporary file left: %v", err)
+	}
+	id = openTestDatabase(t, p, "repair.db", nil)
+	if n := queryValue(t, p, id, "SELECT count(*) FROM t"); n != int64(2) {
+		t.Errorf("count = %v, want 2", n)
+	}
+}
@@ -0,0 +1,76 @@
+package sqflite
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+// Reset forgets the database handles of the Dart side, lost by a hot restart
+// of the app, which keeps the Go side running. The databases are closed, but
+// with keepSingleInstance those opened with singleInstance, which the
+// restarted app gets back when opening them again as on Android: their open
+// transaction is rolled back, and their cursors and watched queries are
+// closed. It returns the number of databases closed.
+func (p *SqflitePlugin) Reset(keepSingleInstance bool) int {
+	p.Lock()
+	databases := make(map[int32]*sql.DB, len(p.databases))
+	for id, db := range p.databases {
+		databases[id] = db
+	}
+	singleInstances := make(map[int32]bool, len(p.singleInstances))
+	for id := range p.singleInstances {
+		singleInstances[id] = true
+	}
+	p.Unlock()
+	closed := 0
+	for id, db := range databases {
+		if keepSingleInstance && singleInstances[id] {
+			p.closeCursors(id)
+			p.unwatchDatabase(id)
+			p.rollbackTransaction(id)
+			continue
+		}
+		if err := p.closeDatabase(id, db); err != nil {
+			p.logger.Errorf("failed to close database %d: %v", id, err)
+		}
+		closed++
+	}
+	if p.verbose() {
+		p.logger.Infof("reset, closed %d databases", closed)
+	}
+	return closed
+}
+
+// rollbackTransaction rolls back the open transaction of databaseId, if any
+func (p *SqflitePlugin) rollbackTransaction(databaseId int32) {
+	p.Lock()
+	_, open := p.transactions[databaseId]
+	reserved := p.reservedConns[databaseId]
+	p.Unlock()
+	if !open {
+		return
+	}
+	if reserved != nil {
+		if _, err := reserved.conn.ExecContext(p.ctx, "ROLLBACK"); err != nil {
+			p.logger.Errorf("failed to roll back the transaction of database %d: %v", databaseId, err)
+		}
+	}
+	p.endTransaction(databaseId)
+}
+
+// handleReset resets the database handles after a hot restart, see Reset,
+// keeping the single instance databases unless PARAM_KEEP_SINGLE_INSTANCE is
+// false, and returns {closed}
+func (p *SqflitePlugin) handleReset(arguments interface{}) (reply interface{}, err error) {
+	args, _ := arguments.(map[interface{}]interface{})
+	keep := true
+	if k, ok := args[PARAM_KEEP_SINGLE_INSTANCE]; ok && k != nil {
+		if keep, ok = k.(bool); !ok {
+			return nil, errors.New("invalid keepSingleInstance")
+		}
+	}
+	return map[interface{}]interface{}{
+		"closed": int32(p.Reset(keep)),
+	}, nil
+}
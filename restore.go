@@ -0,0 +1,260 @@
+package sqflite
+
+import (
+	"database/sql/driver"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// walSegmentExt is the extension of archived WAL segments. A segment is a copy
+// of the -wal file of a database taken right before it was checkpointed, named
+// <name>-<timestamp>.wal like the backups written by ScheduleBackup, the
+// timestamp in backupTimeFormat or, in local time, legacyBackupTimeFormat. The
+// plugin does not archive them: the host must copy the -wal file, with the
+// automatic checkpoints disabled, before checkpointing the database itself.
+const walSegmentExt = ".wal"
+
+// RestoreResult describes a point-in-time restore.
+type RestoreResult struct {
+	Base     string   // base snapshot the restore started from
+	Segments []string // WAL segments replayed on top of the base, oldest first
+}
+
+// RestoreToTime rebuilds the database dbPath as it was at the given time. The
+// latest snapshot of dbPath taken before that time is read from archiveDir,
+// then all archived WAL segments written between the snapshot and the given
+// time are replayed on top of it. dbPath, absolute or relative to the
+// databases folder, must not be open. The segments are archived by the host,
+// see walSegmentExt: restoring to a time after the snapshot fails when none is
+// archived for dbPath. The restored database replaces dbPath only once
+// rebuilt, dbPath being kept on failure.
+func (p *SqflitePlugin) RestoreToTime(dbPath, archiveDir string, at time.Time) (*RestoreResult, error) {
+	if dbPath == "" || dbPath == MEMORY_DATABASE_PATH {
+		return nil, errors.New("invalid restore database path")
+	}
+	dbPath = p.resolvePath(dbPath)
+	if _, open := p.getDatabaseByPath(dbPath); open {
+		return nil, errors.New("cannot restore " + dbPath + " while it is open")
+	}
+
+	base, baseTime, err := findBaseSnapshot(dbPath, archiveDir, at)
+	if err != nil {
+		return nil, err
+	}
+	segments, err := listWALSegments(dbPath, archiveDir, baseTime, at)
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) == 0 && at.After(baseTime) {
+		// restoring the snapshot would silently lose the changes made since
+		archived, err := archivedWALSegments(dbPath, archiveDir)
+		if err != nil {
+			return nil, err
+		}
+		if len(archived) == 0 {
+			return nil, errors.Errorf("no WAL segment of %s archived in %s, the host must archive them as <name>-<timestamp>%s", filepath.Base(dbPath), archiveDir, walSegmentExt)
+		}
+	}
+	tmp := dbPath + ".restore"
+	removeDatabaseFiles(tmp)
+	if err = os.MkdirAll(filepath.Dir(dbPath), 0755); err != nil {
+		return nil, err
+	}
+	if err = copyFile(base, tmp); err != nil {
+		return nil, err
+	}
+	if err = replayWALSegments(tmp, segments); err != nil {
+		removeDatabaseFiles(tmp)
+		return nil, err
+	}
+	if err = replaceDatabaseFiles(tmp, dbPath); err != nil {
+		removeDatabaseFiles(tmp)
+		return nil, err
+	}
+	return &RestoreResult{Base: base, Segments: segments}, nil
+}
+
+// replaceDatabaseFiles replaces the database dbPath, and its journal files, by
+// the database src. The files of dbPath are moved aside first, and moved back
+// if src cannot be renamed to dbPath, so that dbPath is never lost.
+func replaceDatabaseFiles(src, dbPath string) error {
+	aside := dbPath + ".old"
+	removeDatabaseFiles(aside)
+	if err := moveDatabaseFiles(dbPath, aside); err != nil {
+		moveDatabaseFiles(aside, dbPath)
+		return errors.Wrap(err, "failed to move "+filepath.Base(dbPath)+" aside")
+	}
+	if err := os.Rename(src, dbPath); err != nil {
+		if rerr := moveDatabaseFiles(aside, dbPath); rerr != nil {
+			return errors.Wrapf(err, "failed to replace %s, kept as %s (%v)", dbPath, aside, rerr)
+		}
+		return errors.Wrap(err, "failed to replace "+filepath.Base(dbPath))
+	}
+	removeDatabaseFiles(aside)
+	return nil
+}
+
+// moveDatabaseFiles renames a database file and its journal files, the ones
+// that exist
+func moveDatabaseFiles(from, to string) error {
+	for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+		err := os.Rename(from+suffix, to+suffix)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *SqflitePlugin) handleRestoreToTime(arguments interface{}) (reply interface{}, err error) {
+	args, ok := arguments.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("invalid arguments")
+	}
+	dbPath, _ := args[PARAM_PATH].(string)
+	dir, _ := args["archiveDir"].(string)
+	if dir == "" {
+		return nil, errors.New("archiveDir is not set")
+	}
+	var at time.Time
+	switch ms := args["time"].(type) {
+	case int32:
+		at = time.Unix(0, int64(ms)*int64(time.Millisecond))
+	case int64:
+		at = time.Unix(0, ms*int64(time.Millisecond))
+	default:
+		return nil, errors.New("invalid restore time")
+	}
+	result, err := p.RestoreToTime(dbPath, dir, at)
+	if err != nil {
+		return nil, err
+	}
+	var segments []interface{}
+	for _, s := range result.Segments {
+		segments = append(segments, s)
+	}
+	return map[interface{}]interface{}{
+		"base":     result.Base,
+		"segments": segments,
+	}, nil
+}
+
+// findBaseSnapshot returns the latest backup of dbPath in dir taken at or before at
+func findBaseSnapshot(dbPath, dir string, at time.Time) (string, time.Time, error) {
+	backups, err := listBackups(dbPath, dir)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	for i := len(backups) - 1; i >= 0; i-- {
+		stamp, err := archiveTime(dbPath, backups[i])
+		if err == nil && !stamp.After(at) {
+			return backups[i], stamp, nil
+		}
+	}
+	return "", time.Time{}, errors.New("no snapshot found before the requested time")
+}
+
+// archivedWALSegments returns the files of dir named as the WAL segments of dbPath
+func archivedWALSegments(dbPath, dir string) ([]string, error) {
+	base := filepath.Base(dbPath)
+	name := strings.TrimSuffix(base, filepath.Ext(base))
+	return filepath.Glob(filepath.Join(dir, name+"-*"+walSegmentExt))
+}
+
+// listWALSegments returns the segments of dbPath in dir archived in (from, to], oldest first
+func listWALSegments(dbPath, dir string, from, to time.Time) ([]string, error) {
+	matches, err := archivedWALSegments(dbPath, dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []string
+	for _, m := range matches {
+		stamp, err := archiveTime(dbPath, m)
+		if err == nil && stamp.After(from) && !stamp.After(to) {
+			segments = append(segments, m)
+		}
+	}
+	sortByArchiveTime(dbPath, segments)
+	return segments, nil
+}
+
+// archiveTime parses the timestamp of an archived file of dbPath
+func archiveTime(dbPath, file string) (time.Time, error) {
+	base := filepath.Base(dbPath)
+	prefix := strings.TrimSuffix(base, filepath.Ext(base)) + "-"
+	stamp := strings.TrimPrefix(filepath.Base(file), prefix)
+	stamp = strings.TrimSuffix(stamp, filepath.Ext(stamp))
+	return parseBackupTime(stamp)
+}
+
+// replayWALSegments applies the segments in order to the database at dbPath.
+// Each segment is put in place as the -wal file of the database, recovered by
+// sqlite when opening it, and checkpointed into the database file.
+func replayWALSegments(dbPath string, segments []string) error {
+	if len(segments) == 0 {
+		return nil
+	}
+	walDSN := pathDSN(dbPath) + "?_journal_mode=WAL"
+	if err := execOnFile(walDSN, "PRAGMA journal_mode=WAL"); err != nil {
+		return err
+	}
+	for _, segment := range segments {
+		os.Remove(dbPath + "-shm")
+		if err := copyFile(segment, dbPath+"-wal"); err != nil {
+			return err
+		}
+		if err := execOnFile(walDSN, "PRAGMA wal_checkpoint(TRUNCATE)"); err != nil {
+			return errors.Wrap(err, "failed to replay "+filepath.Base(segment))
+		}
+	}
+	return execOnFile(pathDSN(dbPath), "PRAGMA journal_mode=DELETE")
+}
+
+// execOnFile runs a single statement on a private connection opened with dsn
+func execOnFile(dsn, sqlStr string) error {
+	conn, err := (&sqlite3.SQLiteDriver{}).Open(dsn)
+	if err != nil {
+		return err
+	}
+	rows, err := conn.(*sqlite3.SQLiteConn).Query(sqlStr, nil)
+	if err == nil {
+		dest := make([]driver.Value, len(rows.Columns()))
+		for rows.Next(dest) == nil {
+		}
+		err = rows.Close()
+	}
+	if cerr := conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// removeDatabaseFiles removes a database file and its journal files
+func removeDatabaseFiles(dbPath string) {
+	for _, suffix := range []string{"", "-wal", "-shm", "-journal"} {
+		os.Remove(dbPath + suffix)
+	}
+}
+
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
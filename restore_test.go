@@ -0,0 +1,105 @@
+package sqflite
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestDatabase creates the database dbPath with a table t of one row x
+func writeTestDatabase(t *testing.T, dbPath string, x int64) {
+	t.Helper()
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err = db.Exec("CREATE TABLE t (x)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = db.Exec("INSERT INTO t VALUES (?)", x); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readTestDatabase returns the row of the table t of dbPath
+func readTestDatabase(t *testing.T, dbPath string) int64 {
+	t.Helper()
+	db, err := sql.Open("sqlite3", dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var x int64
+	if err = db.QueryRow("SELECT x FROM t").Scan(&x); err != nil {
+		t.Fatalf("read %s: %v", dbPath, err)
+	}
+	return x
+}
+
+func TestRestoreToTime(t *testing.T) {
+	p := newTestPlugin(t)
+	dir := t.TempDir()
+	dbPath := filepath.Join(t.TempDir(), "notes.db")
+	writeTestDatabase(t, dbPath, 1)
+	snapshotTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	writeTestDatabase(t, filepath.Join(dir, backupFileName(dbPath, snapshotTime)), 2)
+
+	// changes made after the snapshot without any WAL segment archived
+	if _, err := p.RestoreToTime(dbPath, dir, time.Now()); err == nil {
+		t.Error("restored after the snapshot without WAL segments")
+	}
+	if x := readTestDatabase(t, dbPath); x != 1 {
+		t.Errorf("database changed by a failed restore, x = %d", x)
+	}
+
+	if _, err := p.RestoreToTime(dbPath, dir, snapshotTime); err != nil {
+		t.Fatal(err)
+	}
+	if x := readTestDatabase(t, dbPath); x != 2 {
+		t.Errorf("restored x = %d, want 2", x)
+	}
+	if _, err := os.Stat(dbPath + ".old"); !os.IsNotExist(err) {
+		t.Errorf("previous database left aside: %v", err)
+	}
+}
+
+func TestReplaceDatabaseFilesKeepsTarget(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "notes.db")
+	writeTestDatabase(t, dbPath, 1)
+	if err := os.WriteFile(dbPath+"-journal", []byte("journal"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := replaceDatabaseFiles(filepath.Join(dir, "missing.db"), dbPath); err == nil {
+		t.Fatal("replaced by a missing database")
+	}
+	if b, err := os.ReadFile(dbPath + "-journal"); err != nil || string(b) != "journal" {
+		t.Errorf("journal not moved back: %q %v", b, err)
+	}
+	if x := readTestDatabase(t, dbPath); x != 1 {
+		t.Errorf("x = %d after a failed replace, want 1", x)
+	}
+}
+
+func TestRestoreOpenDatabase(t *testing.T) {
+	p := newTestPlugin(t)
+	dir := t.TempDir()
+	id := openTestDatabase(t, p, "notes.db", nil)
+	mustCall(t, p.handleExecute, sqlArgs(id, "CREATE TABLE t (x)"))
+	mustCall(t, p.handleInsert, sqlArgs(id, "INSERT INTO t VALUES (1)"))
+	snapshotTime := time.Now().Add(-time.Hour)
+	writeTestDatabase(t, filepath.Join(dir, backupFileName("notes.db", snapshotTime)), 2)
+
+	dbPath := filepath.Join(p.getDatabasesPath(), "notes.db")
+	for _, path := range []string{"notes.db", dbPath, filepath.Join(p.getDatabasesPath(), ".", "notes.db")} {
+		if _, err := p.RestoreToTime(path, dir, snapshotTime); err == nil {
+			t.Errorf("restored %s while open", path)
+		}
+	}
+	if v := queryValue(t, p, id, "SELECT x FROM t"); v != int64(1) {
+		t.Errorf("open database replaced, x = %v", v)
+	}
+}
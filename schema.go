@@ -0,0 +1,181 @@
+package sqflite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// order in which the kinds of schema objects are created
+var schemaObjectOrder = []string{"table", "index", "view", "trigger"}
+
+// suffixes of the shadow tables of the fts3, fts4, fts5 and rtree virtual
+// tables, created with them
+var shadowTableSuffixes = []string{
+	"_content", "_segments", "_segdir", "_docsize", "_stat",
+	"_data", "_idx", "_config",
+	"_node", "_rowid", "_parent",
+}
+
+// schemaObject is an object of the main schema of a database
+type schemaObject struct {
+	kind string
+	name string
+	sql  string
+}
+
+// handleSnapshotSchema returns the schema of the main database, without data:
+// {statements: [CREATE statements], userVersion}. Passed to restoreSchema, it
+// recreates the same empty tables, indexes, views and triggers.
+func (p *SqflitePlugin) handleSnapshotSchema(arguments interface{}) (reply interface{}, err error) {
+	databaseId, db, err := p.getDatabase(arguments)
+	if err != nil {
+		return nil, err
+	}
+	ctx := p.ctx
+	exec := p.executor(databaseId, db, arguments)
+	objects, err := schemaObjects(ctx, exec)
+	if err != nil {
+		return nil, err
+	}
+	var userVersion int64
+	if err = exec.(rowQueryer).QueryRowContext(ctx, "PRAGMA user_version").Scan(&userVersion); err != nil {
+		return nil, err
+	}
+	statements := make([]interface{}, 0, len(objects))
+	for _, kind := range schemaObjectOrder {
+		for _, o := range objects {
+			if o.kind == kind {
+				statements = append(statements, o.sql)
+			}
+		}
+	}
+	return map[interface{}]interface{}{
+		"statements":  statements,
+		"userVersion": userVersion,
+	}, nil
+}
+
+// handleRestoreSchema drops every table, index, view and trigger of the main
+// database and recreates the ones of PARAM_SNAPSHOT, taken by snapshotSchema.
+// All the data is lost. It fails while a transaction or cursor is open.
+func (p *SqflitePlugin) handleRestoreSchema(arguments interface{}) (reply interface{}, err error) {
+	databaseId, db, err := p.getDatabase(arguments)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, ok := arguments.(map[interface{}]interface{})[PARAM_SNAPSHOT].(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("snapshot is not set")
+	}
+	statements, ok := snapshot["statements"].([]interface{})
+	if !ok {
+		return nil, errors.New("snapshot has no statements")
+	}
+	userVersion, _ := toFloat(snapshot["userVersion"])
+
+	ctx := p.ctx
+	// foreign keys cannot be disabled within a transaction
+	conn, err := p.exclusiveConn(databaseId, db, "restore the schema")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var foreignKeys int64
+	if err = conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&foreignKeys); err != nil {
+		return nil, err
+	}
+	if _, err = conn.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+		return nil, err
+	}
+	defer conn.ExecContext(ctx, fmt.Sprintf("PRAGMA foreign_keys = %d", foreignKeys))
+	if cache := p.getQueryCache(databaseId); cache != nil {
+		defer cache.clear()
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	objects, err := schemaObjects(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+	// drop the virtual tables first, with their shadow tables
+	for _, virtual := range []bool{true, false} {
+		for _, o := range objects {
+			if isVirtualTable(o) != virtual {
+				continue
+			}
+			if _, err = tx.ExecContext(ctx, fmt.Sprintf("DROP %s IF EXISTS %s", strings.ToUpper(o.kind), quoteIdentifier(o.name))); err != nil {
+				return nil, errors.Wrap(err, "failed to drop "+o.name)
+			}
+		}
+	}
+	for _, s := range statements {
+		stmt, ok := s.(string)
+		if !ok {
+			return nil, errors.Errorf("invalid statement %v", s)
+		}
+		if _, err = tx.ExecContext(ctx, stmt); err != nil {
+			return nil, errors.Wrap(err, "failed to restore schema")
+		}
+	}
+	if _, err = tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", int64(userVersion))); err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return int64(len(statements)), nil
+}
+
+// schemaObjects returns the objects of the main schema created by a
+// statement, without the internal ones and the shadow tables of the virtual
+// tables
+func schemaObjects(ctx context.Context, q queryer) ([]schemaObject, error) {
+	rows, err := q.QueryContext(ctx, "SELECT type, name, sql FROM main.sqlite_master WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite\\_%' ESCAPE '\\' ORDER BY rowid")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var objects []schemaObject
+	for rows.Next() {
+		var o schemaObject
+		var stmt sql.NullString
+		if err = rows.Scan(&o.kind, &o.name, &stmt); err != nil {
+			return nil, err
+		}
+		o.sql = stmt.String
+		objects = append(objects, o)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	var result []schemaObject
+	for _, o := range objects {
+		shadow := false
+		for _, v := range objects {
+			if !isVirtualTable(v) || o.kind != "table" {
+				continue
+			}
+			for _, suffix := range shadowTableSuffixes {
+				if o.name == v.name+suffix {
+					shadow = true
+				}
+			}
+		}
+		if !shadow {
+			result = append(result, o)
+		}
+	}
+	return result, nil
+}
+
+func isVirtualTable(o schemaObject) bool {
+	return o.kind == "table" && strings.HasPrefix(strings.ToUpper(strings.TrimSpace(o.sql)), "CREATE VIRTUAL TABLE")
+}
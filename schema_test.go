@@ -0,0 +1,44 @@
+package sqflite
+
+import (
+	"testing"
+)
+
+// TestSchemaWithReservedConn checks that snapshotSchema runs on the connection
+// reserved to an open transaction or cursor, and that restoreSchema fails at
+// once instead of waiting for it
+func TestSchemaWithReservedConn(t *testing.T) {
+	for _, kind := range reservedConnKinds {
+		t.Run(kind, func(t *testing.T) {
+			p := newTestPlugin(t)
+			id := openTestDatabase(t, p, "schema.db", nil)
+			mustCall(t, p.handleExecute, sqlArgs(id, "CREATE TABLE t (id INTEGER PRIMARY KEY, x)"))
+			mustCall(t, p.handleInsert, sqlArgs(id, "INSERT INTO t VALUES (1, 'a')"))
+
+			release := reserveTestConn(t, p, id, kind)
+			var snapshot interface{}
+			if err := callWithin(t, "snapshotSchema", func() (err error) {
+				snapshot, err = p.handleSnapshotSchema(map[interface{}]interface{}{PARAM_ID: id})
+				return err
+			}); err != nil {
+				t.Fatal(err)
+			}
+			restore := map[interface{}]interface{}{PARAM_ID: id, PARAM_SNAPSHOT: snapshot}
+			if err := callWithin(t, "restoreSchema", func() error {
+				_, err := p.handleRestoreSchema(restore)
+				return err
+			}); err == nil {
+				t.Error("schema restored while the connection is reserved")
+			}
+			release()
+
+			if v := queryValue(t, p, id, "SELECT count(*) FROM t"); v != int64(1) {
+				t.Errorf("count = %v before restore, want 1", v)
+			}
+			mustCall(t, p.handleRestoreSchema, restore)
+			if v := queryValue(t, p, id, "SELECT count(*) FROM t"); v != int64(0) {
+				t.Errorf("count = %v after restore, want 0", v)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package sqflite
+
+/*
+#include <stdlib.h>
+extern int sqlite3_complete(const char *sql);
+*/
+import "C"
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// ScriptError is the error of the statement of a script that failed, the
+// statements before it being rolled back with the transaction of the script
+type ScriptError struct {
+	Index int    // index of the statement in the script, from 0
+	Line  int    // line of the script on which the statement starts, from 1
+	SQL   string // failed statement
+	Err   error
+}
+
+func (e *ScriptError) Error() string {
+	return fmt.Sprintf("statement %d at line %d failed: %v", e.Index, e.Line, e.Err)
+}
+
+func (e *ScriptError) Cause() error {
+	return e.Err
+}
+
+// scriptStatement is a statement of a script
+type scriptStatement struct {
+	sql  string
+	line int
+}
+
+// ExecuteScript runs the statements of script, separated by semicolons, on db
+// in a single transaction, and returns the number of statements run. When a
+// statement fails, the transaction is rolled back and a *ScriptError tells
+// which one. The script must not begin or end transactions itself.
+func ExecuteScript(ctx context.Context, db *sql.DB, script string) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	n, err := executeScript(ctx, tx, script)
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// handleExecuteScript runs the script PARAM_SCRIPT with ExecuteScript, within
+// the open transaction of the database if any, and returns the number of
+// statements run. The error of a failed statement carries its sql, index and
+// line in its details.
+func (p *SqflitePlugin) handleExecuteScript(arguments interface{}) (reply interface{}, err error) {
+	databaseId, db, err := p.getDatabase(arguments)
+	if err != nil {
+		return nil, err
+	}
+	script, ok := arguments.(map[interface{}]interface{})[PARAM_SCRIPT].(string)
+	if !ok {
+		return nil, errors.New("script is not set")
+	}
+	if cache := p.getQueryCache(databaseId); cache != nil {
+		defer cache.clear()
+	}
+	ctx := p.ctx
+	exec := p.executor(databaseId, db, arguments)
+	var tx *sql.Tx
+	if _, open := p.transactionId(databaseId); !open {
+		if tx, err = exec.(txBeginner).BeginTx(ctx, nil); err != nil {
+			return nil, err
+		}
+		defer tx.Rollback()
+		exec = tx
+	}
+	n, err := executeScript(ctx, exec, script)
+	// a failed script may still have created temp state, outside of a transaction
+	p.trackSessionState(databaseId, script)
+	if err != nil {
+		return nil, err
+	}
+	if tx != nil {
+		if err = tx.Commit(); err != nil {
+			return nil, err
+		}
+	}
+	return int64(n), nil
+}
+
+// executeScript runs the statements of script on exec, stopping at the first
+// failed one
+func executeScript(ctx context.Context, exec executor, script string) (int, error) {
+	statements := splitScript(script)
+	for i, s := range statements {
+		if _, err := exec.ExecContext(ctx, s.sql); err != nil {
+			return i, &ScriptError{Index: i, Line: s.line, SQL: s.sql, Err: err}
+		}
+	}
+	return len(statements), nil
+}
+
+// splitScript splits script into its statements, ending at the semicolons
+// after which sqlite3_complete() finds a complete statement, so that the
+// semicolons of literals, comments and trigger bodies are kept. The
+// comments before a statement are dropped with the statements made only of
+// blanks and comments.
+func splitScript(script string) []scriptStatement {
+	var statements []scriptStatement
+	start, line := 0, 1
+	add := func(end int) {
+		chunk := script[start:end]
+		trimmed := chunk[leadingComments(chunk):]
+		stmtLine := line + strings.Count(chunk[:len(chunk)-len(trimmed)], "\n")
+		line += strings.Count(chunk, "\n")
+		start = end
+		if s := strings.TrimRight(trimmed, "; \t\r\n"); s != "" {
+			statements = append(statements, scriptStatement{sql: trimmed, line: stmtLine})
+		}
+	}
+	for i := 0; i < len(script); i++ {
+		if script[i] == ';' && completeStatement(script[start:i+1]) {
+			add(i + 1)
+		}
+	}
+	if start < len(script) {
+		add(len(script))
+	}
+	return statements
+}
+
+// leadingComments returns the length of the blanks and comments starting sql
+func leadingComments(sql string) int {
+	i := 0
+	for i < len(sql) {
+		switch {
+		case strings.ContainsRune(" \t\r\n", rune(sql[i])):
+			i++
+		case strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end < 0 {
+				return len(sql)
+			}
+			i += end + 1
+		case strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end < 0 {
+				return len(sql)
+			}
+			i += end + 4
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// completeStatement tells if sql ends with a complete statement
+func completeStatement(sql string) bool {
+	cs := C.CString(sql)
+	defer C.free(unsafe.Pointer(cs))
+	return C.sqlite3_complete(cs) != 0
+}
@@ -0,0 +1,82 @@
+package sqflite
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// seed is the content copied to a database file when missing, the file at
+// source or data
+type seed struct {
+	source string // host path or flutter asset
+	data   []byte // embedded database file
+}
+
+func (s *seed) String() string {
+	if s.source == "" {
+		return "embedded database"
+	}
+	return s.source
+}
+
+// getAssetsPath returns the flutter assets folder, set with WithAssetsPath or
+// the flutter_assets folder next to the executable, as go-flutter does
+func (p *SqflitePlugin) getAssetsPath() string {
+	if p.assetsPath != "" {
+		return p.assetsPath
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return "flutter_assets"
+	}
+	return filepath.Join(filepath.Dir(exe), "flutter_assets")
+}
+
+// seedOf returns the seed of dbPath: the asset given to openDatabase, or the
+// seed registered with WithSeedDatabase or WithEmbeddedDatabase, nil if none
+func (p *SqflitePlugin) seedOf(dbPath, asset string) *seed {
+	if asset != "" {
+		return &seed{source: filepath.Join(p.getAssetsPath(), filepath.FromSlash(asset))}
+	}
+	for path, s := range p.seeds {
+		if p.resolvePath(path) != dbPath {
+			continue
+		}
+		if s.source != "" && !filepath.IsAbs(s.source) {
+			return &seed{source: filepath.Join(p.getAssetsPath(), filepath.FromSlash(s.source))}
+		}
+		return s
+	}
+	return nil
+}
+
+// seedDatabase copies the seed s to dbPath if dbPath does not exist, and
+// tells if it did. The copy is renamed into place once complete, so an
+// interrupted copy is not opened as the database.
+func seedDatabase(dbPath string, s *seed) (bool, error) {
+	if _, err := os.Stat(dbPath); err == nil || !os.IsNotExist(err) {
+		return false, err
+	}
+	tmp := dbPath + ".seed"
+	var err error
+	if s.source != "" {
+		if _, err = os.Stat(s.source); err != nil {
+			return false, errors.Wrap(err, "seed database not found")
+		}
+		err = copyFile(s.source, tmp)
+	} else {
+		err = ioutil.WriteFile(tmp, s.data, 0644)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return false, errors.Wrap(err, "failed to copy the seed database")
+	}
+	if err = os.Rename(tmp, dbPath); err != nil {
+		os.Remove(tmp)
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,143 @@
+package sqflite
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// default name of the column marking the soft deleted rows
+const defaultSoftDeleteColumn = "deleted_at"
+
+// handleEnableSoftDelete adds a PARAM_COLUMN column (deleted_at by default) to
+// PARAM_TABLE, holding the time in ms since epoch a row was soft deleted, NULL
+// for the live rows, and a partial index of the deleted rows used to list and
+// purge them. Queries of the live rows filter on "deleted_at IS NULL". Within
+// the open transaction of the database, the changes are committed or rolled
+// back with it.
+func (p *SqflitePlugin) handleEnableSoftDelete(arguments interface{}) (reply interface{}, err error) {
+	databaseId, db, err := p.getDatabase(arguments)
+	if err != nil {
+		return nil, err
+	}
+	table, column, err := getSoftDeleteParams(arguments)
+	if err != nil {
+		return nil, err
+	}
+	ctx := p.ctx
+	exec, tx, err := p.beginExecutor(databaseId, db, arguments)
+	if err != nil {
+		return nil, err
+	}
+	if tx != nil {
+		defer tx.Rollback()
+	}
+	cols, err := tableColumns(ctx, exec, "main", table)
+	if err != nil {
+		return nil, err
+	}
+	added := indexOf(cols, column) < 0
+	if added {
+		if _, err = exec.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s INTEGER", quoteIdentifier(table), quoteIdentifier(column))); err != nil {
+			return nil, err
+		}
+	}
+	index := table + "_" + column
+	if _, err = exec.ExecContext(ctx, fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (%s) WHERE %s IS NOT NULL",
+		quoteIdentifier(index), quoteIdentifier(table), quoteIdentifier(column), quoteIdentifier(column))); err != nil {
+		return nil, errors.Wrap(err, "failed to create soft delete index")
+	}
+	if tx != nil {
+		if err = tx.Commit(); err != nil {
+			return nil, err
+		}
+	}
+	return map[interface{}]interface{}{
+		PARAM_COLUMN: column,
+		"added":      added,
+		"index":      index,
+	}, nil
+}
+
+// handleSoftDelete marks the live rows of PARAM_TABLE matching PARAM_WHERE
+// (with PARAM_SQL_ARGUMENTS) as deleted now, and returns their number.
+func (p *SqflitePlugin) handleSoftDelete(arguments interface{}) (reply interface{}, err error) {
+	return p.updateSoftDeleted(arguments, nowMillisExpr, "IS NULL")
+}
+
+// handleRestoreDeleted marks the soft deleted rows of PARAM_TABLE matching
+// PARAM_WHERE (with PARAM_SQL_ARGUMENTS) as live again, and returns their
+// number. All the deleted rows are restored without PARAM_WHERE.
+func (p *SqflitePlugin) handleRestoreDeleted(arguments interface{}) (reply interface{}, err error) {
+	return p.updateSoftDeleted(arguments, "NULL", "IS NOT NULL")
+}
+
+func (p *SqflitePlugin) updateSoftDeleted(arguments interface{}, value, state string) (reply interface{}, err error) {
+	databaseId, db, err := p.getDatabase(arguments)
+	if err != nil {
+		return nil, err
+	}
+	table, column, err := getSoftDeleteParams(arguments)
+	if err != nil {
+		return nil, err
+	}
+	args := arguments.(map[interface{}]interface{})
+	where, _ := args[PARAM_WHERE].(string)
+	whereArgs, _ := args[PARAM_SQL_ARGUMENTS].([]interface{})
+	if where == "" && value != "NULL" {
+		return nil, errors.New("where is not set")
+	}
+	sqlStr := fmt.Sprintf("UPDATE %s SET %s = %s WHERE %s %s", quoteIdentifier(table), quoteIdentifier(column), value, quoteIdentifier(column), state)
+	if where != "" {
+		sqlStr += " AND (" + where + ")"
+	}
+	p.logSQL(databaseId, sqlStr, whereArgs)
+	p.throttleWrite(databaseId)
+	result, err := p.executor(databaseId, db, arguments).ExecContext(p.ctx, sqlStr, whereArgs...)
+	if err != nil {
+		return nil, err
+	}
+	return result.RowsAffected()
+}
+
+// handlePurgeDeleted deletes for good the rows of PARAM_TABLE soft deleted
+// before PARAM_TIME (ms since epoch), or all of them without PARAM_TIME, and
+// returns their number.
+func (p *SqflitePlugin) handlePurgeDeleted(arguments interface{}) (reply interface{}, err error) {
+	databaseId, db, err := p.getDatabase(arguments)
+	if err != nil {
+		return nil, err
+	}
+	table, column, err := getSoftDeleteParams(arguments)
+	if err != nil {
+		return nil, err
+	}
+	sqlStr := fmt.Sprintf("DELETE FROM %s WHERE %s IS NOT NULL", quoteIdentifier(table), quoteIdentifier(column))
+	var whereArgs []interface{}
+	if before, ok := arguments.(map[interface{}]interface{})[PARAM_TIME]; ok && before != nil {
+		if _, ok = toFloat(before); !ok {
+			return nil, errors.Errorf("invalid time %v", before)
+		}
+		sqlStr += fmt.Sprintf(" AND %s < ?", quoteIdentifier(column))
+		whereArgs = append(whereArgs, before)
+	}
+	p.throttleWrite(databaseId)
+	result, err := p.executor(databaseId, db, arguments).ExecContext(p.ctx, sqlStr, whereArgs...)
+	if err != nil {
+		return nil, err
+	}
+	return result.RowsAffected()
+}
+
+func getSoftDeleteParams(arguments interface{}) (table, column string, err error) {
+	args := arguments.(map[interface{}]interface{})
+	table, _ = args[PARAM_TABLE].(string)
+	if table == "" {
+		return "", "", errors.New("table is not set")
+	}
+	column, _ = args[PARAM_COLUMN].(string)
+	if column == "" {
+		column = defaultSoftDeleteColumn
+	}
+	return table, column, nil
+}
@@ -0,0 +1,49 @@
+package sqflite
+
+import (
+	"testing"
+)
+
+// TestSoftDeleteWithReservedConn checks that the soft delete methods run on
+// the connection reserved to an open transaction or cursor instead of waiting
+func TestSoftDeleteWithReservedConn(t *testing.T) {
+	for _, kind := range reservedConnKinds {
+		t.Run(kind, func(t *testing.T) {
+			p := newTestPlugin(t)
+			id := openTestDatabase(t, p, "softdelete.db", nil)
+			mustCall(t, p.handleExecute, sqlArgs(id, "CREATE TABLE t (id INTEGER PRIMARY KEY, x)"))
+			mustCall(t, p.handleInsert, sqlArgs(id, "INSERT INTO t VALUES (1, 'a'), (2, 'b')"))
+			table := map[interface{}]interface{}{PARAM_ID: id, PARAM_TABLE: "t"}
+
+			release := reserveTestConn(t, p, id, kind)
+			calls := []struct {
+				name      string
+				handler   func(interface{}) (interface{}, error)
+				arguments map[interface{}]interface{}
+			}{
+				{"enableSoftDelete", p.handleEnableSoftDelete, table},
+				{"softDelete", p.handleSoftDelete, map[interface{}]interface{}{PARAM_ID: id, PARAM_TABLE: "t", PARAM_WHERE: "id = 1"}},
+				{"restoreDeleted", p.handleRestoreDeleted, table},
+				{"purgeDeleted", p.handlePurgeDeleted, table},
+			}
+			for _, call := range calls {
+				if err := callWithin(t, call.name, func() error {
+					_, err := call.handler(call.arguments)
+					return err
+				}); err != nil {
+					t.Fatalf("%s: %v", call.name, err)
+				}
+			}
+			release()
+
+			// added with the transaction, rolled back with it
+			want := int64(1)
+			if kind == "transaction" {
+				want = 0
+			}
+			if v := queryValue(t, p, id, "SELECT count(*) FROM pragma_table_info('t') WHERE name = ?", defaultSoftDeleteColumn); v != want {
+				t.Errorf("soft delete columns = %v, want %d", v, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package sqflite
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// CallStats counts the calls of a method made on a database
+type CallStats struct {
+	Count    int64
+	Errors   int64         // calls that failed
+	Duration time.Duration // cumulative time spent in the calls
+}
+
+// DatabaseStats counts the calls made on a database since it was opened
+type DatabaseStats struct {
+	Insert  CallStats
+	Update  CallStats
+	Query   CallStats
+	Execute CallStats
+}
+
+// dbStats are the stats of an open database
+type dbStats struct {
+	sync.Mutex
+	stats DatabaseStats
+}
+
+// calls returns the stats of method, nil for the methods not counted
+func (s *DatabaseStats) calls(method string) *CallStats {
+	switch method {
+	case METHOD_INSERT:
+		return &s.Insert
+	case METHOD_UPDATE:
+		return &s.Update
+	case METHOD_QUERY:
+		return &s.Query
+	case METHOD_EXECUTE:
+		return &s.Execute
+	}
+	return nil
+}
+
+// recordStats wraps the handler of method to count its calls in the stats of
+// the database they are made on, for the methods of DatabaseStats
+func (p *SqflitePlugin) recordStats(method string, handler func(arguments interface{}) (interface{}, error)) func(arguments interface{}) (interface{}, error) {
+	if (&DatabaseStats{}).calls(method) == nil {
+		return handler
+	}
+	return func(arguments interface{}) (interface{}, error) {
+		start := time.Now()
+		reply, err := handler(arguments)
+		elapsed := time.Since(start)
+
+		args, ok := arguments.(map[interface{}]interface{})
+		if !ok {
+			return reply, err
+		}
+		id, ok := args[PARAM_ID].(int32)
+		if !ok {
+			return reply, err
+		}
+		p.Lock()
+		s := p.stats[id]
+		p.Unlock()
+		if s == nil {
+			return reply, err
+		}
+		s.Lock()
+		calls := s.stats.calls(method)
+		calls.Count++
+		if err != nil {
+			calls.Errors++
+		}
+		calls.Duration += elapsed
+		s.Unlock()
+		return reply, err
+	}
+}
+
+// Stats returns the calls made on the open database at dbPath, absolute or
+// relative to the databases folder, since it was opened
+func (p *SqflitePlugin) Stats(dbPath string) (*DatabaseStats, error) {
+	databaseId, open := p.getDatabaseByPath(p.resolvePath(dbPath))
+	if !open {
+		return nil, errors.New(dbPath + " is not open")
+	}
+	p.Lock()
+	s := p.stats[databaseId]
+	p.Unlock()
+	if s == nil {
+		return nil, errors.New(dbPath + " is not open")
+	}
+	s.Lock()
+	defer s.Unlock()
+	stats := s.stats
+	return &stats, nil
+}
+
+// handleGetStats returns the calls made on the database PARAM_ID since it was
+// opened, as {insert, update, query, execute: {count, errors, totalMicros}}
+func (p *SqflitePlugin) handleGetStats(arguments interface{}) (reply interface{}, err error) {
+	databaseId, _, err := p.getDatabase(arguments)
+	if err != nil {
+		return nil, err
+	}
+	p.Lock()
+	s := p.stats[databaseId]
+	p.Unlock()
+	if s == nil {
+		return nil, &codedError{code: ERROR_DATABASE_CLOSED, err: errors.Errorf("database %d is closed", databaseId)}
+	}
+	s.Lock()
+	defer s.Unlock()
+	reply = map[interface{}]interface{}{
+		METHOD_INSERT:  s.stats.Insert.reply(),
+		METHOD_UPDATE:  s.stats.Update.reply(),
+		METHOD_QUERY:   s.stats.Query.reply(),
+		METHOD_EXECUTE: s.stats.Execute.reply(),
+	}
+	return reply, nil
+}
+
+func (c CallStats) reply() map[interface{}]interface{} {
+	return map[interface{}]interface{}{
+		"count":       c.Count,
+		"errors":      c.Errors,
+		"totalMicros": int64(c.Duration / time.Microsecond),
+	}
+}
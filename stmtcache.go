@@ -0,0 +1,215 @@
+package sqflite
+
+import (
+	"container/list"
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// stmtCache keeps the prepared statements of a database, keyed by sql, so
+// that the statements run again skip parsing. The least recently used
+// statement is closed above max statements. Only the statements run on the
+// database handler are cached, not the ones of transactions.
+type stmtCache struct {
+	sync.Mutex
+	max     int
+	entries map[string]*list.Element
+	lru     *list.List
+	hits    int64
+	misses  int64
+}
+
+type stmtEntry struct {
+	sql  string
+	stmt *sql.Stmt
+}
+
+func newStmtCache(max int) *stmtCache {
+	return &stmtCache{
+		max:     max,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// singleStatement tells if sqlStr is a single statement, the only ones a
+// prepared statement runs entirely
+func singleStatement(sqlStr string) bool {
+	return !strings.Contains(strings.TrimRight(sqlStr, "; \t\r\n"), ";")
+}
+
+// prepare returns the cached statement of sqlStr, prepared on db on a miss
+func (c *stmtCache) prepare(ctx context.Context, db *sql.DB, sqlStr string) (*sql.Stmt, error) {
+	c.Lock()
+	if e, ok := c.entries[sqlStr]; ok {
+		c.hits++
+		c.lru.MoveToFront(e)
+		c.Unlock()
+		return e.Value.(*stmtEntry).stmt, nil
+	}
+	c.misses++
+	c.Unlock()
+	stmt, err := db.PrepareContext(ctx, sqlStr)
+	if err != nil {
+		return nil, err
+	}
+	c.Lock()
+	defer c.Unlock()
+	if e, ok := c.entries[sqlStr]; ok {
+		// prepared meanwhile by another call
+		stmt.Close()
+		return e.Value.(*stmtEntry).stmt, nil
+	}
+	c.entries[sqlStr] = c.lru.PushFront(&stmtEntry{sql: sqlStr, stmt: stmt})
+	for c.lru.Len() > c.max {
+		e := c.lru.Back()
+		c.lru.Remove(e)
+		delete(c.entries, e.Value.(*stmtEntry).sql)
+		// a statement in use is closed once done
+		e.Value.(*stmtEntry).stmt.Close()
+	}
+	return stmt, nil
+}
+
+// close closes the cached statements
+func (c *stmtCache) close() {
+	c.Lock()
+	defer c.Unlock()
+	for e := c.lru.Front(); e != nil; e = e.Next() {
+		e.Value.(*stmtEntry).stmt.Close()
+	}
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+}
+
+func (p *SqflitePlugin) getStmtCache(databaseId int32) *stmtCache {
+	p.Lock()
+	defer p.Unlock()
+	return p.stmtCaches[databaseId]
+}
+
+// execCached runs the statement sqlStr on db, with the statement cache of
+// databaseId if any
+func (p *SqflitePlugin) execCached(databaseId int32, db *sql.DB, sqlStr string, args []interface{}) (sql.Result, error) {
+	cache := p.getStmtCache(databaseId)
+	if cache == nil || !singleStatement(sqlStr) {
+		return db.ExecContext(p.ctx, sqlStr, args...)
+	}
+	stmt, err := cache.prepare(p.ctx, db, sqlStr)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.ExecContext(p.ctx, args...)
+}
+
+// queryCached runs the query sqlStr on db, with the statement cache of
+// databaseId if any
+func (p *SqflitePlugin) queryCached(databaseId int32, db *sql.DB, sqlStr string, args []interface{}) (*sql.Rows, error) {
+	cache := p.getStmtCache(databaseId)
+	if cache == nil || !singleStatement(sqlStr) {
+		return db.QueryContext(p.ctx, sqlStr, args...)
+	}
+	stmt, err := cache.prepare(p.ctx, db, sqlStr)
+	if err != nil {
+		return nil, err
+	}
+	return stmt.QueryContext(p.ctx, args...)
+}
+
+// handleGetStatementCacheStats reports the size and hit counts of the
+// statement cache of the database PARAM_ID
+func (p *SqflitePlugin) handleGetStatementCacheStats(arguments interface{}) (reply interface{}, err error) {
+	databaseId, _, err := p.getDatabase(arguments)
+	if err != nil {
+		return nil, err
+	}
+	cache := p.getStmtCache(databaseId)
+	if cache == nil {
+		return nil, errors.New("the statement cache of the database is disabled")
+	}
+	cache.Lock()
+	defer cache.Unlock()
+	var hitRate float64
+	if total := cache.hits + cache.misses; total > 0 {
+		hitRate = float64(cache.hits) / float64(total)
+	}
+	return map[interface{}]interface{}{
+		"size":     int64(cache.lru.Len()),
+		"capacity": int64(cache.max),
+		"hits":     cache.hits,
+		"misses":   cache.misses,
+		"hitRate":  hitRate,
+	}, nil
+}
+
+// preparer prepares the statements of a batch, on a transaction or on the
+// reserved connection of a database
+type preparer interface {
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+// stmtExecutor runs the statement it was prepared for, whatever the query
+// given
+type stmtExecutor struct {
+	stmt *sql.Stmt
+}
+
+func (e stmtExecutor) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return e.stmt.ExecContext(ctx, args...)
+}
+
+func (e stmtExecutor) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return e.stmt.QueryContext(ctx, args...)
+}
+
+// batchStatements prepares once the statements run several times by a
+// batch, such as the inserts of an import, instead of parsing them for each
+// operation
+type batchStatements struct {
+	ctx   context.Context
+	exec  executor
+	runs  map[string]int
+	stmts map[string]*sql.Stmt
+}
+
+func newBatchStatements(ctx context.Context, exec executor, operations []interface{}) *batchStatements {
+	b := &batchStatements{ctx: ctx, exec: exec, runs: make(map[string]int), stmts: make(map[string]*sql.Stmt)}
+	for _, operation := range operations {
+		operate, _ := operation.(map[interface{}]interface{})
+		if sqlStr, ok := operate[PARAM_SQL].(string); ok {
+			b.runs[sqlStr]++
+		}
+	}
+	return b
+}
+
+// executor returns the executor running sqlStr, prepared if it runs several
+// times
+func (b *batchStatements) executor(sqlStr string) (executor, error) {
+	if b.runs[sqlStr] < 2 || !singleStatement(sqlStr) {
+		return b.exec, nil
+	}
+	if stmt, ok := b.stmts[sqlStr]; ok {
+		return stmtExecutor{stmt}, nil
+	}
+	p, ok := b.exec.(preparer)
+	if !ok {
+		return b.exec, nil
+	}
+	stmt, err := p.PrepareContext(b.ctx, sqlStr)
+	if err != nil {
+		return nil, err
+	}
+	b.stmts[sqlStr] = stmt
+	return stmtExecutor{stmt}, nil
+}
+
+func (b *batchStatements) close() {
+	for _, stmt := range b.stmts {
+		stmt.Close()
+	}
+}
@@ -0,0 +1,495 @@
+package sqflite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// tables of the rows changed since the last push, and of the pull cursor
+const (
+	syncLogTable   = "_sync_log"
+	syncStateTable = "_sync_state"
+)
+
+// RowChange is a row changed locally, sent by SyncAdapter.Push, or on the
+// server, returned by SyncAdapter.Pull
+type RowChange struct {
+	Table   string
+	Key     map[string]interface{} // values of the primary key columns, or of rowid without one
+	Values  map[string]interface{} // values of the columns, nil when deleted
+	Deleted bool
+}
+
+// SyncAdapter exchanges the row changes of a database with a server. The
+// plugin pushes the local changes first, then applies the pulled ones, which
+// win over the local rows.
+type SyncAdapter interface {
+	// Push sends the rows changed locally since the last successful push,
+	// once each with its current values, in the order of their last change.
+	// They are pushed again by the next sync when it fails.
+	Push(ctx context.Context, changes []RowChange) error
+	// Pull returns the rows changed on the server since cursor, empty for the
+	// first pull, and the cursor of the next pull
+	Pull(ctx context.Context, cursor string) (changes []RowChange, next string, err error)
+}
+
+// SyncConfig describes the sync of a database with a server
+type SyncConfig struct {
+	Adapter  SyncAdapter
+	Tables   []string      // tables whose changes are tracked and pushed
+	Interval time.Duration // time between two syncs, 0 to sync only with the syncDatabase method
+}
+
+// SyncResult reports the rows exchanged by a sync
+type SyncResult struct {
+	Pushed int
+	Pulled int
+}
+
+type syncWorker struct {
+	sync.Mutex // serializes the syncs of the database
+	path       string
+	config     SyncConfig
+	stop       chan struct{}
+}
+
+// ScheduleSync syncs the database at dbPath, absolute or relative to the
+// databases folder, with config.Adapter every config.Interval while it is
+// open. The changes of config.Tables are tracked by triggers created when the
+// plugin opens it writable and before each sync, their keys must be INTEGER
+// or TEXT. A previous sync of the same path is replaced.
+func (p *SqflitePlugin) ScheduleSync(dbPath string, config SyncConfig) error {
+	if dbPath == "" || dbPath == MEMORY_DATABASE_PATH {
+		return errors.New("invalid sync database path")
+	}
+	if config.Adapter == nil {
+		return errors.New("sync adapter must be set")
+	}
+	if len(config.Tables) == 0 {
+		return errors.New("sync tables must be set")
+	}
+	if config.Interval < 0 {
+		return errors.New("sync interval must be positive")
+	}
+	w := &syncWorker{
+		path:   dbPath,
+		config: config,
+		stop:   make(chan struct{}),
+	}
+	p.Lock()
+	if old, ok := p.syncs[dbPath]; ok {
+		close(old.stop)
+	}
+	p.syncs[dbPath] = w
+	p.Unlock()
+	if config.Interval > 0 {
+		go p.runSyncWorker(w)
+	}
+	return nil
+}
+
+// CancelSync stops the sync of dbPath, if any. Its changes are still tracked
+// until the triggers are dropped.
+func (p *SqflitePlugin) CancelSync(dbPath string) {
+	p.Lock()
+	defer p.Unlock()
+	if w, ok := p.syncs[dbPath]; ok {
+		close(w.stop)
+		delete(p.syncs, dbPath)
+	}
+}
+
+// syncWorkerOf returns the sync of the database opened on dbPath, nil if none
+func (p *SqflitePlugin) syncWorkerOf(dbPath string) *syncWorker {
+	p.Lock()
+	syncs := make([]*syncWorker, 0, len(p.syncs))
+	for _, w := range p.syncs {
+		syncs = append(syncs, w)
+	}
+	p.Unlock()
+	for _, w := range syncs {
+		if p.resolvePath(w.path) == dbPath {
+			return w
+		}
+	}
+	return nil
+}
+
+func (p *SqflitePlugin) runSyncWorker(w *syncWorker) {
+	ticker := time.NewTicker(w.config.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		databaseId, ok := p.getDatabaseByPath(p.resolvePath(w.path))
+		if !ok {
+			continue
+		}
+		p.Lock()
+		db := p.databases[databaseId]
+		p.Unlock()
+		if db == nil {
+			continue
+		}
+		result, err := w.run(p.ctx, db)
+		if err != nil {
+			p.logger.Errorf("sync of %s failed: %v", w.path, err)
+		} else if p.verbose() {
+			p.logger.Infof("sync of %s done, pushed=%d pulled=%d", w.path, result.Pushed, result.Pulled)
+		}
+	}
+}
+
+// handleSyncDatabase syncs the database PARAM_ID with the adapter scheduled
+// for its path, and returns {pushed, pulled}. It fails while a transaction or
+// cursor is open.
+func (p *SqflitePlugin) handleSyncDatabase(arguments interface{}) (reply interface{}, err error) {
+	databaseId, db, err := p.getDatabase(arguments)
+	if err != nil {
+		return nil, err
+	}
+	p.Lock()
+	dbPath := p.databasePaths[databaseId]
+	p.Unlock()
+	w := p.syncWorkerOf(dbPath)
+	if w == nil {
+		return nil, errors.New("no sync scheduled for " + dbPath)
+	}
+	// the pushed changes must be committed, and the pulled ones cannot be
+	// applied within a transaction
+	p.Lock()
+	_, reserved := p.reservedConns[databaseId]
+	p.Unlock()
+	if reserved {
+		return nil, errors.New("cannot sync " + dbPath + " while a transaction or cursor is open")
+	}
+	result, err := w.run(p.ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	return map[interface{}]interface{}{
+		"pushed": int64(result.Pushed),
+		"pulled": int64(result.Pulled),
+	}, nil
+}
+
+// setupSync creates the change tracking of the sync scheduled for the
+// database opened on dbPath, if any
+func (p *SqflitePlugin) setupSync(db *sql.DB, dbPath string) error {
+	w := p.syncWorkerOf(dbPath)
+	if w == nil {
+		return nil
+	}
+	return errors.Wrap(trackChanges(p.ctx, db, w.config.Tables), "failed to set up the sync of "+dbPath)
+}
+
+// trackChanges creates the sync tables, and the triggers logging the keys of
+// the rows of tables changed locally. The triggers only use core SQL, so the
+// tables can be written by other tools, and recreating them replaces the ones
+// of the earlier versions, which called the json_row() function of the
+// plugin. The rows changed while applying the pulled changes are not logged. The existing rows of a table tracked for the
+// first time are logged, the tables not created yet are skipped.
+func trackChanges(ctx context.Context, db *sql.DB, tables []string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, stmt := range []string{
+		"CREATE TABLE IF NOT EXISTS " + syncLogTable + " (id INTEGER PRIMARY KEY, table_name TEXT NOT NULL, row_key TEXT NOT NULL)",
+		"CREATE TABLE IF NOT EXISTS " + syncStateTable + " (id INTEGER PRIMARY KEY CHECK (id = 0), cursor TEXT NOT NULL, applying INTEGER NOT NULL)",
+		"INSERT OR IGNORE INTO " + syncStateTable + " (id, cursor, applying) VALUES (0, '', 0)",
+	} {
+		if _, err = tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	for _, table := range tables {
+		var exists, tracked bool
+		if err = tx.QueryRowContext(ctx, "SELECT count(*) > 0 FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		if err = tx.QueryRowContext(ctx, "SELECT count(*) > 0 FROM sqlite_master WHERE type = 'trigger' AND name = ?", table+"_sync_insert").Scan(&tracked); err != nil {
+			return err
+		}
+		key, err := syncKeyColumns(ctx, tx, table)
+		if err != nil {
+			return err
+		}
+		entry := func(row string) string {
+			return fmt.Sprintf("INSERT INTO %s (table_name, row_key) VALUES (%s, %s); %s", syncLogTable, quoteString(table), jsonObjectExpr(key, row),
+				escapeControlChars(syncLogTable, []string{"row_key"}, "id = last_insert_rowid()"))
+		}
+		on := quoteIdentifier(table) + " WHEN (SELECT applying FROM " + syncStateTable + ") = 0"
+		triggers := map[string]string{
+			"insert": "AFTER INSERT ON " + on + " BEGIN " + entry("NEW.") + " END",
+			"update": "AFTER UPDATE ON " + on + " BEGIN " + entry("OLD.") + " " + entry("NEW.") + " END",
+			"delete": "AFTER DELETE ON " + on + " BEGIN " + entry("OLD.") + " END",
+		}
+		for event, body := range triggers {
+			name := quoteIdentifier(table + "_sync_" + event)
+			if _, err = tx.ExecContext(ctx, "DROP TRIGGER IF EXISTS "+name); err != nil {
+				return err
+			}
+			if _, err = tx.ExecContext(ctx, "CREATE TRIGGER "+name+" "+body); err != nil {
+				return errors.Wrap(err, "failed to create sync trigger")
+			}
+		}
+		if !tracked {
+			if _, err = tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (table_name, row_key) SELECT %s, %s FROM %s",
+				syncLogTable, quoteString(table), jsonObjectExpr(key, quoteIdentifier(table)+"."), quoteIdentifier(table))); err != nil {
+				return err
+			}
+			if _, err = tx.ExecContext(ctx, escapeControlChars(syncLogTable, []string{"row_key"}, "table_name = "+quoteString(table))); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// syncKeyColumns returns the primary key columns of table, or rowid
+func syncKeyColumns(ctx context.Context, q queryer, table string) ([]string, error) {
+	key, err := primaryKeyColumns(ctx, q, table)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) == 0 {
+		key = []string{"rowid"}
+	}
+	return key, nil
+}
+
+// run pushes the local changes of db, then applies the pulled ones. The
+// adapter is called without holding the connection of the database.
+func (w *syncWorker) run(ctx context.Context, db *sql.DB) (*SyncResult, error) {
+	w.Lock()
+	defer w.Unlock()
+	if err := trackChanges(ctx, db, w.config.Tables); err != nil {
+		return nil, err
+	}
+	changes, lastId, err := localChanges(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	if len(changes) > 0 {
+		if err = w.config.Adapter.Push(ctx, changes); err != nil {
+			return nil, errors.Wrap(err, "push failed")
+		}
+		if _, err = db.ExecContext(ctx, "DELETE FROM "+syncLogTable+" WHERE id <= ?", lastId); err != nil {
+			return nil, err
+		}
+	}
+	var cursor string
+	if err = db.QueryRowContext(ctx, "SELECT cursor FROM "+syncStateTable).Scan(&cursor); err != nil {
+		return nil, err
+	}
+	pulled, next, err := w.config.Adapter.Pull(ctx, cursor)
+	if err != nil {
+		return nil, errors.Wrap(err, "pull failed")
+	}
+	if err = w.applyChanges(ctx, db, pulled, next); err != nil {
+		return nil, err
+	}
+	return &SyncResult{Pushed: len(changes), Pulled: len(pulled)}, nil
+}
+
+// localChanges returns the rows changed since the last push with their
+// current values, and the id of the last log entry they cover
+func localChanges(ctx context.Context, db *sql.DB) (changes []RowChange, lastId int64, err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+	var maxId sql.NullInt64
+	if err = tx.QueryRowContext(ctx, "SELECT max(id) FROM "+syncLogTable).Scan(&maxId); err != nil || !maxId.Valid {
+		return nil, 0, err
+	}
+	rows, err := tx.QueryContext(ctx, "SELECT table_name, row_key FROM "+syncLogTable+
+		" WHERE id <= ? GROUP BY table_name, row_key ORDER BY max(id)", maxId.Int64)
+	if err != nil {
+		return nil, 0, err
+	}
+	var entries [][2]string
+	for rows.Next() {
+		var entry [2]string
+		if err = rows.Scan(&entry[0], &entry[1]); err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
+		entries = append(entries, entry)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	for _, entry := range entries {
+		table, rowKey := entry[0], entry[1]
+		key, err := decodeSyncKey(rowKey)
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "invalid sync key %s of %s", rowKey, table)
+		}
+		cols, err := tableColumns(ctx, tx, "main", table)
+		if err != nil {
+			return nil, 0, err
+		}
+		where, args := syncKeyWhere(key)
+		rows, err := tx.QueryContext(ctx, fmt.Sprintf("SELECT %s FROM %s WHERE %s",
+			quoteColumns(cols), quoteIdentifier(table), where), args...)
+		if err != nil {
+			return nil, 0, err
+		}
+		// read as by a query, the TEXT values as strings
+		values, _, err := readRows(rows, rowValueTypes(rows), len(cols), 1)
+		rows.Close()
+		if err != nil {
+			return nil, 0, err
+		}
+		change := RowChange{Table: table, Key: key, Deleted: len(values) == 0}
+		if len(values) > 0 {
+			change.Values = make(map[string]interface{}, len(cols))
+			for i, v := range values[0].([]interface{}) {
+				change.Values[cols[i]] = v
+			}
+		}
+		changes = append(changes, change)
+	}
+	return changes, maxId.Int64, nil
+}
+
+// applyChanges writes the pulled changes to db and saves the cursor of the
+// next pull, in a single transaction
+func (w *syncWorker) applyChanges(ctx context.Context, db *sql.DB, changes []RowChange, cursor string) error {
+	tables := make(map[string]bool, len(w.config.Tables))
+	for _, table := range w.config.Tables {
+		tables[table] = true
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err = tx.ExecContext(ctx, "UPDATE "+syncStateTable+" SET applying = 1"); err != nil {
+		return err
+	}
+	for _, c := range changes {
+		if !tables[c.Table] {
+			return errors.Errorf("pulled a change of %s, which is not synced", c.Table)
+		}
+		if len(c.Key) == 0 {
+			return errors.Errorf("pulled a change of %s without key", c.Table)
+		}
+		if err = applyChange(ctx, tx, c); err != nil {
+			return errors.Wrapf(err, "failed to apply the pulled change of %s", c.Table)
+		}
+	}
+	if _, err = tx.ExecContext(ctx, "UPDATE "+syncStateTable+" SET applying = 0, cursor = ?", cursor); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// applyChange deletes the row of c, or updates it with its values, inserting
+// it when missing
+func applyChange(ctx context.Context, tx *sql.Tx, c RowChange) error {
+	where, whereArgs := syncKeyWhere(c.Key)
+	table := quoteIdentifier(c.Table)
+	if c.Deleted {
+		_, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE "+where, whereArgs...)
+		return err
+	}
+	values := make(map[string]interface{}, len(c.Values)+len(c.Key))
+	for col, v := range c.Values {
+		values[col] = v
+	}
+	for col, v := range c.Key {
+		values[col] = v
+	}
+	cols := sortedKeys(values)
+	var sets []string
+	var args []interface{}
+	for _, col := range cols {
+		if _, isKey := c.Key[col]; !isKey {
+			sets = append(sets, quoteIdentifier(col)+" = ?")
+			args = append(args, values[col])
+		}
+	}
+	if len(sets) > 0 {
+		result, err := tx.ExecContext(ctx, "UPDATE "+table+" SET "+strings.Join(sets, ", ")+" WHERE "+where, append(args, whereArgs...)...)
+		if err != nil {
+			return err
+		}
+		if n, err := result.RowsAffected(); err != nil || n > 0 {
+			return err
+		}
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", ")
+	_, err := tx.ExecContext(ctx, fmt.Sprintf("INSERT OR IGNORE INTO %s (%s) VALUES (%s)", table, quoteColumns(cols), placeholders), rowValues(values, cols)...)
+	return err
+}
+
+// decodeSyncKey decodes the JSON key of a log entry, integral numbers as
+// int64
+func decodeSyncKey(rowKey string) (map[string]interface{}, error) {
+	d := json.NewDecoder(strings.NewReader(rowKey))
+	d.UseNumber()
+	var key map[string]interface{}
+	if err := d.Decode(&key); err != nil {
+		return nil, err
+	}
+	for col, v := range key {
+		if n, ok := v.(json.Number); ok {
+			if i, err := n.Int64(); err == nil {
+				key[col] = i
+			} else if f, err := n.Float64(); err == nil {
+				key[col] = f
+			}
+		}
+	}
+	return key, nil
+}
+
+// syncKeyWhere returns the condition selecting the row of key
+func syncKeyWhere(key map[string]interface{}) (string, []interface{}) {
+	var where []string
+	var args []interface{}
+	for _, col := range sortedKeys(key) {
+		where = append(where, quoteIdentifier(col)+" = ?")
+		args = append(args, key[col])
+	}
+	return strings.Join(where, " AND "), args
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func quoteColumns(cols []string) string {
+	quoted := make([]string, len(cols))
+	for i, c := range cols {
+		quoted[i] = quoteIdentifier(c)
+	}
+	return strings.Join(quoted, ", ")
+}
@@ -0,0 +1,86 @@
+package sqflite
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// testSyncAdapter keeps the pushed changes and pulls none
+type testSyncAdapter struct {
+	pushed []RowChange
+}
+
+func (a *testSyncAdapter) Push(ctx context.Context, changes []RowChange) error {
+	a.pushed = append(a.pushed, changes...)
+	return nil
+}
+
+func (a *testSyncAdapter) Pull(ctx context.Context, cursor string) ([]RowChange, string, error) {
+	return nil, cursor, nil
+}
+
+// newSyncTestDatabase opens sync.db, synced with the returned adapter, with a
+// table note keyed by text
+func newSyncTestDatabase(t *testing.T, p *SqflitePlugin) (int32, *testSyncAdapter) {
+	t.Helper()
+	adapter := &testSyncAdapter{}
+	if err := p.ScheduleSync("sync.db", SyncConfig{Adapter: adapter, Tables: []string{"note"}}); err != nil {
+		t.Fatal(err)
+	}
+	id := openTestDatabase(t, p, "sync.db", nil)
+	mustCall(t, p.handleExecute, sqlArgs(id, "CREATE TABLE note (id TEXT PRIMARY KEY, body)"))
+	mustCall(t, p.handleInsert, sqlArgs(id, "INSERT INTO note VALUES ('a\"\\\tb', 'first')"))
+	return id, adapter
+}
+
+// TestSyncTriggersCoreSQL checks that the synced tables can be written by a
+// connection without the functions of the plugin, and that the keys logged by
+// the triggers decode to the keys of the rows
+func TestSyncTriggersCoreSQL(t *testing.T) {
+	p := newTestPlugin(t)
+	id, adapter := newSyncTestDatabase(t, p)
+	mustCall(t, p.handleSyncDatabase, map[interface{}]interface{}{PARAM_ID: id})
+
+	other, err := sql.Open("sqlite3", filepath.Join(p.getDatabasesPath(), "sync.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if _, err = other.Exec("INSERT INTO note VALUES (?, 'second')", "line\nbreak\x01"); err != nil {
+		t.Fatalf("write from another tool: %v", err)
+	}
+	if _, err = other.Exec("UPDATE note SET body = 'updated' WHERE id = ?", "a\"\\\tb"); err != nil {
+		t.Fatalf("write from another tool: %v", err)
+	}
+	other.Close()
+
+	adapter.pushed = nil
+	mustCall(t, p.handleSyncDatabase, map[interface{}]interface{}{PARAM_ID: id})
+	var keys []interface{}
+	for _, c := range adapter.pushed {
+		keys = append(keys, c.Key["id"])
+	}
+	if want := []interface{}{"line\nbreak\x01", "a\"\\\tb"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("pushed keys = %q, want %q", keys, want)
+	}
+}
+
+func TestSyncDatabaseWithReservedConn(t *testing.T) {
+	for _, kind := range reservedConnKinds {
+		t.Run(kind, func(t *testing.T) {
+			p := newTestPlugin(t)
+			id, _ := newSyncTestDatabase(t, p)
+			release := reserveTestConn(t, p, id, kind)
+			defer release()
+			if err := callWithin(t, "syncDatabase", func() error {
+				_, err := p.handleSyncDatabase(map[interface{}]interface{}{PARAM_ID: id})
+				return err
+			}); err == nil {
+				t.Error("synced while the connection is reserved")
+			}
+		})
+	}
+}
@@ -0,0 +1,167 @@
+package sqflite
+
+import (
+	crand "crypto/rand"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// TestMode makes the content written by the plugin reproducible, for golden
+// file tests of databases. While enabled, the datetime_local(), now_ms(),
+// uuid4(), random() and randomblob() SQL functions read the injected clock and
+// a random source seeded with Seed, and the method calls are run one at a
+// time in their order of arrival.
+type TestMode struct {
+	Clock func() time.Time // current time, a clock starting at the epoch and advancing 1ms per reading if nil
+	Seed  int64
+}
+
+// testSource is the clock and random source of the test mode
+type testSource struct {
+	sync.Mutex
+	clock func() time.Time
+	rand  *rand.Rand
+}
+
+// SetTestMode enables the test mode, or disables it for a nil mode. The
+// random() and randomblob() builtins are only replaced on the connections
+// opened after, so it should be set before opening the databases.
+func (p *SqflitePlugin) SetTestMode(mode *TestMode) {
+	var source *testSource
+	if mode != nil {
+		source = &testSource{clock: mode.Clock, rand: rand.New(rand.NewSource(mode.Seed))}
+		if source.clock == nil {
+			source.clock = steppingClock(time.Unix(0, 0), time.Millisecond)
+		}
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.testSource = source
+}
+
+// steppingClock returns a clock starting at start and advancing by step at
+// each reading
+func steppingClock(start time.Time, step time.Duration) func() time.Time {
+	var lock sync.Mutex
+	t := start
+	return func() time.Time {
+		lock.Lock()
+		defer lock.Unlock()
+		now := t
+		t = t.Add(step)
+		return now
+	}
+}
+
+// setTestModeParam enables the test mode from the debugMode arguments: false,
+// or {time: start in ms since epoch, seed}
+func (p *SqflitePlugin) setTestModeParam(arg interface{}) error {
+	switch v := arg.(type) {
+	case bool:
+		if !v {
+			p.SetTestMode(nil)
+			return nil
+		}
+		p.SetTestMode(&TestMode{})
+	case map[interface{}]interface{}:
+		mode := &TestMode{}
+		if start, ok := toFloat(v[PARAM_TIME]); ok {
+			mode.Clock = steppingClock(time.Unix(0, int64(start)*int64(time.Millisecond)), time.Millisecond)
+		}
+		if seed, ok := toFloat(v["seed"]); ok {
+			mode.Seed = int64(seed)
+		}
+		p.SetTestMode(mode)
+	default:
+		return errors.Errorf("invalid test mode %v", arg)
+	}
+	return nil
+}
+
+func (p *SqflitePlugin) getTestSource() *testSource {
+	p.Lock()
+	defer p.Unlock()
+	return p.testSource
+}
+
+// now returns the current time, from the clock of the test mode if enabled
+func (p *SqflitePlugin) now() time.Time {
+	if source := p.getTestSource(); source != nil {
+		return source.clock()
+	}
+	return time.Now()
+}
+
+// randomBytes returns n random bytes, from the seeded source of the test mode
+// if enabled
+func (p *SqflitePlugin) randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if source := p.getTestSource(); source != nil {
+		source.Lock()
+		defer source.Unlock()
+		source.rand.Read(b)
+		return b, nil
+	}
+	_, err := crand.Read(b)
+	return b, err
+}
+
+// registerClockFuncs registers the SQL functions reading the clock and the
+// random source of the plugin, and overrides the random() and randomblob()
+// builtins on the connections opened in test mode:
+//
+//	now_ms()    current time in ms since epoch
+//	uuid4()     random UUID, as text
+func (p *SqflitePlugin) registerClockFuncs(conn *sqlite3.SQLiteConn) error {
+	if err := conn.RegisterFunc("now_ms", p.nowMillisFunc, false); err != nil {
+		return err
+	}
+	if err := conn.RegisterFunc("uuid4", p.uuid4Func, false); err != nil {
+		return err
+	}
+	if p.getTestSource() == nil {
+		return nil
+	}
+	if err := conn.RegisterFunc("random", p.randomFunc, false); err != nil {
+		return err
+	}
+	return conn.RegisterFunc("randomblob", p.randomBlobFunc, false)
+}
+
+func (p *SqflitePlugin) nowMillisFunc() int64 {
+	return p.now().UnixNano() / int64(time.Millisecond)
+}
+
+func (p *SqflitePlugin) uuid4Func() (string, error) {
+	b, err := p.randomBytes(16)
+	if err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40 // version 4
+	b[8] = b[8]&0x3f | 0x80 // variant 10
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+func (p *SqflitePlugin) randomFunc() (int64, error) {
+	b, err := p.randomBytes(8)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	for _, c := range b {
+		n = n<<8 | int64(c)
+	}
+	return n, nil
+}
+
+func (p *SqflitePlugin) randomBlobFunc(n int64) ([]byte, error) {
+	if n < 1 {
+		n = 1
+	}
+	return p.randomBytes(int(n))
+}
@@ -0,0 +1,71 @@
+package sqflite
+
+import "time"
+
+// StatementTrace describes a statement run for the Dart side, by a method
+// call or by an operation of a batch
+type StatementTrace struct {
+	DatabaseId int32
+	Path       string
+	Method     string // insert, update, query or execute
+	SQL        string
+	Args       []interface{}
+	Duration   time.Duration
+	Err        error // nil when the statement succeeded
+}
+
+// traced tells if the statements of method are traced
+func traced(method string) bool {
+	switch method {
+	case METHOD_INSERT, METHOD_UPDATE, METHOD_QUERY, METHOD_EXECUTE:
+		return true
+	}
+	return false
+}
+
+// traceCall wraps the handler of method to pass the statement it runs to the
+// tracer set by WithStatementTracer
+func (p *SqflitePlugin) traceCall(method string, handler func(arguments interface{}) (interface{}, error)) func(arguments interface{}) (interface{}, error) {
+	if p.statementTracer == nil || !traced(method) {
+		return handler
+	}
+	return func(arguments interface{}) (interface{}, error) {
+		start := time.Now()
+		reply, err := handler(arguments)
+		elapsed := time.Since(start)
+
+		args, ok := arguments.(map[interface{}]interface{})
+		if !ok {
+			return reply, err
+		}
+		id, ok := args[PARAM_ID].(int32)
+		if !ok {
+			return reply, err
+		}
+		sqlStr, sqlArgs, argsErr := p.getSqlCommand(arguments)
+		if argsErr != nil {
+			return reply, err
+		}
+		p.traceStatement(id, method, sqlStr, sqlArgs, elapsed, err)
+		return reply, err
+	}
+}
+
+// traceStatement passes a statement run on databaseId to the tracer, if any
+func (p *SqflitePlugin) traceStatement(databaseId int32, method, sqlStr string, args []interface{}, elapsed time.Duration, err error) {
+	if p.statementTracer == nil {
+		return
+	}
+	p.Lock()
+	dbPath := p.databasePaths[databaseId]
+	p.Unlock()
+	p.statementTracer(StatementTrace{
+		DatabaseId: databaseId,
+		Path:       dbPath,
+		Method:     method,
+		SQL:        sqlStr,
+		Args:       args,
+		Duration:   elapsed,
+		Err:        err,
+	})
+}
@@ -0,0 +1,275 @@
+package sqflite
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// transactionId of the calls run within the open transaction of their
+// database, whatever its id
+const TRANSACTION_ID_FORCE = -1
+
+// executor runs the statements of a database, on its pool or on the
+// connection of its open transaction
+type executor interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// txBeginner begins the transactions of a batch, on the pool or the reserved
+// connection of a database
+type txBeginner interface {
+	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
+}
+
+// rowQueryer reads a single row, on the pool or the reserved connection of a
+// database
+type rowQueryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// transaction is a transaction open on a database, begun from Dart
+type transaction struct {
+	id int32
+}
+
+// reservedConn is the connection of a database reserved to its open
+// transaction and cursors
+type reservedConn struct {
+	conn *sql.Conn
+	refs int
+}
+
+// connWaiter is an exclusiveConn call waiting for the connection of a
+// database, cancelled once the connection gets reserved
+type connWaiter struct {
+	databaseId int32
+	cancel     context.CancelFunc
+}
+
+// transactionStatement tells if sqlStr starts a transaction, or ends it with
+// COMMIT, END or ROLLBACK. ROLLBACK TO a savepoint does not end it.
+func transactionStatement(sqlStr string) (begin, end bool) {
+	words := strings.Fields(strings.ToUpper(strings.TrimRight(sqlStr, "; \t\r\n")))
+	if len(words) == 0 {
+		return false, false
+	}
+	switch words[0] {
+	case "BEGIN":
+		return true, false
+	case "COMMIT", "END":
+		return false, true
+	case "ROLLBACK":
+		for _, w := range words[1:] {
+			if w == "TO" {
+				return false, false
+			}
+		}
+		return false, true
+	}
+	return false, false
+}
+
+// executor returns the reserved connection of databaseId for the calls made
+// within its open transaction, or db. sqflite 2 clients pass the
+// PARAM_TRANSACTION_ID returned when beginning the transaction, the calls of
+// older clients, without it, all run within the open transaction. The calls
+// given another id run on db, and wait for the end of the transaction.
+func (p *SqflitePlugin) executor(databaseId int32, db *sql.DB, arguments interface{}) executor {
+	p.Lock()
+	defer p.Unlock()
+	reserved, ok := p.reservedConns[databaseId]
+	if !ok {
+		return db
+	}
+	if tx, ok := p.transactions[databaseId]; ok {
+		args, _ := arguments.(map[interface{}]interface{})
+		if id, ok := args[PARAM_TRANSACTION_ID]; ok && id != nil {
+			if id, _ := id.(int32); id != tx.id && id != TRANSACTION_ID_FORCE {
+				return db
+			}
+		}
+	}
+	return reserved.conn
+}
+
+// reserveConn reserves the connection of databaseId, already reserved or
+// taken from db, until released by as many releaseConn calls. The connection
+// being the only one of the database, the calls not made through executor
+// wait for its release.
+func (p *SqflitePlugin) reserveConn(databaseId int32, db *sql.DB) (*sql.Conn, error) {
+	p.Lock()
+	if reserved, ok := p.reservedConns[databaseId]; ok {
+		reserved.refs++
+		p.Unlock()
+		return reserved.conn, nil
+	}
+	p.Unlock()
+	conn, err := db.Conn(p.ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.addReservedConn(databaseId, conn)
+	return conn, nil
+}
+
+// addReservedConn reserves conn, taken from the database databaseId, and
+// fails the exclusiveConn calls waiting for it
+func (p *SqflitePlugin) addReservedConn(databaseId int32, conn *sql.Conn) {
+	p.Lock()
+	defer p.Unlock()
+	p.reservedConns[databaseId] = &reservedConn{conn: conn, refs: 1}
+	for w := range p.connWaiters {
+		if w.databaseId == databaseId {
+			w.cancel()
+		}
+	}
+}
+
+// exclusiveConn takes the connection of databaseId from db, for the
+// statements that cannot run within a transaction, until closed by the
+// caller. It fails at once while the connection is reserved to a transaction
+// or cursor, also when reserved while waiting for it, instead of waiting for
+// their end. what describes the call in the error.
+func (p *SqflitePlugin) exclusiveConn(databaseId int32, db *sql.DB, what string) (*sql.Conn, error) {
+	reservedErr := errors.New("cannot " + what + " while a transaction or cursor is open")
+	ctx, cancel := context.WithCancel(p.ctx)
+	defer cancel()
+	w := &connWaiter{databaseId: databaseId, cancel: cancel}
+	p.Lock()
+	if _, ok := p.reservedConns[databaseId]; ok {
+		p.Unlock()
+		return nil, reservedErr
+	}
+	p.connWaiters[w] = true
+	p.Unlock()
+	conn, err := db.Conn(ctx)
+	p.Lock()
+	delete(p.connWaiters, w)
+	p.Unlock()
+	if err != nil {
+		if p.ctx.Err() == nil && ctx.Err() != nil {
+			return nil, reservedErr
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// releaseConn releases a reservation of the connection of databaseId
+func (p *SqflitePlugin) releaseConn(databaseId int32) {
+	p.Lock()
+	reserved, ok := p.reservedConns[databaseId]
+	if ok {
+		reserved.refs--
+	}
+	if !ok || reserved.refs > 0 {
+		p.Unlock()
+		return
+	}
+	delete(p.reservedConns, databaseId)
+	p.Unlock()
+	reserved.conn.Close()
+}
+
+// beginExecutor returns the executor running the statements of a call
+// atomically: the open transaction of databaseId, on its reserved connection,
+// or a new transaction on the executor of databaseId, returned as tx to be
+// committed by the caller
+func (p *SqflitePlugin) beginExecutor(databaseId int32, db *sql.DB, arguments interface{}) (exec executor, tx *sql.Tx, err error) {
+	exec = p.executor(databaseId, db, arguments)
+	if _, open := p.transactionId(databaseId); open {
+		return exec, nil, nil
+	}
+	if tx, err = exec.(txBeginner).BeginTx(p.ctx, nil); err != nil {
+		return nil, nil, err
+	}
+	return tx, tx, nil
+}
+
+// transactionId returns the id of the open transaction of databaseId
+func (p *SqflitePlugin) transactionId(databaseId int32) (int32, bool) {
+	p.Lock()
+	defer p.Unlock()
+	tx, ok := p.transactions[databaseId]
+	if !ok {
+		return 0, false
+	}
+	return tx.id, true
+}
+
+// beginTransaction runs the BEGIN statement sqlStr on the connection of
+// databaseId, reserved until the transaction ends
+func (p *SqflitePlugin) beginTransaction(databaseId int32, db *sql.DB, sqlStr string, args []interface{}) (sql.Result, error) {
+	conn, err := p.reserveConn(databaseId, db)
+	if err != nil {
+		return nil, err
+	}
+	var result sql.Result
+	err = p.retryBusy(func() (err error) {
+		result, err = conn.ExecContext(p.ctx, sqlStr, args...)
+		return err
+	})
+	if err != nil {
+		p.releaseConn(databaseId)
+		return nil, err
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.lastTransactionId++
+	p.transactions[databaseId] = &transaction{id: p.lastTransactionId}
+	return result, nil
+}
+
+// endTransaction releases the connection reserved to the transaction of
+// databaseId, if any
+func (p *SqflitePlugin) endTransaction(databaseId int32) {
+	p.Lock()
+	_, ok := p.transactions[databaseId]
+	delete(p.transactions, databaseId)
+	p.Unlock()
+	if ok {
+		p.releaseConn(databaseId)
+	}
+}
+
+// execStatement runs a statement of the database databaseId, keeping track of
+// the transactions it begins and ends. PARAM_IN_TRANSACTION, when given, tells
+// if the statement begins or ends a transaction instead of its first keyword.
+func (p *SqflitePlugin) execStatement(databaseId int32, db *sql.DB, arguments interface{}, sqlStr string, args []interface{}) (sql.Result, error) {
+	begin, end := transactionStatement(sqlStr)
+	if m, ok := arguments.(map[interface{}]interface{}); ok {
+		if in, ok := m[PARAM_IN_TRANSACTION].(bool); ok {
+			begin, end = in, !in
+		}
+	}
+	if _, open := p.transactionId(databaseId); begin && !open {
+		return p.beginTransaction(databaseId, db, sqlStr, args)
+	}
+	exec := p.executor(databaseId, db, arguments)
+	var result sql.Result
+	var err error
+	if exec == executor(db) {
+		err = p.retryBusy(func() (err error) {
+			result, err = p.execCached(databaseId, db, sqlStr, args)
+			return err
+		})
+		if err == nil {
+			// pragmas are no-ops within a transaction
+			p.recordPragma(databaseId, sqlStr)
+		}
+	} else {
+		result, err = exec.ExecContext(p.ctx, sqlStr, args...)
+	}
+	if err == nil {
+		p.trackSessionState(databaseId, sqlStr)
+	}
+	// a failed COMMIT leaves the transaction open, to be rolled back
+	if end && (err == nil || strings.HasPrefix(strings.ToUpper(strings.TrimSpace(sqlStr)), "ROLLBACK")) {
+		p.endTransaction(databaseId)
+	}
+	return result, err
+}
@@ -0,0 +1,8 @@
+//go:build go1.15
+// +build go1.15
+
+package sqflite
+
+// embed the time zone database used by tz_convert, desktop systems like
+// Windows have none
+import _ "time/tzdata"
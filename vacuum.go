@@ -0,0 +1,110 @@
+package sqflite
+
+import (
+	"database/sql/driver"
+	"os"
+	"path/filepath"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// first sqlite version supporting VACUUM INTO, as returned by sqlite3.Version
+const vacuumIntoVersion = 3027000
+
+// VacuumResult reports the sizes in bytes of a database and of its compacted
+// copy made by VacuumInto
+type VacuumResult struct {
+	SizeBefore int64 // database file, without its WAL
+	SizeAfter  int64
+}
+
+// VacuumInto writes a compacted copy of the database at dbPath, absolute or
+// relative to the databases folder, to destPath, while the database stays
+// open and in use. It runs VACUUM INTO, or with sqlite older than 3.27,
+// vacuums an online backup of the database. The copy is renamed into place
+// once complete.
+func (p *SqflitePlugin) VacuumInto(dbPath, destPath string) (*VacuumResult, error) {
+	if dbPath == "" || dbPath == MEMORY_DATABASE_PATH {
+		return nil, errors.New("invalid vacuum database path")
+	}
+	if destPath == "" {
+		return nil, errors.New("vacuum path is not set")
+	}
+	dbPath = p.resolvePath(dbPath)
+	if samePath(dbPath, normalizePath(filepath.Clean(destPath))) {
+		return nil, errors.New("cannot vacuum a database into itself")
+	}
+	fi, err := os.Stat(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+		return nil, err
+	}
+	tmp := destPath + ".tmp"
+	os.Remove(tmp)
+	if err = vacuumInto(dbPath, tmp); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	if err = os.Rename(tmp, destPath); err != nil {
+		os.Remove(tmp)
+		return nil, err
+	}
+	result := &VacuumResult{SizeBefore: fi.Size()}
+	if fi, err = os.Stat(destPath); err != nil {
+		return nil, err
+	}
+	result.SizeAfter = fi.Size()
+	return result, nil
+}
+
+// vacuumInto writes the compacted copy of the database at src to dest, which
+// must not exist
+func vacuumInto(src, dest string) error {
+	if _, version, _ := sqlite3.Version(); version < vacuumIntoVersion {
+		if err := backupFile(src, dest); err != nil {
+			return err
+		}
+		conn, err := (&sqlite3.SQLiteDriver{}).Open(fileDSN(dest))
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		_, err = conn.(*sqlite3.SQLiteConn).Exec("VACUUM", nil)
+		return err
+	}
+	conn, err := (&sqlite3.SQLiteDriver{}).Open(fileDSN(src))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.(*sqlite3.SQLiteConn).Exec("VACUUM INTO ?", []driver.Value{dest})
+	return err
+}
+
+// handleVacuumInto writes a compacted copy of the database PARAM_ID to
+// PARAM_PATH, see VacuumInto, and returns {path, sizeBefore, sizeAfter}
+func (p *SqflitePlugin) handleVacuumInto(arguments interface{}) (reply interface{}, err error) {
+	databaseId, _, err := p.getDatabase(arguments)
+	if err != nil {
+		return nil, err
+	}
+	dest, _ := arguments.(map[interface{}]interface{})[PARAM_PATH].(string)
+	p.Lock()
+	dbPath := p.databasePaths[databaseId]
+	p.Unlock()
+	if dbPath == MEMORY_DATABASE_PATH {
+		return nil, errors.New("cannot vacuum a memory database into a file")
+	}
+	result, err := p.VacuumInto(dbPath, dest)
+	if err != nil {
+		return nil, err
+	}
+	return map[interface{}]interface{}{
+		PARAM_PATH:   dest,
+		"sizeBefore": result.SizeBefore,
+		"sizeAfter":  result.SizeAfter,
+	}, nil
+}
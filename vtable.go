@@ -0,0 +1,59 @@
+package sqflite
+
+import (
+	"github.com/pkg/errors"
+)
+
+// VirtualTable is a read-only table whose rows are produced by Go code, made
+// queryable from SQL with a virtual table module, see RegisterVirtualTable.
+type VirtualTable interface {
+	// Columns returns the column names of the table
+	Columns() []string
+	// Open starts a scan of the table
+	Open() (VirtualCursor, error)
+}
+
+// VirtualCursor iterates over the rows of a VirtualTable scan.
+type VirtualCursor interface {
+	// Next returns the next row, one value per column, or io.EOF after the
+	// last row. Values can be nil, int, int64, float64, bool, string, []byte
+	// or time.Time, other values are converted to text.
+	Next() ([]interface{}, error)
+	Close() error
+}
+
+// VirtualTableFactory creates the table of a CREATE VIRTUAL TABLE statement.
+// args are the module arguments, "a" and "b" for
+// CREATE VIRTUAL TABLE temp.t USING module(a, b).
+type VirtualTableFactory func(args []string) (VirtualTable, error)
+
+// RegisterVirtualTable registers a virtual table module on every connection
+// opened afterwards, so SQL sent from Dart can query Go data sources, e.g.
+//
+//	CREATE VIRTUAL TABLE temp.cache USING cache(users);
+//	SELECT * FROM cache WHERE ...;
+//
+// Virtual tables require building with the sqlite_vtable tag.
+func (p *SqflitePlugin) RegisterVirtualTable(module string, factory VirtualTableFactory) error {
+	if !vtableSupported {
+		return errors.New("virtual tables require the sqlite_vtable build tag")
+	}
+	if module == "" || factory == nil {
+		return errors.New("invalid virtual table module")
+	}
+	p.Lock()
+	defer p.Unlock()
+	p.vtables[module] = factory
+	return nil
+}
+
+// virtualTables returns a copy of the registered virtual table modules
+func (p *SqflitePlugin) virtualTables() map[string]VirtualTableFactory {
+	p.Lock()
+	defer p.Unlock()
+	modules := make(map[string]VirtualTableFactory, len(p.vtables))
+	for name, factory := range p.vtables {
+		modules[name] = factory
+	}
+	return modules
+}
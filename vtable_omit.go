@@ -0,0 +1,14 @@
+//go:build !sqlite_vtable && !vtable
+// +build !sqlite_vtable,!vtable
+
+package sqflite
+
+import (
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+const vtableSupported = false
+
+func createModules(conn *sqlite3.SQLiteConn, modules map[string]VirtualTableFactory) error {
+	return nil
+}
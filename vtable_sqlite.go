@@ -0,0 +1,148 @@
+//go:build sqlite_vtable || vtable
+// +build sqlite_vtable vtable
+
+package sqflite
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+const vtableSupported = true
+
+// createModules registers the virtual table modules on conn
+func createModules(conn *sqlite3.SQLiteConn, modules map[string]VirtualTableFactory) error {
+	for name, factory := range modules {
+		if err := conn.CreateModule(name, &vtableModule{factory: factory}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type vtableModule struct {
+	factory VirtualTableFactory
+}
+
+func (m *vtableModule) Create(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
+	// args are the module name, the database name and the table name, then
+	// the module arguments
+	var moduleArgs []string
+	for _, a := range args[3:] {
+		moduleArgs = append(moduleArgs, strings.TrimSpace(a))
+	}
+	table, err := m.factory(moduleArgs)
+	if err != nil {
+		return nil, err
+	}
+	var cols []string
+	for _, col := range table.Columns() {
+		cols = append(cols, quoteIdentifier(col))
+	}
+	if len(cols) == 0 {
+		return nil, fmt.Errorf("virtual table %s has no column", args[2])
+	}
+	if err = c.DeclareVTab("CREATE TABLE x(" + strings.Join(cols, ", ") + ")"); err != nil {
+		return nil, err
+	}
+	return &vtable{table: table}, nil
+}
+
+func (m *vtableModule) Connect(c *sqlite3.SQLiteConn, args []string) (sqlite3.VTab, error) {
+	return m.Create(c, args)
+}
+
+func (m *vtableModule) DestroyModule() {}
+
+type vtable struct {
+	table VirtualTable
+}
+
+// BestIndex uses no constraint, sqlite filters the rows of a full scan
+func (t *vtable) BestIndex(cst []sqlite3.InfoConstraint, ob []sqlite3.InfoOrderBy) (*sqlite3.IndexResult, error) {
+	return &sqlite3.IndexResult{Used: make([]bool, len(cst)), EstimatedCost: 1000000}, nil
+}
+
+func (t *vtable) Disconnect() error { return nil }
+func (t *vtable) Destroy() error    { return nil }
+
+func (t *vtable) Open() (sqlite3.VTabCursor, error) {
+	return &vtableCursor{table: t.table}, nil
+}
+
+type vtableCursor struct {
+	table  VirtualTable
+	cursor VirtualCursor
+	row    []interface{}
+	rowid  int64
+	eof    bool
+}
+
+func (c *vtableCursor) Filter(idxNum int, idxStr string, vals []interface{}) (err error) {
+	if c.cursor != nil {
+		c.cursor.Close()
+	}
+	if c.cursor, err = c.table.Open(); err != nil {
+		return err
+	}
+	c.rowid = 0
+	return c.Next()
+}
+
+func (c *vtableCursor) Next() (err error) {
+	c.row, err = c.cursor.Next()
+	if err == io.EOF {
+		c.eof, c.row = true, nil
+		return nil
+	}
+	c.eof = false
+	c.rowid++
+	return err
+}
+
+func (c *vtableCursor) EOF() bool {
+	return c.eof
+}
+
+func (c *vtableCursor) Column(ctx *sqlite3.SQLiteContext, col int) error {
+	if col >= len(c.row) {
+		ctx.ResultNull()
+		return nil
+	}
+	switch v := c.row[col].(type) {
+	case nil:
+		ctx.ResultNull()
+	case int:
+		ctx.ResultInt(v)
+	case int64:
+		ctx.ResultInt64(v)
+	case float64:
+		ctx.ResultDouble(v)
+	case bool:
+		ctx.ResultBool(v)
+	case string:
+		ctx.ResultText(v)
+	case []byte:
+		ctx.ResultBlob(v)
+	case time.Time:
+		ctx.ResultText(v.Format(time.RFC3339Nano))
+	default:
+		ctx.ResultText(fmt.Sprint(v))
+	}
+	return nil
+}
+
+func (c *vtableCursor) Rowid() (int64, error) {
+	return c.rowid, nil
+}
+
+func (c *vtableCursor) Close() error {
+	if c.cursor == nil {
+		return nil
+	}
+	return c.cursor.Close()
+}
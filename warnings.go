@@ -0,0 +1,134 @@
+package sqflite
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+)
+
+// name of the event channel of the non-fatal warnings
+const warningChannelName = channelName + "/warnings"
+
+// kinds of the non-fatal warnings
+const (
+	WARNING_CLOUD_FOLDER    = "cloudFolder"    // database in a cloud-synced folder
+	WARNING_WAL_SIZE        = "walSize"        // WAL file above the size threshold
+	WARNING_SLOW_QUERY      = "slowQuery"      // call above the duration threshold
+	WARNING_BUSY            = "busy"           // call failed on a locked database
+	WARNING_WRITE_THROTTLED = "writeThrottled" // write rate limit reached
+	WARNING_OPEN_CONFLICT   = "openConflict"   // path reopened with another readOnly
+	WARNING_REPAIRED        = "repaired"       // corrupt database repaired on open
+	WARNING_NOT_ATOMIC      = "notAtomic"      // attached database not committed atomically
+)
+
+// default warning thresholds
+const (
+	defaultSlowQueryThreshold = 500 * time.Millisecond
+	defaultWALSizeThreshold   = 64 << 20
+)
+
+// folders synchronized by cloud storage clients, where the journal files of a
+// database may be uploaded out of order
+var cloudFolders = []string{
+	"dropbox", "onedrive", "google drive", "googledrive", "icloud drive",
+	"iclouddrive", "mobile documents", "box sync", "nextcloud", "owncloud",
+}
+
+// SetWarningThresholds sets the duration above which a call is reported as a
+// slow query, and the size above which a WAL file is reported. Zero disables
+// the warning.
+func (p *SqflitePlugin) SetWarningThresholds(slowQuery time.Duration, walSize int64) {
+	p.Lock()
+	defer p.Unlock()
+	p.slowQueryThreshold = slowQuery
+	p.walSizeThreshold = walSize
+}
+
+// warn logs a non-fatal diagnostic and sends it to the Dart side listening on
+// the warnings event channel
+func (p *SqflitePlugin) warn(kind, dbPath, message string) {
+	p.logger.Warnf("%s", message)
+	p.Lock()
+	warnings := p.warnings
+	p.Unlock()
+	warnings.send(map[interface{}]interface{}{
+		"kind":              kind,
+		PARAM_PATH:          dbPath,
+		PARAM_ERROR_MESSAGE: message,
+		"time":              time.Now().UnixNano() / int64(time.Millisecond),
+	})
+}
+
+// watchCall wraps the handler of method to report slow calls, locked databases
+// and WAL files growing beyond the threshold
+func (p *SqflitePlugin) watchCall(method string, handler func(arguments interface{}) (interface{}, error)) func(arguments interface{}) (interface{}, error) {
+	return func(arguments interface{}) (interface{}, error) {
+		start := time.Now()
+		reply, err := handler(arguments)
+		elapsed := time.Since(start)
+
+		args, ok := arguments.(map[interface{}]interface{})
+		if !ok {
+			return reply, err
+		}
+		id, ok := args[PARAM_ID].(int32)
+		if !ok {
+			return reply, err
+		}
+		p.Lock()
+		dbPath := p.databasePaths[id]
+		slowQuery, walSize := p.slowQueryThreshold, p.walSizeThreshold
+		p.Unlock()
+		sqlStr, _ := args[PARAM_SQL].(string)
+		if slowQuery > 0 && elapsed > slowQuery {
+			p.countSlowQuery()
+			p.warn(WARNING_SLOW_QUERY, dbPath, fmt.Sprintf("slow %s on %s took %v: %s", method, dbPath, elapsed, sqlStr))
+		}
+		if sqliteErr, ok := errors.Cause(err).(sqlite3.Error); ok && (sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked) {
+			p.warn(WARNING_BUSY, dbPath, fmt.Sprintf("%s on %s failed, database is locked by another connection", method, dbPath))
+		}
+		if walSize > 0 && dbPath != "" && dbPath != MEMORY_DATABASE_PATH {
+			p.checkWALSize(dbPath, walSize)
+		}
+		return reply, err
+	}
+}
+
+// checkWALSize warns once when the WAL file of dbPath grows beyond threshold,
+// and again after it was checkpointed below it
+func (p *SqflitePlugin) checkWALSize(dbPath string, threshold int64) {
+	info, err := os.Stat(dbPath + "-wal")
+	above := err == nil && info.Size() > threshold
+	p.Lock()
+	warned := p.walWarned[dbPath]
+	if above != warned {
+		p.walWarned[dbPath] = above
+	}
+	p.Unlock()
+	if above && !warned {
+		p.warn(WARNING_WAL_SIZE, dbPath, fmt.Sprintf("WAL file of %s grew to %d bytes, check that it gets checkpointed", dbPath, info.Size()))
+	}
+}
+
+// inCloudFolder tells if dbPath is in a folder synchronized by a cloud
+// storage client
+func inCloudFolder(dbPath string) bool {
+	abs, err := filepath.Abs(dbPath)
+	if err != nil {
+		return false
+	}
+	for _, part := range strings.Split(filepath.ToSlash(filepath.Dir(abs)), "/") {
+		part = strings.ToLower(part)
+		for _, folder := range cloudFolders {
+			if strings.HasPrefix(part, folder) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,172 @@
+package sqflite
+
+import "github.com/pkg/errors"
+
+// name of the event channel of the results of the watched queries
+const watchChannelName = channelName + "/watch"
+
+// queryWatch is a query run again each time a transaction changing one of its
+// tables commits, its results being sent on the watch event channel
+type queryWatch struct {
+	id         int32
+	databaseId int32
+	sql        string
+	args       []interface{}
+	tables     map[string]bool // tables of the main schema read, nil when unknown
+	dirty      chan struct{}   // signaled when the results may have changed
+	stop       chan struct{}
+}
+
+// handleWatchQuery watches the query PARAM_SQL with its PARAM_SQL_ARGUMENTS,
+// and returns {watchId}. Its results are sent as {watchId, result: {columns,
+// rows}}, or {watchId, error} when it fails, right away and after each
+// committed transaction changing the tables it reads. A query whose tables
+// cannot be found, such as one reading attached databases or calling random(),
+// runs again after every transaction of the database.
+func (p *SqflitePlugin) handleWatchQuery(arguments interface{}) (reply interface{}, err error) {
+	databaseId, db, err := p.getDatabase(arguments)
+	if err != nil {
+		return nil, err
+	}
+	sqlStr, args, err := p.getSqlCommand(arguments)
+	if err != nil {
+		return nil, err
+	}
+	if !readStatement(sqlStr) {
+		return nil, errors.New("only a SELECT statement can be watched")
+	}
+	w := &queryWatch{
+		databaseId: databaseId,
+		sql:        sqlStr,
+		args:       args,
+		dirty:      make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+	}
+	if tables, ok := newQueryCache(0).tablesOf(p.ctx, p.executor(databaseId, db, arguments), sqlStr, args); ok {
+		w.tables = make(map[string]bool)
+		for _, table := range tables {
+			w.tables[table] = true
+		}
+	}
+	p.Lock()
+	p.lastWatchId++
+	w.id = p.lastWatchId
+	p.watches[w.id] = w
+	p.Unlock()
+	w.dirty <- struct{}{}
+	go p.runWatch(w)
+	return map[interface{}]interface{}{
+		PARAM_WATCH_ID: w.id,
+	}, nil
+}
+
+// handleUnwatchQuery stops watching the query PARAM_WATCH_ID
+func (p *SqflitePlugin) handleUnwatchQuery(arguments interface{}) (reply interface{}, err error) {
+	args, ok := arguments.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("invalid arguments")
+	}
+	id, ok := args[PARAM_WATCH_ID].(int32)
+	if !ok {
+		return nil, errors.New("invalid watchId")
+	}
+	p.unwatch(id)
+	return nil, nil
+}
+
+func (p *SqflitePlugin) unwatch(id int32) {
+	p.Lock()
+	defer p.Unlock()
+	if w, ok := p.watches[id]; ok {
+		close(w.stop)
+		delete(p.watches, id)
+	}
+}
+
+// unwatchDatabase stops watching the queries of databaseId
+func (p *SqflitePlugin) unwatchDatabase(databaseId int32) {
+	p.Lock()
+	defer p.Unlock()
+	for id, w := range p.watches {
+		if w.databaseId == databaseId {
+			close(w.stop)
+			delete(p.watches, id)
+		}
+	}
+}
+
+// notifyWatches marks the queries of databaseId reading one of the changed
+// tables of the main schema, or any table for others, as dirty
+func (p *SqflitePlugin) notifyWatches(databaseId int32, changes []change) {
+	p.Lock()
+	defer p.Unlock()
+	for _, w := range p.watches {
+		if w.databaseId != databaseId || !w.reads(changes) {
+			continue
+		}
+		select {
+		case w.dirty <- struct{}{}:
+		default:
+			// already dirty
+		}
+	}
+}
+
+// reads tells if the results of w may depend on changes
+func (w *queryWatch) reads(changes []change) bool {
+	if w.tables == nil {
+		return true
+	}
+	for _, c := range changes {
+		if c.schema != "main" || w.tables[c.table] {
+			return true
+		}
+	}
+	return false
+}
+
+// runWatch runs the query of w each time it is dirty, until it is stopped.
+// A query run while the database is in a transaction waits for its end.
+func (p *SqflitePlugin) runWatch(w *queryWatch) {
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-p.ctx.Done():
+			return
+		case <-w.dirty:
+		}
+		event := map[interface{}]interface{}{
+			PARAM_WATCH_ID: w.id,
+		}
+		result, err := p.runWatchQuery(w)
+		if err != nil {
+			event[PARAM_ERROR] = batchError(err, w.sql, w.args)
+		} else {
+			event[PARAM_RESULT] = result
+		}
+		select {
+		case <-w.stop:
+			return
+		default:
+		}
+		p.Lock()
+		watchEvents := p.watchEvents
+		p.Unlock()
+		watchEvents.send(event)
+	}
+}
+
+func (p *SqflitePlugin) runWatchQuery(w *queryWatch) (result interface{}, err error) {
+	p.Lock()
+	db := p.databases[w.databaseId]
+	p.Unlock()
+	if db == nil {
+		return nil, errors.Errorf("database %d is closed", w.databaseId)
+	}
+	err = p.retryBusy(func() error {
+		result, err = readQuery(p.queryRead(w.databaseId, db, w.sql, w.args))
+		return err
+	})
+	return result, err
+}
@@ -0,0 +1,36 @@
+package sqflite
+
+import (
+	"testing"
+)
+
+// TestWatchQueryWithReservedConn checks that watchQuery finds the tables of
+// the query on the connection reserved to an open transaction or cursor
+// instead of waiting for it
+func TestWatchQueryWithReservedConn(t *testing.T) {
+	for _, kind := range reservedConnKinds {
+		t.Run(kind, func(t *testing.T) {
+			p := newTestPlugin(t)
+			id := openTestDatabase(t, p, "watch.db", nil)
+			mustCall(t, p.handleExecute, sqlArgs(id, "CREATE TABLE t (id INTEGER PRIMARY KEY, x)"))
+
+			release := reserveTestConn(t, p, id, kind)
+			defer release()
+			var reply interface{}
+			if err := callWithin(t, "watchQuery", func() (err error) {
+				reply, err = p.handleWatchQuery(sqlArgs(id, "SELECT x FROM t"))
+				return err
+			}); err != nil {
+				t.Fatal(err)
+			}
+			watchId := reply.(map[interface{}]interface{})[PARAM_WATCH_ID].(int32)
+			defer p.unwatch(watchId)
+			p.Lock()
+			w := p.watches[watchId]
+			p.Unlock()
+			if !w.tables["t"] {
+				t.Errorf("watched tables = %v, want t", w.tables)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package sqflite
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// handleWipeSubject deletes every row referencing PARAM_SUBJECT in the tables
+// given by PARAM_TABLES ({table: column} or {table: [columns]}), with
+// secure_delete enabled so the deleted content is overwritten, then vacuums
+// the database and truncates the WAL so no copy is left on disk. The returned
+// report can be kept as a compliance record. It fails while a transaction or
+// cursor is open, which VACUUM cannot run along.
+func (p *SqflitePlugin) handleWipeSubject(arguments interface{}) (reply interface{}, err error) {
+	databaseId, db, err := p.getDatabase(arguments)
+	if err != nil {
+		return nil, err
+	}
+	args := arguments.(map[interface{}]interface{})
+	subject, ok := args[PARAM_SUBJECT]
+	if !ok || subject == nil {
+		return nil, errors.New("subject is not set")
+	}
+	tables, err := getTableColumns(args[PARAM_TABLES])
+	if err != nil {
+		return nil, err
+	}
+
+	ctx := p.ctx
+	// secure_delete is a per connection setting
+	conn, err := p.exclusiveConn(databaseId, db, "wipe a subject")
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	var secureDelete int64
+	if err = conn.QueryRowContext(ctx, "PRAGMA secure_delete").Scan(&secureDelete); err != nil {
+		return nil, err
+	}
+	if _, err = conn.ExecContext(ctx, "PRAGMA secure_delete = ON"); err != nil {
+		return nil, err
+	}
+	defer conn.ExecContext(ctx, fmt.Sprintf("PRAGMA secure_delete = %d", secureDelete))
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	deleted := make(map[interface{}]interface{})
+	var total int64
+	for table, columns := range tables {
+		var where []string
+		var whereArgs []interface{}
+		for _, c := range columns {
+			where = append(where, quoteIdentifier(c)+" = ?")
+			whereArgs = append(whereArgs, subject)
+		}
+		res, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE %s", quoteIdentifier(table), strings.Join(where, " OR ")), whereArgs...)
+		if err != nil {
+			tx.Rollback()
+			return nil, errors.Wrap(err, "failed to wipe "+table)
+		}
+		n, _ := res.RowsAffected()
+		deleted[table] = n
+		total += n
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	if _, err = conn.ExecContext(ctx, "VACUUM"); err != nil {
+		return nil, errors.Wrap(err, "rows deleted but vacuum failed")
+	}
+	var busy, walFrames, checkpointed int64
+	if err = conn.QueryRowContext(ctx, "PRAGMA wal_checkpoint(TRUNCATE)").Scan(&busy, &walFrames, &checkpointed); err != nil {
+		return nil, err
+	}
+	return map[interface{}]interface{}{
+		"deleted":      deleted,
+		"total":        total,
+		"secureDelete": true,
+		"vacuumed":     true,
+		"walTruncated": busy == 0,
+		"time":         time.Now().UnixNano() / int64(time.Millisecond),
+	}, nil
+}
+
+// getTableColumns decodes a map of table to a column name or a list of column names
+func getTableColumns(arg interface{}) (map[string][]string, error) {
+	m, ok := arg.(map[interface{}]interface{})
+	if !ok || len(m) == 0 {
+		return nil, errors.New("invalid tables")
+	}
+	tables := make(map[string][]string)
+	for t, c := range m {
+		table, ok := t.(string)
+		if !ok {
+			return nil, errors.New("invalid table name")
+		}
+		switch cols := c.(type) {
+		case string:
+			tables[table] = []string{cols}
+		case []interface{}:
+			for _, col := range cols {
+				name, ok := col.(string)
+				if !ok {
+					return nil, errors.New("invalid column for " + table)
+				}
+				tables[table] = append(tables[table], name)
+			}
+		}
+		if len(tables[table]) == 0 {
+			return nil, errors.New("no column given for " + table)
+		}
+	}
+	return tables, nil
+}
@@ -0,0 +1,76 @@
+package sqflite
+
+import (
+	"testing"
+	"time"
+)
+
+// newWipeTestDatabase opens wipe.db with a table t of the rows of alice and
+// bob, and returns its id and the arguments wiping alice
+func newWipeTestDatabase(t *testing.T, p *SqflitePlugin) (int32, map[interface{}]interface{}) {
+	t.Helper()
+	id := openTestDatabase(t, p, "wipe.db", nil)
+	mustCall(t, p.handleExecute, sqlArgs(id, "CREATE TABLE t (user, x)"))
+	mustCall(t, p.handleInsert, sqlArgs(id, "INSERT INTO t VALUES ('alice', 1), ('bob', 2)"))
+	return id, map[interface{}]interface{}{
+		PARAM_ID:      id,
+		PARAM_SUBJECT: "alice",
+		PARAM_TABLES:  map[interface{}]interface{}{"t": "user"},
+	}
+}
+
+func TestWipeSubjectWithReservedConn(t *testing.T) {
+	for _, kind := range reservedConnKinds {
+		t.Run(kind, func(t *testing.T) {
+			p := newTestPlugin(t)
+			id, wipe := newWipeTestDatabase(t, p)
+
+			release := reserveTestConn(t, p, id, kind)
+			if err := callWithin(t, "wipeSubject", func() error {
+				_, err := p.handleWipeSubject(wipe)
+				return err
+			}); err == nil {
+				t.Error("wiped while the connection is reserved")
+			}
+			release()
+
+			reply := mustCall(t, p.handleWipeSubject, wipe).(map[interface{}]interface{})
+			if reply["total"] != int64(1) {
+				t.Errorf("total = %v, want 1", reply["total"])
+			}
+			if v := queryValue(t, p, id, "SELECT count(*) FROM t"); v != int64(1) {
+				t.Errorf("count = %v, want 1", v)
+			}
+		})
+	}
+}
+
+// TestWipeSubjectReservedWhileWaiting checks that wipeSubject fails when the
+// connection it waits for gets reserved to a transaction or cursor
+func TestWipeSubjectReservedWhileWaiting(t *testing.T) {
+	p := newTestPlugin(t)
+	id, wipe := newWipeTestDatabase(t, p)
+	p.Lock()
+	db := p.databases[id]
+	p.Unlock()
+	// a transaction begun before the wipe, not reserved yet
+	conn, err := db.Conn(p.ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := p.handleWipeSubject(wipe)
+		done <- err
+	}()
+	for waiting := false; !waiting; time.Sleep(time.Millisecond) {
+		p.Lock()
+		waiting = len(p.connWaiters) > 0
+		p.Unlock()
+	}
+	p.addReservedConn(id, conn)
+	if err = callWithin(t, "wipeSubject", func() error { return <-done }); err == nil {
+		t.Error("wiped while the connection is reserved")
+	}
+	p.releaseConn(id)
+}